@@ -0,0 +1,373 @@
+package datasources
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vingarcia/insights"
+	"github.com/vingarcia/insights/internal"
+)
+
+// CommonLogFormat is the Common Log Format used by Apache and
+// most other web servers, written as an Nginx log_format string
+const CommonLogFormat = `$remote_addr - $remote_user [$time_local] "$request" $status $body_bytes_sent`
+
+// CombinedLogFormat is the Combined Log Format used by Apache,
+// which is also the default log format of Nginx
+const CombinedLogFormat = CommonLogFormat + ` "$http_referer" "$http_user_agent"`
+
+// NewAccessLogSource returns a data source reading web server access
+// logs written with the given format from the files matching the
+// pattern, see NewAccessLogDecoder and NewFileSource
+func NewAccessLogSource(name string, pattern string, format string) (internal.DataSource, error) {
+	decode, err := NewAccessLogDecoder(format)
+	if err != nil {
+		return internal.DataSource{}, err
+	}
+
+	return NewFileSource(name, "accesslog", pattern, decode), nil
+}
+
+// NewAccessLogDecoder returns a LineDecoder for access logs written
+// with an Nginx log_format string, e.g. CombinedLogFormat, without
+// the quotes used for it on the Nginx configuration.
+//
+// Each variable becomes a field named after it, e.g. `$remote_addr`
+// becomes `remote_addr`, and values that are just "-" become null.
+// Some well known variables are also renamed or typed:
+//
+//   - `$status` is an int
+//   - `$body_bytes_sent` becomes `bytes`, an int where "-" means 0
+//   - `$bytes_sent` and `$request_length` are ints
+//   - `$request_time` is a float
+//   - `$time_local` and `$time_iso8601` become `time`, an RFC3339
+//     timestamp converted to UTC, always with 9 decimal places so
+//     the timestamps also sort correctly as strings
+//   - `$request` is kept and also split into `method`, `path` and
+//     `protocol`, where `path` includes the query string
+func NewAccessLogDecoder(format string) (LineDecoder, error) {
+	segments, err := parseAccessLogFormat(format)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(line []byte) (json.RawMessage, error) {
+		return decodeAccessLog(segments, string(line))
+	}, nil
+}
+
+// accessLogSegment is either a literal text
+// or a variable of an access log format
+type accessLogSegment struct {
+	literal  string
+	variable string
+	field    accessLogField
+}
+
+type accessLogField struct {
+	name   string
+	decode func(value string) (json.RawMessage, error)
+}
+
+var accessLogFields = map[string]accessLogField{
+	"status":          {name: "status", decode: decodeAccessLogInt},
+	"body_bytes_sent": {name: "bytes", decode: decodeAccessLogBytes},
+	"bytes_sent":      {name: "bytes_sent", decode: decodeAccessLogBytes},
+	"request_length":  {name: "request_length", decode: decodeAccessLogInt},
+	"request_time":    {name: "request_time", decode: decodeAccessLogFloat},
+	"time_local":      {name: "time", decode: newAccessLogTimeDecoder("02/Jan/2006:15:04:05 -0700")},
+	"time_iso8601":    {name: "time", decode: newAccessLogTimeDecoder(time.RFC3339)},
+}
+
+// accessLogTimeLayout is a fixed width version of time.RFC3339Nano,
+// which would trim the trailing zeros of the fractional seconds
+const accessLogTimeLayout = "2006-01-02T15:04:05.000000000Z07:00"
+
+// requestFields are the fields created by splitting `$request`
+var requestFields = []string{"method", "path", "protocol"}
+
+func parseAccessLogFormat(format string) ([]accessLogSegment, error) {
+	var segments []accessLogSegment
+	fieldNames := map[string]bool{}
+	for i := 0; i < len(format); {
+		if format[i] != '$' {
+			end := strings.IndexByte(format[i:], '$')
+			if end < 0 {
+				end = len(format) - i
+			}
+			segments = append(segments, accessLogSegment{literal: format[i : i+end]})
+			i += end
+			continue
+		}
+
+		variable, size := readAccessLogVariable(format[i:])
+		if variable == "" {
+			return nil, insights.SyntaxErr("expected a variable name after `$` on log format", map[string]any{
+				"position": i,
+				"format":   format,
+			})
+		}
+		i += size
+
+		if len(segments) > 0 && segments[len(segments)-1].variable != "" {
+			return nil, insights.SyntaxErr("variables must be separated by some text on log format", map[string]any{
+				"variables": []string{segments[len(segments)-1].variable, variable},
+				"format":    format,
+			})
+		}
+
+		field, ok := accessLogFields[variable]
+		if !ok {
+			field = accessLogField{name: variable, decode: decodeAccessLogString}
+		}
+
+		names := []string{field.name}
+		if variable == "request" {
+			names = append(names, requestFields...)
+		}
+		for _, name := range names {
+			if fieldNames[name] {
+				return nil, insights.SyntaxErr("duplicate field on log format", map[string]any{
+					"field":  name,
+					"format": format,
+				})
+			}
+			fieldNames[name] = true
+		}
+
+		segments = append(segments, accessLogSegment{
+			variable: variable,
+			field:    field,
+		})
+	}
+
+	return segments, nil
+}
+
+// readAccessLogVariable reads a variable such as `$status` or
+// `${status}` returning its name and its size on the format
+func readAccessLogVariable(s string) (name string, size int) {
+	if strings.HasPrefix(s, "${") {
+		end := strings.IndexByte(s, '}')
+		if end < 0 {
+			return "", 0
+		}
+		return s[2:end], end + 1
+	}
+
+	end := 1
+	for end < len(s) && isAccessLogVariableChar(s[end]) {
+		end++
+	}
+	return s[1:end], end
+}
+
+func isAccessLogVariableChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+func decodeAccessLog(segments []accessLogSegment, line string) (json.RawMessage, error) {
+	b := []byte{'{'}
+	pos := 0
+	for i, segment := range segments {
+		if segment.variable == "" {
+			if !strings.HasPrefix(line[pos:], segment.literal) {
+				return nil, insights.RuntimeErr("line doesn't match the log format", map[string]any{
+					"expected": segment.literal,
+					"position": pos,
+					"line":     truncate([]byte(line)),
+				})
+			}
+			pos += len(segment.literal)
+			continue
+		}
+
+		// Variables end where the next literal starts, which
+		// is found skipping escaped characters, e.g. `\"`:
+		end := len(line)
+		if i+1 < len(segments) {
+			next := segments[i+1].literal
+			idx := indexUnescaped(line[pos:], next)
+			if idx < 0 {
+				return nil, insights.RuntimeErr("line doesn't match the log format", map[string]any{
+					"expected": next,
+					"position": pos,
+					"line":     truncate([]byte(line)),
+				})
+			}
+			end = pos + idx
+		}
+		value := line[pos:end]
+		pos = end
+
+		rawValue, err := segment.field.decode(value)
+		if err != nil {
+			return nil, insights.RuntimeErr("invalid value on access log", map[string]any{
+				"field": segment.field.name,
+				"value": value,
+				"error": err,
+			})
+		}
+		b = appendJSONField(b, segment.field.name, rawValue)
+
+		if segment.variable == "request" && value != "-" {
+			// Requests are only split when they are well formed,
+			// i.e. `GET /path HTTP/1.1` or `GET /path` for HTTP/0.9:
+			parts := strings.Fields(unescapeAccessLogValue(value))
+			if len(parts) >= 2 && len(parts) <= len(requestFields) {
+				for j, part := range parts {
+					rawPart, _ := json.Marshal(part)
+					b = appendJSONField(b, requestFields[j], rawPart)
+				}
+			}
+		}
+	}
+
+	if pos < len(line) {
+		return nil, insights.RuntimeErr("unexpected text after the end of the log format", map[string]any{
+			"position": pos,
+			"line":     truncate([]byte(line)),
+		})
+	}
+
+	return append(b, '}'), nil
+}
+
+func appendJSONField(b []byte, name string, rawValue json.RawMessage) []byte {
+	if len(b) > 1 {
+		b = append(b, ',')
+	}
+	rawName, _ := json.Marshal(name)
+	b = append(b, rawName...)
+	b = append(b, ':')
+	return append(b, rawValue...)
+}
+
+// indexUnescaped works like strings.Index
+// but ignores matches starting with `\`
+func indexUnescaped(s string, substr string) int {
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' {
+			i++
+			continue
+		}
+		if strings.HasPrefix(s[i:], substr) {
+			return i
+		}
+	}
+	return -1
+}
+
+// unescapeAccessLogValue decodes the escape sequences used by
+// Apache and Nginx, e.g. `\"` and `\x22`, unknown sequences
+// are kept as they are
+func unescapeAccessLogValue(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			sb.WriteByte(s[i])
+			continue
+		}
+
+		switch s[i+1] {
+		case '"', '\\':
+			sb.WriteByte(s[i+1])
+			i++
+		case 'n':
+			sb.WriteByte('\n')
+			i++
+		case 't':
+			sb.WriteByte('\t')
+			i++
+		case 'r':
+			sb.WriteByte('\r')
+			i++
+		case 'x':
+			if i+3 < len(s) {
+				c, err := strconv.ParseUint(s[i+2:i+4], 16, 8)
+				if err == nil {
+					sb.WriteByte(byte(c))
+					i += 3
+					continue
+				}
+			}
+			sb.WriteByte(s[i])
+		default:
+			sb.WriteByte(s[i])
+		}
+	}
+
+	return sb.String()
+}
+
+func decodeAccessLogString(value string) (json.RawMessage, error) {
+	if value == "-" {
+		return json.RawMessage("null"), nil
+	}
+
+	rawValue, _ := json.Marshal(unescapeAccessLogValue(value))
+	return rawValue, nil
+}
+
+func decodeAccessLogInt(value string) (json.RawMessage, error) {
+	if value == "-" {
+		return json.RawMessage("null"), nil
+	}
+
+	i, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	return strconv.AppendInt(nil, i, 10), nil
+}
+
+// decodeAccessLogBytes decodes byte counts, which
+// are logged as "-" when no bytes were sent
+func decodeAccessLogBytes(value string) (json.RawMessage, error) {
+	if value == "-" {
+		return json.RawMessage("0"), nil
+	}
+
+	return decodeAccessLogInt(value)
+}
+
+func decodeAccessLogFloat(value string) (json.RawMessage, error) {
+	if value == "-" {
+		return json.RawMessage("null"), nil
+	}
+
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return nil, err
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil, fmt.Errorf("expected a finite number")
+	}
+
+	return strconv.AppendFloat(nil, f, 'g', -1, 64), nil
+}
+
+func newAccessLogTimeDecoder(layout string) func(value string) (json.RawMessage, error) {
+	return func(value string) (json.RawMessage, error) {
+		if value == "-" {
+			return json.RawMessage("null"), nil
+		}
+
+		t, err := time.Parse(layout, value)
+		if err != nil {
+			return nil, err
+		}
+
+		rawValue, _ := json.Marshal(t.UTC().Format(accessLogTimeLayout))
+		return rawValue, nil
+	}
+}
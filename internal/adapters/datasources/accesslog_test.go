@@ -0,0 +1,154 @@
+package datasources
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/vingarcia/insights/internal/adapters/evaluator/eparser"
+	tt "github.com/vingarcia/insights/internal/testtools"
+)
+
+func TestAccessLogDecoder(t *testing.T) {
+	tests := []struct {
+		desc               string
+		format             string
+		line               string
+		expectedRecord     string
+		expectErrToContain []string
+	}{
+		{
+			desc:   "should decode the common log format",
+			format: CommonLogFormat,
+			line:   `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif?a=1 HTTP/1.0" 200 2326`,
+			expectedRecord: `{"remote_addr":"127.0.0.1","remote_user":"frank","time":"2000-10-10T20:55:36.000000000Z",` +
+				`"request":"GET /apache_pb.gif?a=1 HTTP/1.0","method":"GET","path":"/apache_pb.gif?a=1","protocol":"HTTP/1.0",` +
+				`"status":200,"bytes":2326}`,
+		},
+		{
+			desc:   "should decode the combined log format with escaped quotes",
+			format: CombinedLogFormat,
+			line:   `::1 - - [10/Oct/2000:13:55:36 +0000] "POST /api/users HTTP/1.1" 503 - "-" "curl \"7.0\" \x22x\x22"`,
+			expectedRecord: `{"remote_addr":"::1","remote_user":null,"time":"2000-10-10T13:55:36.000000000Z",` +
+				`"request":"POST /api/users HTTP/1.1","method":"POST","path":"/api/users","protocol":"HTTP/1.1",` +
+				`"status":503,"bytes":0,"http_referer":null,"http_user_agent":"curl \"7.0\" \"x\""}`,
+		},
+		{
+			desc:           "should not split malformed requests",
+			format:         `"$request" $status`,
+			line:           `"\x16\x03\x01" 400`,
+			expectedRecord: `{"request":"\u0016\u0003\u0001","status":400}`,
+		},
+		{
+			desc:           "should decode custom Nginx formats",
+			format:         `$time_iso8601|${host}|$request_time|$upstream_response_time|$bytes_sent`,
+			line:           `2024-03-10T14:00:10+02:00|example.com|0.250|0.1, 0.2|512`,
+			expectedRecord: `{"time":"2024-03-10T12:00:10.000000000Z","host":"example.com","request_time":0.25,"upstream_response_time":"0.1, 0.2","bytes_sent":512}`,
+		},
+		{
+			desc:           "should keep the fractional seconds with a fixed width",
+			format:         `$time_iso8601`,
+			line:           `2024-03-10T14:00:10.5-03:00`,
+			expectedRecord: `{"time":"2024-03-10T17:00:10.500000000Z"}`,
+		},
+		{
+			desc:               "should reject lines that don't match the literals",
+			format:             CommonLogFormat,
+			line:               `127.0.0.1 frank [10/Oct/2000:13:55:36 -0700] "GET / HTTP/1.0" 200 2326`,
+			expectErrToContain: []string{"line doesn't match the log format", "expected =  - ", "position = 0"},
+		},
+		{
+			desc:               "should reject lines with extra text",
+			format:             `$status `,
+			line:               `200 extra`,
+			expectErrToContain: []string{"unexpected text after the end of the log format"},
+		},
+		{
+			desc:               "should reject invalid typed values",
+			format:             `$status $time_local`,
+			line:               `OK 10/Oct/2000`,
+			expectErrToContain: []string{"invalid value on access log", "status", "OK"},
+		},
+		{
+			desc:               "should reject invalid timestamps",
+			format:             `$status [$time_local]`,
+			line:               `200 [10/Oct/2000]`,
+			expectErrToContain: []string{"invalid value on access log", "time"},
+		},
+		{
+			desc:               "should reject non finite floats",
+			format:             `$request_time`,
+			line:               `NaN`,
+			expectErrToContain: []string{"invalid value on access log", "finite"},
+		},
+		{
+			desc:               "should reject variables without a separator",
+			format:             `$status$bytes_sent`,
+			expectErrToContain: []string{"SyntaxErr", "must be separated", "status", "bytes_sent"},
+		},
+		{
+			desc:               "should reject fields with the same name",
+			format:             `$time_local $time_iso8601`,
+			expectErrToContain: []string{"SyntaxErr", "duplicate field", "time"},
+		},
+		{
+			desc:               "should reject fields colliding with the request fields",
+			format:             `$path "$request"`,
+			expectErrToContain: []string{"SyntaxErr", "duplicate field", "path"},
+		},
+		{
+			desc:               "should reject `$` without a variable name",
+			format:             `$status $ ${}`,
+			expectErrToContain: []string{"SyntaxErr", "expected a variable name", "position = 8"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			decode, err := NewAccessLogDecoder(test.format)
+			if err == nil {
+				var record []byte
+				record, err = decode([]byte(test.line))
+				if err == nil {
+					tt.AssertEqual(t, string(record), test.expectedRecord)
+				}
+			}
+
+			if test.expectErrToContain != nil {
+				tt.AssertErrContains(t, err, test.expectErrToContain...)
+				return
+			}
+			tt.AssertNoErr(t, err)
+		})
+	}
+}
+
+func TestAccessLogSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	writeFile(t, path, []byte(
+		`10.0.0.1 - - [10/Mar/2024:14:00:10 +0000] "GET /api/users HTTP/1.1" 502 157 "-" "curl/8.0"`+"\n"+
+			`10.0.0.2 - - [10/Mar/2024:14:00:11 +0000] "GET /index.html HTTP/1.1" 500 157 "-" "curl/8.0"`+"\n"+
+			`10.0.0.3 - - [10/Mar/2024:14:00:12 +0000] "GET /api/orders HTTP/1.1" 200 1024 "-" "curl/8.0"`+"\n",
+	), 0)
+
+	source, err := NewAccessLogSource("access", path, CombinedLogFormat)
+	tt.AssertNoErr(t, err)
+
+	records := readAll(t, source)
+	tt.AssertEqual(t, len(records), 3)
+
+	expr, err := eparser.Parse(`status >= 500 && starts_with(path, "/api")`)
+	tt.AssertNoErr(t, err)
+
+	var matches []bool
+	for _, record := range records {
+		tt.AssertNoErr(t, record.Err)
+
+		match, err := expr.Evaluate(record.Raw)
+		tt.AssertNoErr(t, err)
+		matches = append(matches, match)
+	}
+	tt.AssertEqual(t, matches, []bool{true, false, false})
+
+	_, err = NewAccessLogSource("access", path, `$status$status`)
+	tt.AssertErrContains(t, err, "SyntaxErr", "must be separated")
+}
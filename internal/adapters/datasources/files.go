@@ -0,0 +1,295 @@
+package datasources
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/vingarcia/insights"
+	"github.com/vingarcia/insights/internal"
+)
+
+// LineDecoder converts a line of a file into a record encoded as a
+// JSON object, errors are reported as errors of that record only
+type LineDecoder func(line []byte) (json.RawMessage, error)
+
+// NewNDJSONSource returns a data source reading newline delimited
+// JSON from the files matching the pattern, see NewFileSource
+func NewNDJSONSource(name string, pattern string) internal.DataSource {
+	return NewFileSource(name, "ndjson", pattern, DecodeNDJSON)
+}
+
+// NewFileSource returns a data source that reads one record per line
+// from the files matching the pattern, which might be a single path
+// or a glob such as `/var/log/app/*.log*`.
+//
+// Files are read from the least to the most recently modified, so
+// rotated logs are read in order, and gzip compressed files are
+// decompressed transparently. Blank lines are ignored.
+//
+// Each record also contains the following virtual fields:
+//
+//   - `$file`: the path of the file containing the record
+//   - `$line`: the line number of the record, starting from 1
+//   - `$offset`: the offset in bytes of the start of the line,
+//     on the decompressed contents for gzip files
+func NewFileSource(name string, sourceType string, pattern string, decode LineDecoder) internal.DataSource {
+	return internal.DataSource{
+		Name: name,
+		Type: sourceType,
+		Open: func(ctx context.Context) (internal.RecordIterator, error) {
+			paths, err := findFiles(pattern)
+			if err != nil {
+				return nil, err
+			}
+
+			return &fileIterator{
+				ctx:    ctx,
+				paths:  paths,
+				decode: decode,
+			}, nil
+		},
+	}
+}
+
+// findFiles returns the files matching the pattern
+// sorted by modification time and then by path
+func findFiles(pattern string) ([]string, error) {
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, insights.RuntimeErr("invalid file pattern", map[string]any{
+			"pattern": pattern,
+			"error":   err,
+		})
+	}
+
+	if len(paths) == 0 {
+		return nil, insights.RuntimeErr("no files found", map[string]any{
+			"pattern": pattern,
+		})
+	}
+
+	modTimes := map[string]int64{}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, insights.RuntimeErr("unable to read file info", map[string]any{
+				"path":  path,
+				"error": err,
+			})
+		}
+		modTimes[path] = info.ModTime().UnixNano()
+	}
+
+	sort.SliceStable(paths, func(i, j int) bool {
+		return modTimes[paths[i]] < modTimes[paths[j]]
+	})
+
+	return paths, nil
+}
+
+type fileIterator struct {
+	ctx    context.Context
+	paths  []string
+	decode LineDecoder
+
+	// The state of the file being read:
+	file   *os.File
+	reader *bufio.Reader
+	path   string
+	line   int
+	offset int64
+}
+
+func (f *fileIterator) Next() (internal.Record, error) {
+	for {
+		if f.ctx.Err() != nil {
+			return internal.Record{}, f.ctx.Err()
+		}
+
+		if f.reader == nil {
+			if len(f.paths) == 0 {
+				return internal.Record{}, io.EOF
+			}
+
+			err := f.openFile(f.paths[0])
+			if err != nil {
+				return internal.Record{}, err
+			}
+			f.paths = f.paths[1:]
+		}
+
+		// ReadBytes grows its buffer as needed, so
+		// long lines are never truncated:
+		line, err := f.reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return internal.Record{}, insights.RuntimeErr("unable to read file", map[string]any{
+				"path":  f.path,
+				"error": err,
+			})
+		}
+		if err == io.EOF {
+			f.closeFile()
+		}
+
+		lineOffset := f.offset
+		f.offset += int64(len(line))
+		if len(line) == 0 {
+			continue
+		}
+		f.line++
+
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+
+		return newLineRecord(f.decode, line, f.path, f.line, lineOffset), nil
+	}
+}
+
+// newLineRecord decodes a line into a record with the virtual fields
+func newLineRecord(decode LineDecoder, line []byte, path string, lineNum int, offset int64) internal.Record {
+	rawRecord, err := decode(line)
+	if err == nil {
+		rawRecord, err = addVirtualFields(rawRecord, path, lineNum, offset)
+	}
+	if err != nil {
+		return internal.Record{
+			Err: insights.RuntimeErr("unable to decode line", map[string]any{
+				"file":  path,
+				"line":  lineNum,
+				"error": err,
+			}),
+		}
+	}
+
+	return internal.Record{
+		Raw: rawRecord,
+	}
+}
+
+func (f *fileIterator) openFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return insights.RuntimeErr("unable to open file", map[string]any{
+			"path":  path,
+			"error": err,
+		})
+	}
+
+	reader, err := newDecompressingReader(file)
+	if err != nil {
+		file.Close()
+		return insights.RuntimeErr("unable to decompress file", map[string]any{
+			"path":  path,
+			"error": err,
+		})
+	}
+
+	f.file = file
+	f.reader = reader
+	f.path = path
+	f.line = 0
+	f.offset = 0
+	return nil
+}
+
+func (f *fileIterator) closeFile() {
+	if f.file != nil {
+		f.file.Close()
+	}
+	f.file = nil
+	f.reader = nil
+}
+
+func (f *fileIterator) Close() error {
+	f.closeFile()
+	f.paths = nil
+	return nil
+}
+
+// gzipMagic are the first bytes of any gzip file
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// newDecompressingReader detects gzip files by their contents
+// instead of their extension, so compressed files are read
+// correctly regardless of how the log rotation named them
+func newDecompressingReader(r io.Reader) (*bufio.Reader, error) {
+	reader := bufio.NewReader(r)
+	magic, _ := reader.Peek(len(gzipMagic))
+	if !bytes.Equal(magic, gzipMagic) {
+		return reader, nil
+	}
+
+	gzipReader, err := gzip.NewReader(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	return bufio.NewReader(gzipReader), nil
+}
+
+// DecodeNDJSON is the LineDecoder for newline delimited
+// JSON, it only checks that the line is a JSON object
+func DecodeNDJSON(line []byte) (json.RawMessage, error) {
+	if !json.Valid(line) {
+		return nil, insights.RuntimeErr("invalid JSON", map[string]any{
+			"line": truncate(line),
+		})
+	}
+
+	if line[0] != '{' {
+		return nil, insights.RuntimeErr("expected a JSON object", map[string]any{
+			"line": truncate(line),
+		})
+	}
+
+	return line, nil
+}
+
+// addVirtualFields adds the `$file`, `$line` and `$offset` fields
+// to the end of the JSON object rawRecord, so they take precedence
+// over any fields with the same names
+func addVirtualFields(rawRecord json.RawMessage, path string, line int, offset int64) (json.RawMessage, error) {
+	rawRecord = bytes.TrimSpace(rawRecord)
+	if len(rawRecord) < 2 || rawRecord[0] != '{' || rawRecord[len(rawRecord)-1] != '}' {
+		return nil, insights.InternalErr("decoded records must be JSON objects", map[string]any{
+			"record": truncate(rawRecord),
+		})
+	}
+
+	rawPath, _ := json.Marshal(path)
+
+	fields := bytes.TrimSpace(rawRecord[:len(rawRecord)-1])
+	b := make([]byte, 0, len(rawRecord)+len(rawPath)+64)
+	b = append(b, fields...)
+	if len(fields) > 1 {
+		b = append(b, ',')
+	}
+	b = append(b, `"$file":`...)
+	b = append(b, rawPath...)
+	b = append(b, `,"$line":`...)
+	b = strconv.AppendInt(b, int64(line), 10)
+	b = append(b, `,"$offset":`...)
+	b = strconv.AppendInt(b, offset, 10)
+
+	return append(b, '}'), nil
+}
+
+// truncate shortens long lines included on error messages
+func truncate(line []byte) string {
+	const maxLen = 100
+	if len(line) <= maxLen {
+		return string(line)
+	}
+
+	return string(line[:maxLen]) + "..."
+}
@@ -0,0 +1,170 @@
+package datasources
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vingarcia/insights/internal"
+	"github.com/vingarcia/insights/internal/adapters/evaluator/eparser"
+	tt "github.com/vingarcia/insights/internal/testtools"
+)
+
+func TestNDJSONSource(t *testing.T) {
+	dir := t.TempDir()
+
+	longMsg := strings.Repeat("x", 1<<20)
+	writeFile(t, filepath.Join(dir, "app.log.1.gz"), gzipData(t, `{"msg":"oldest"}`+"\n"), -2*time.Hour)
+	writeFile(t, filepath.Join(dir, "app.log.1"), []byte(`{"msg":"older"}`+"\r\n\n"+`{"msg":"`+longMsg+`"}`+"\n"), -time.Hour)
+	writeFile(t, filepath.Join(dir, "app.log"), []byte(`{"msg":"newest","$line":"fake"}`+"\n"+`not json`+"\n"+`[1]`+"\n"+`{}`), 0)
+	writeFile(t, filepath.Join(dir, "other.txt"), []byte(`{"msg":"ignored"}`), 0)
+
+	t.Run("should read all matching files in order", func(t *testing.T) {
+		records := readAll(t, NewNDJSONSource("app", filepath.Join(dir, "app.log*")))
+
+		tt.AssertEqual(t, len(records), 7)
+		assertRecord(t, records[0], map[string]any{
+			"msg":     "oldest",
+			"$file":   filepath.Join(dir, "app.log.1.gz"),
+			"$line":   1.0,
+			"$offset": 0.0,
+		})
+		assertRecord(t, records[1], map[string]any{
+			"msg":     "older",
+			"$file":   filepath.Join(dir, "app.log.1"),
+			"$line":   1.0,
+			"$offset": 0.0,
+		})
+		assertRecord(t, records[2], map[string]any{
+			"msg":     longMsg,
+			"$file":   filepath.Join(dir, "app.log.1"),
+			"$line":   3.0,
+			"$offset": 18.0,
+		})
+		assertRecord(t, records[3], map[string]any{
+			"msg":     "newest",
+			"$file":   filepath.Join(dir, "app.log"),
+			"$line":   1.0,
+			"$offset": 0.0,
+		})
+		tt.AssertErrContains(t, records[4].Err, "unable to decode line", "line = 2", "invalid JSON", "not json")
+		tt.AssertErrContains(t, records[5].Err, "unable to decode line", "line = 3", "expected a JSON object")
+		assertRecord(t, records[6], map[string]any{
+			"$file":   filepath.Join(dir, "app.log"),
+			"$line":   4.0,
+			"$offset": 45.0,
+		})
+	})
+
+	t.Run("should expose the virtual fields to expressions", func(t *testing.T) {
+		// The virtual fields should also replace the ones from the record:
+		expr, err := eparser.Parse(`$line == 1 && $offset == 0 && ends_with($file, "app.log")`)
+		tt.AssertNoErr(t, err)
+
+		records := readAll(t, NewNDJSONSource("app", filepath.Join(dir, "app.log")))
+		match, err := expr.Evaluate(records[0].Raw)
+		tt.AssertNoErr(t, err)
+		tt.AssertEqual(t, match, true)
+	})
+
+	t.Run("should report missing files", func(t *testing.T) {
+		_, err := NewNDJSONSource("app", filepath.Join(dir, "missing*")).Open(context.Background())
+		tt.AssertErrContains(t, err, "no files found", "missing*")
+	})
+
+	t.Run("should stop reading when the context is canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		iterator, err := NewNDJSONSource("app", filepath.Join(dir, "app.log*")).Open(ctx)
+		tt.AssertNoErr(t, err)
+		defer iterator.Close()
+
+		cancel()
+		_, err = iterator.Next()
+		tt.AssertEqual(t, err, context.Canceled)
+	})
+}
+
+func TestAddVirtualFields(t *testing.T) {
+	tests := []struct {
+		desc               string
+		record             string
+		expectedRecord     string
+		expectErrToContain []string
+	}{
+		{
+			desc:           "should add the fields to empty objects",
+			record:         ` { } `,
+			expectedRecord: `{"$file":"a.log","$line":2,"$offset":10}`,
+		},
+		{
+			desc:           "should add the fields after the existing ones",
+			record:         `{"a": 1}`,
+			expectedRecord: `{"a": 1,"$file":"a.log","$line":2,"$offset":10}`,
+		},
+		{
+			desc:               "should reject records that are not objects",
+			record:             `[1]`,
+			expectErrToContain: []string{"InternalErr", "must be JSON objects"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			record, err := addVirtualFields(json.RawMessage(test.record), "a.log", 2, 10)
+			if test.expectErrToContain != nil {
+				tt.AssertErrContains(t, err, test.expectErrToContain...)
+				return
+			}
+			tt.AssertNoErr(t, err)
+
+			tt.AssertEqual(t, string(record), test.expectedRecord)
+		})
+	}
+}
+
+func readAll(t *testing.T, source internal.DataSource) []internal.Record {
+	iterator, err := source.Open(context.Background())
+	tt.AssertNoErr(t, err)
+	defer iterator.Close()
+
+	var records []internal.Record
+	for {
+		record, err := iterator.Next()
+		if err == io.EOF {
+			return records
+		}
+		tt.AssertNoErr(t, err)
+		records = append(records, record)
+	}
+}
+
+func assertRecord(t *testing.T, record internal.Record, expected map[string]any) {
+	tt.AssertNoErr(t, record.Err)
+
+	var decoded map[string]any
+	tt.AssertNoErr(t, json.Unmarshal(record.Raw, &decoded))
+	tt.AssertEqual(t, decoded, expected)
+}
+
+func writeFile(t *testing.T, path string, data []byte, age time.Duration) {
+	tt.AssertNoErr(t, os.WriteFile(path, data, 0644))
+
+	modTime := time.Now().Add(age)
+	tt.AssertNoErr(t, os.Chtimes(path, modTime, modTime))
+}
+
+func gzipData(t *testing.T, data string) []byte {
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	_, err := w.Write([]byte(data))
+	tt.AssertNoErr(t, err)
+	tt.AssertNoErr(t, w.Close())
+	return b.Bytes()
+}
@@ -0,0 +1,519 @@
+package datasources
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/vingarcia/insights"
+	"github.com/vingarcia/insights/internal"
+)
+
+// FollowOptions configures the data sources created by NewFollowSource
+type FollowOptions struct {
+	// CheckpointPath is an optional file for saving the read offset,
+	// so a restarted source resumes from where the last one stopped
+	CheckpointPath string
+
+	// FromStart makes the source read the existing contents of the
+	// file when there is no checkpoint, by default only the lines
+	// appended after the source is opened are read
+	FromStart bool
+
+	// PollInterval is how often the file is checked for new lines
+	// and rotations, defaultPollInterval is used if it is zero
+	PollInterval time.Duration
+}
+
+const defaultPollInterval = 250 * time.Millisecond
+
+// checkpointInterval is the minimum time between checkpoint writes,
+// checkpoints are also written when the iterator is closed
+const checkpointInterval = time.Second
+
+// fingerprintSize is the number of bytes from the start of a file
+// used for recognizing it after a restart, even if it was renamed
+const fingerprintSize = 1024
+
+// NewFollowSource returns a data source that reads the lines appended
+// to the file on path, just like `tail -F`, so its iterator never
+// returns io.EOF and Next blocks until a new line is written or the
+// context is canceled.
+//
+// Rotations are detected both when path is renamed and a new file is
+// created in its place, in which case the renamed file is read until
+// its end before the new one is read, and when the file is copied and
+// then truncated, in which case it is read again from its start.
+//
+// The records have the same virtual fields of NewFileSource, `$file`
+// is the path of the file when it was opened, which is only different
+// from path when resuming from the checkpoint of a rotated file.
+func NewFollowSource(name string, sourceType string, path string, decode LineDecoder, opts FollowOptions) internal.DataSource {
+	if opts.PollInterval == 0 {
+		opts.PollInterval = defaultPollInterval
+	}
+
+	return internal.DataSource{
+		Name: name,
+		Type: sourceType,
+		Open: func(ctx context.Context) (internal.RecordIterator, error) {
+			f := &followIterator{
+				ctx:    ctx,
+				path:   path,
+				decode: decode,
+				opts:   opts,
+			}
+
+			err := f.resume()
+			if err != nil {
+				f.Close()
+				return nil, err
+			}
+
+			return f, nil
+		},
+	}
+}
+
+type followIterator struct {
+	ctx    context.Context
+	path   string
+	decode LineDecoder
+	opts   FollowOptions
+
+	// The state of the file being read, filePath is
+	// different from path after resuming from a checkpoint
+	// of a file that was rotated in the meantime:
+	file     *os.File
+	filePath string
+	reader   *bufio.Reader
+	line     int
+	offset   int64
+
+	// partial holds the start of a line that is still being written
+	partial []byte
+
+	// head holds the first bytes read from the file, up to
+	// fingerprintSize, for detecting truncations that were
+	// followed by enough writes to go past the offset
+	head []byte
+
+	// rotated is set once path refers to a new file, the current
+	// file is then read until its end before switching to it
+	rotated bool
+
+	lastCheckpoint     checkpoint
+	lastCheckpointTime time.Time
+}
+
+// checkpoint is the format of the checkpoint files
+type checkpoint struct {
+	Path            string `json:"path"`
+	Offset          int64  `json:"offset"`
+	Line            int    `json:"line"`
+	Fingerprint     uint64 `json:"fingerprint"`
+	FingerprintSize int64  `json:"fingerprint_size"`
+}
+
+// resume opens the file from the checkpoint, if there is one,
+// which might have been renamed by a rotation in the meantime
+func (f *followIterator) resume() error {
+	cp, found, err := loadCheckpoint(f.opts.CheckpointPath)
+	if err != nil {
+		return err
+	}
+
+	if !found {
+		return f.openPath(!f.opts.FromStart)
+	}
+
+	rotatedPaths, _ := filepath.Glob(f.path + "*")
+	seen := map[string]bool{}
+	for _, candidate := range append([]string{cp.Path, f.path}, rotatedPaths...) {
+		if seen[candidate] {
+			continue
+		}
+		seen[candidate] = true
+
+		file, err := os.Open(candidate)
+		if err != nil {
+			continue
+		}
+
+		if !matchesCheckpoint(file, cp) {
+			file.Close()
+			continue
+		}
+
+		return f.setFile(file, candidate, cp.Offset, cp.Line)
+	}
+
+	// The file was removed or compressed, so the best
+	// we can do is reading the current file from its start:
+	return f.openPath(false)
+}
+
+// openPath opens the file on path from its start or from its end,
+// if the file doesn't exist yet it is opened later by Next
+func (f *followIterator) openPath(atEnd bool) error {
+	file, err := os.Open(f.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return insights.RuntimeErr("unable to open file", map[string]any{
+			"path":  f.path,
+			"error": err,
+		})
+	}
+
+	var offset int64
+	var line int
+	if atEnd {
+		offset, line, err = countLines(file)
+		if err != nil {
+			file.Close()
+			return insights.RuntimeErr("unable to read file", map[string]any{
+				"path":  f.path,
+				"error": err,
+			})
+		}
+	}
+
+	return f.setFile(file, f.path, offset, line)
+}
+
+func (f *followIterator) setFile(file *os.File, path string, offset int64, line int) error {
+	_, err := file.Seek(offset, io.SeekStart)
+	if err != nil {
+		file.Close()
+		return insights.RuntimeErr("unable to read file", map[string]any{
+			"path":  path,
+			"error": err,
+		})
+	}
+
+	head := make([]byte, min(offset, fingerprintSize))
+	_, err = file.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		file.Close()
+		return insights.RuntimeErr("unable to read file", map[string]any{
+			"path":  path,
+			"error": err,
+		})
+	}
+
+	f.file = file
+	f.filePath = path
+	f.reader = bufio.NewReader(file)
+	f.offset = offset
+	f.line = line
+	f.partial = nil
+	f.head = head
+	f.rotated = false
+	return nil
+}
+
+func (f *followIterator) Next() (internal.Record, error) {
+	// The records returned before were already
+	// processed, so they can be checkpointed:
+	err := f.checkpoint(false)
+	if err != nil {
+		return internal.Record{}, err
+	}
+
+	for {
+		if f.ctx.Err() != nil {
+			return internal.Record{}, f.ctx.Err()
+		}
+
+		if f.file == nil {
+			err := f.openPath(false)
+			if err != nil {
+				return internal.Record{}, err
+			}
+
+			if f.file == nil {
+				err = f.wait()
+				if err != nil {
+					return internal.Record{}, err
+				}
+				continue
+			}
+		}
+
+		chunk, err := f.reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return internal.Record{}, insights.RuntimeErr("unable to read file", map[string]any{
+				"path":  f.filePath,
+				"error": err,
+			})
+		}
+		f.partial = append(f.partial, chunk...)
+
+		// Incomplete lines are only returned once nothing
+		// else can be written to them, i.e. after a rotation:
+		if err == nil || (f.rotated && len(f.partial) > 0) {
+			line := f.partial
+			f.partial = nil
+
+			if missing := fingerprintSize - len(f.head); missing > 0 {
+				f.head = append(f.head, line[:min(missing, len(line))]...)
+			}
+
+			lineOffset := f.offset
+			f.offset += int64(len(line))
+			f.line++
+
+			line = bytes.TrimSpace(line)
+			if len(line) == 0 {
+				continue
+			}
+
+			return newLineRecord(f.decode, line, f.filePath, f.line, lineOffset), nil
+		}
+
+		if f.rotated {
+			f.file.Close()
+			f.file = nil
+			continue
+		}
+
+		err = f.checkpoint(false)
+		if err != nil {
+			return internal.Record{}, err
+		}
+
+		err = f.wait()
+		if err != nil {
+			return internal.Record{}, err
+		}
+
+		// Rotations are checked right before reading again,
+		// so truncated files are not read past their start:
+		err = f.detectRotation()
+		if err != nil {
+			return internal.Record{}, err
+		}
+	}
+}
+
+// detectRotation checks if path refers to a new file or if the
+// file was truncated, preparing the iterator to read it
+func (f *followIterator) detectRotation() error {
+	pathInfo, err := os.Stat(f.path)
+	if os.IsNotExist(err) {
+		// The file was renamed and the new one was not created yet:
+		return nil
+	}
+	if err != nil {
+		return insights.RuntimeErr("unable to read file info", map[string]any{
+			"path":  f.path,
+			"error": err,
+		})
+	}
+
+	fileInfo, err := f.file.Stat()
+	if err != nil {
+		return insights.RuntimeErr("unable to read file info", map[string]any{
+			"path":  f.filePath,
+			"error": err,
+		})
+	}
+
+	if !os.SameFile(pathInfo, fileInfo) {
+		// Drain the current file one last time before switching,
+		// since lines might have been written before the rename:
+		f.rotated = true
+		return nil
+	}
+
+	if fileInfo.Size() < f.offset+int64(len(f.partial)) {
+		// The file was truncated, e.g. by logrotate's copytruncate:
+		return f.setFile(f.file, f.filePath, 0, 0)
+	}
+
+	// The file might also have been truncated and written past the
+	// offset since the last check, in which case its start changed:
+	fp, err := fingerprint(f.file, int64(len(f.head)))
+	if err != nil {
+		return insights.RuntimeErr("unable to read file", map[string]any{
+			"path":  f.filePath,
+			"error": err,
+		})
+	}
+	if fp != hashBytes(f.head) {
+		return f.setFile(f.file, f.filePath, 0, 0)
+	}
+
+	return nil
+}
+
+func (f *followIterator) wait() error {
+	timer := time.NewTimer(f.opts.PollInterval)
+	defer timer.Stop()
+
+	select {
+	case <-f.ctx.Done():
+		return f.ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// checkpoint saves the offset of the last line read, if force is
+// false it is only saved if checkpointInterval has passed since
+// the last time it was saved
+func (f *followIterator) checkpoint(force bool) error {
+	if f.opts.CheckpointPath == "" || f.file == nil {
+		return nil
+	}
+
+	if !force && time.Since(f.lastCheckpointTime) < checkpointInterval {
+		return nil
+	}
+
+	size := min(f.offset, fingerprintSize)
+	fp, err := fingerprint(f.file, size)
+	if err != nil {
+		return insights.RuntimeErr("unable to read file", map[string]any{
+			"path":  f.filePath,
+			"error": err,
+		})
+	}
+
+	cp := checkpoint{
+		Path:            f.filePath,
+		Offset:          f.offset,
+		Line:            f.line,
+		Fingerprint:     fp,
+		FingerprintSize: size,
+	}
+	f.lastCheckpointTime = time.Now()
+	if cp == f.lastCheckpoint {
+		return nil
+	}
+
+	err = saveCheckpoint(f.opts.CheckpointPath, cp)
+	if err != nil {
+		return err
+	}
+
+	f.lastCheckpoint = cp
+	return nil
+}
+
+func (f *followIterator) Close() error {
+	err := f.checkpoint(true)
+	if f.file != nil {
+		f.file.Close()
+		f.file = nil
+	}
+
+	return err
+}
+
+func loadCheckpoint(path string) (_ checkpoint, found bool, _ error) {
+	if path == "" {
+		return checkpoint{}, false, nil
+	}
+
+	rawCheckpoint, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return checkpoint{}, false, nil
+	}
+	if err != nil {
+		return checkpoint{}, false, insights.RuntimeErr("unable to read checkpoint file", map[string]any{
+			"path":  path,
+			"error": err,
+		})
+	}
+
+	var cp checkpoint
+	err = json.Unmarshal(rawCheckpoint, &cp)
+	if err != nil {
+		return checkpoint{}, false, insights.RuntimeErr("invalid checkpoint file", map[string]any{
+			"path":  path,
+			"error": err,
+		})
+	}
+
+	return cp, true, nil
+}
+
+// saveCheckpoint replaces the checkpoint file atomically, so
+// it is never left half written if the process is killed
+func saveCheckpoint(path string, cp checkpoint) error {
+	rawCheckpoint, _ := json.Marshal(cp)
+
+	tmpPath := path + ".tmp"
+	err := os.WriteFile(tmpPath, rawCheckpoint, 0644)
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		return insights.RuntimeErr("unable to save checkpoint file", map[string]any{
+			"path":  path,
+			"error": err,
+		})
+	}
+
+	return nil
+}
+
+func matchesCheckpoint(file *os.File, cp checkpoint) bool {
+	info, err := file.Stat()
+	if err != nil || info.Size() < cp.Offset {
+		return false
+	}
+
+	fp, err := fingerprint(file, cp.FingerprintSize)
+	return err == nil && fp == cp.Fingerprint
+}
+
+// fingerprint hashes the first size bytes of the file
+func fingerprint(file *os.File, size int64) (uint64, error) {
+	buf := make([]byte, size)
+	_, err := file.ReadAt(buf, 0)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+
+	return hashBytes(buf), nil
+}
+
+func hashBytes(b []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(b)
+	return h.Sum64()
+}
+
+// countLines returns the offset right after the last complete line
+// of the file and the number of complete lines before it
+func countLines(r io.Reader) (offset int64, lines int, _ error) {
+	buf := make([]byte, 64*1024)
+	var read int64
+	for {
+		n, err := r.Read(buf)
+		for i, c := range buf[:n] {
+			if c == '\n' {
+				lines++
+				offset = read + int64(i) + 1
+			}
+		}
+		read += int64(n)
+
+		if err == io.EOF {
+			return offset, lines, nil
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package datasources
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/vingarcia/insights/internal"
+	tt "github.com/vingarcia/insights/internal/testtools"
+)
+
+func TestFollowSource(t *testing.T) {
+	t.Run("should only read new lines by default", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "app.log")
+		appendLines(t, path, `{"n":1}`+"\n"+`{"n":2}`+"\n"+`{"n":`)
+
+		iterator := openFollow(t, path, FollowOptions{})
+		appendLines(t, path, `3}`+"\n"+`{"n":4}`+"\n")
+
+		assertNextLines(t, iterator, []followedLine{
+			{n: 3, file: path, line: 3},
+			{n: 4, file: path, line: 4},
+		})
+	})
+
+	t.Run("should wait for the file to be created", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "app.log")
+
+		iterator := openFollow(t, path, FollowOptions{})
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			os.WriteFile(path, []byte(`{"n":1}`+"\n"), 0644)
+		}()
+
+		assertNextLines(t, iterator, []followedLine{
+			{n: 1, file: path, line: 1},
+		})
+	})
+
+	t.Run("should follow renamed files", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "app.log")
+		appendLines(t, path, `{"n":1}`+"\n")
+
+		iterator := openFollow(t, path, FollowOptions{FromStart: true})
+		assertNextLines(t, iterator, []followedLine{
+			{n: 1, file: path, line: 1},
+		})
+
+		// Lines written to the old file after the rename
+		// should still be read before the new file:
+		appendLines(t, path, `{"n":2}`+"\n")
+		tt.AssertNoErr(t, os.Rename(path, path+".1"))
+		appendLines(t, path+".1", `{"n":3}`)
+		appendLines(t, path, `{"n":4}`+"\n")
+
+		assertNextLines(t, iterator, []followedLine{
+			{n: 2, file: path, line: 2},
+			{n: 3, file: path, line: 3},
+			{n: 4, file: path, line: 1},
+		})
+	})
+
+	t.Run("should follow truncated files", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "app.log")
+		appendLines(t, path, `{"n":1}`+"\n"+`{"n":2}`+"\n")
+
+		iterator := openFollow(t, path, FollowOptions{FromStart: true})
+		assertNextLines(t, iterator, []followedLine{
+			{n: 1, file: path, line: 1},
+			{n: 2, file: path, line: 2},
+		})
+
+		tt.AssertNoErr(t, os.Truncate(path, 0))
+		appendLines(t, path, `{"n":3}`+"\n")
+
+		assertNextLines(t, iterator, []followedLine{
+			{n: 3, file: path, line: 1},
+		})
+	})
+
+	t.Run("should follow truncated files written past the offset", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "app.log")
+		appendLines(t, path, `{"n":1}`+"\n"+`{"n":2}`+"\n")
+
+		iterator := openFollow(t, path, FollowOptions{FromStart: true})
+		assertNextLines(t, iterator, []followedLine{
+			{n: 1, file: path, line: 1},
+			{n: 2, file: path, line: 2},
+		})
+
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			os.WriteFile(path, []byte(`{"n":3}`+"\n"+`{"n":4}`+"\n"+`{"n":5}`+"\n"), 0644)
+		}()
+
+		assertNextLines(t, iterator, []followedLine{
+			{n: 3, file: path, line: 1},
+			{n: 4, file: path, line: 2},
+			{n: 5, file: path, line: 3},
+		})
+	})
+
+	t.Run("should resume from the checkpoint", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "app.log")
+		opts := FollowOptions{
+			CheckpointPath: filepath.Join(dir, "checkpoint.json"),
+			FromStart:      true,
+		}
+		appendLines(t, path, `{"n":1}`+"\n"+`{"n":2}`+"\n")
+
+		iterator := openFollow(t, path, opts)
+		assertNextLines(t, iterator, []followedLine{
+			{n: 1, file: path, line: 1},
+			{n: 2, file: path, line: 2},
+		})
+		tt.AssertNoErr(t, iterator.Close())
+
+		appendLines(t, path, `{"n":3}`+"\n")
+
+		iterator = openFollow(t, path, opts)
+		assertNextLines(t, iterator, []followedLine{
+			{n: 3, file: path, line: 3},
+		})
+	})
+
+	t.Run("should resume from the checkpoint of a rotated file", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "app.log")
+		opts := FollowOptions{
+			CheckpointPath: filepath.Join(dir, "checkpoint.json"),
+		}
+		appendLines(t, path, "")
+
+		iterator := openFollow(t, path, opts)
+		appendLines(t, path, `{"n":1}`+"\n")
+		assertNextLines(t, iterator, []followedLine{
+			{n: 1, file: path, line: 1},
+		})
+		tt.AssertNoErr(t, iterator.Close())
+
+		// Rotate the file while the source is closed:
+		appendLines(t, path, `{"n":2}`+"\n")
+		tt.AssertNoErr(t, os.Rename(path, path+".1"))
+		appendLines(t, path, `{"n":3}`+"\n")
+
+		iterator = openFollow(t, path, opts)
+		assertNextLines(t, iterator, []followedLine{
+			{n: 2, file: path + ".1", line: 2},
+			{n: 3, file: path, line: 1},
+		})
+	})
+
+	t.Run("should stop when the context is canceled", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "app.log")
+		appendLines(t, path, "")
+
+		ctx, cancel := context.WithCancel(context.Background())
+		iterator, err := NewFollowSource("app", "ndjson", path, DecodeNDJSON, FollowOptions{
+			PollInterval: time.Millisecond,
+		}).Open(ctx)
+		tt.AssertNoErr(t, err)
+		defer iterator.Close()
+
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			cancel()
+		}()
+
+		_, err = iterator.Next()
+		tt.AssertEqual(t, err, context.Canceled)
+	})
+}
+
+type followedLine struct {
+	n    int
+	file string
+	line int
+}
+
+func openFollow(t *testing.T, path string, opts FollowOptions) internal.RecordIterator {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+
+	opts.PollInterval = time.Millisecond
+	iterator, err := NewFollowSource("app", "ndjson", path, DecodeNDJSON, opts).Open(ctx)
+	tt.AssertNoErr(t, err)
+	t.Cleanup(func() { iterator.Close() })
+
+	return iterator
+}
+
+func assertNextLines(t *testing.T, iterator internal.RecordIterator, expected []followedLine) {
+	for _, e := range expected {
+		record, err := iterator.Next()
+		tt.AssertNoErr(t, err)
+		tt.AssertNoErr(t, record.Err)
+
+		var decoded struct {
+			N    int    `json:"n"`
+			File string `json:"$file"`
+			Line int    `json:"$line"`
+		}
+		tt.AssertNoErr(t, json.Unmarshal(record.Raw, &decoded))
+		tt.AssertEqual(t, followedLine{n: decoded.N, file: decoded.File, line: decoded.Line}, e)
+	}
+}
+
+func appendLines(t *testing.T, path string, data string) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	tt.AssertNoErr(t, err)
+	defer file.Close()
+
+	_, err = file.WriteString(data)
+	tt.AssertNoErr(t, err)
+}
@@ -0,0 +1,170 @@
+package datasources
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+
+	"github.com/vingarcia/insights"
+	"github.com/vingarcia/insights/internal"
+)
+
+// NewLogfmtSource returns a data source reading logfmt lines
+// from the files matching the pattern, see NewFileSource
+func NewLogfmtSource(name string, pattern string) internal.DataSource {
+	return NewFileSource(name, "logfmt", pattern, DecodeLogfmt)
+}
+
+// DecodeLogfmt is the LineDecoder for logfmt lines such as:
+//
+//	level=info msg="request done" dur=12ms status=200 cached
+//
+// The pairs are separated by spaces or tabs and are decoded as follows:
+//
+//   - Quoted values are always strings, and can contain the same
+//     escape sequences of Go strings, e.g. `msg="say \"hi\""`
+//   - Unquoted values end at the first space and are decoded as
+//     numbers or booleans if they are valid JSON numbers or booleans,
+//     otherwise they are strings, e.g. `dur=12ms` is a string
+//   - Keys without a value, e.g. `cached`, are set to true, and keys
+//     with an empty value, e.g. `user=`, are set to an empty string
+//   - If a key appears more than once only its last value is kept
+func DecodeLogfmt(line []byte) (json.RawMessage, error) {
+	var keys []string
+	values := map[string]json.RawMessage{}
+
+	for i := 0; i < len(line); {
+		if isLogfmtSpace(line[i]) {
+			i++
+			continue
+		}
+
+		start := i
+		for i < len(line) && !isLogfmtSpace(line[i]) && line[i] != '=' && line[i] != '"' {
+			i++
+		}
+		key := string(line[start:i])
+		if key == "" {
+			return nil, insights.RuntimeErr("invalid logfmt: expected a key", map[string]any{
+				"position": start,
+				"line":     truncate(line),
+			})
+		}
+
+		var value json.RawMessage
+		switch {
+		case i == len(line) || isLogfmtSpace(line[i]):
+			value = json.RawMessage("true")
+
+		case line[i] == '"':
+			return nil, insights.RuntimeErr("invalid logfmt: unexpected quote on key", map[string]any{
+				"position": i,
+				"line":     truncate(line),
+			})
+
+		default:
+			// Skip the '=':
+			i++
+
+			var err error
+			value, i, err = decodeLogfmtValue(line, i)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if _, exists := values[key]; !exists {
+			keys = append(keys, key)
+		}
+		values[key] = value
+	}
+
+	b := []byte{'{'}
+	for idx, key := range keys {
+		if idx > 0 {
+			b = append(b, ',')
+		}
+		rawKey, _ := json.Marshal(key)
+		b = append(b, rawKey...)
+		b = append(b, ':')
+		b = append(b, values[key]...)
+	}
+
+	return append(b, '}'), nil
+}
+
+// decodeLogfmtValue decodes the value starting at line[i],
+// returning it as JSON along with the position right after it
+func decodeLogfmtValue(line []byte, i int) (json.RawMessage, int, error) {
+	if i < len(line) && line[i] == '"' {
+		end := i + 1
+		for ; end < len(line) && line[end] != '"'; end++ {
+			if line[end] == '\\' {
+				end++
+			}
+		}
+		if end >= len(line) {
+			return nil, 0, insights.RuntimeErr("invalid logfmt: unterminated quoted value", map[string]any{
+				"position": i,
+				"line":     truncate(line),
+			})
+		}
+		end++
+
+		if end < len(line) && !isLogfmtSpace(line[end]) {
+			return nil, 0, insights.RuntimeErr("invalid logfmt: expected a space after quoted value", map[string]any{
+				"position": end,
+				"line":     truncate(line),
+			})
+		}
+
+		s, err := strconv.Unquote(string(line[i:end]))
+		if err != nil {
+			return nil, 0, insights.RuntimeErr("invalid logfmt: bad escape sequence on quoted value", map[string]any{
+				"position": i,
+				"line":     truncate(line),
+			})
+		}
+
+		rawValue, _ := json.Marshal(s)
+		return rawValue, end, nil
+	}
+
+	start := i
+	for i < len(line) && !isLogfmtSpace(line[i]) {
+		if line[i] == '"' {
+			return nil, 0, insights.RuntimeErr("invalid logfmt: unexpected quote on unquoted value", map[string]any{
+				"position": i,
+				"line":     truncate(line),
+			})
+		}
+		i++
+	}
+	value := line[start:i]
+
+	if isJSONScalar(value) {
+		return json.RawMessage(value), i, nil
+	}
+
+	rawValue, _ := json.Marshal(string(value))
+	return rawValue, i, nil
+}
+
+// isJSONScalar returns true for the unquoted values that
+// should be kept as they are, i.e. numbers and booleans
+func isJSONScalar(value []byte) bool {
+	if len(value) == 0 {
+		return false
+	}
+
+	if bytes.Equal(value, []byte("true")) || bytes.Equal(value, []byte("false")) {
+		return true
+	}
+
+	c := value[0]
+	return (c == '-' || (c >= '0' && c <= '9')) && json.Valid(value)
+}
+
+func isLogfmtSpace(c byte) bool {
+	return c == ' ' || c == '\t'
+}
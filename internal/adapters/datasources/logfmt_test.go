@@ -0,0 +1,128 @@
+package datasources
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/vingarcia/insights/internal/adapters/evaluator/eparser"
+	tt "github.com/vingarcia/insights/internal/testtools"
+)
+
+func TestDecodeLogfmt(t *testing.T) {
+	tests := []struct {
+		desc               string
+		line               string
+		expectedRecord     string
+		expectErrToContain []string
+	}{
+		{
+			desc:           "should decode unquoted values",
+			line:           `level=info dur=12ms path=/users?id=1`,
+			expectedRecord: `{"level":"info","dur":"12ms","path":"/users?id=1"}`,
+		},
+		{
+			desc:           "should keep numbers and booleans typed",
+			line:           `status=500 latency=-1.5e3 ok=false version=1.2.3 zip=01234`,
+			expectedRecord: `{"status":500,"latency":-1.5e3,"ok":false,"version":"1.2.3","zip":"01234"}`,
+		},
+		{
+			desc:           "should decode quoted values as strings",
+			line:           `msg="request done" code="200" empty=""`,
+			expectedRecord: `{"msg":"request done","code":"200","empty":""}`,
+		},
+		{
+			desc:           "should decode escape sequences",
+			line:           `msg="say \"hi\"\tto C:\\users\n" unicode="\u00e9"`,
+			expectedRecord: `{"msg":"say \"hi\"\tto C:\\users\n","unicode":"é"}`,
+		},
+		{
+			desc:           "should set bare keys to true and empty values to empty strings",
+			line:           "cached\tuser= retried",
+			expectedRecord: `{"cached":true,"user":"","retried":true}`,
+		},
+		{
+			desc:           "should keep the last value of duplicate keys",
+			line:           `level=info msg=a level=error`,
+			expectedRecord: `{"level":"error","msg":"a"}`,
+		},
+		{
+			desc:           "should escape the keys",
+			line:           `weird\key=1`,
+			expectedRecord: `{"weird\\key":1}`,
+		},
+		{
+			desc:               "should reject missing keys",
+			line:               `level=info =oops`,
+			expectErrToContain: []string{"expected a key", "position = 11"},
+		},
+		{
+			desc:               "should reject unterminated quoted values",
+			line:               `msg="request done`,
+			expectErrToContain: []string{"unterminated quoted value"},
+		},
+		{
+			desc:               "should reject text right after quoted values",
+			line:               `msg="a"b`,
+			expectErrToContain: []string{"expected a space after quoted value"},
+		},
+		{
+			desc:               "should reject invalid escape sequences",
+			line:               `msg="\q"`,
+			expectErrToContain: []string{"bad escape sequence"},
+		},
+		{
+			desc:               "should reject quotes on keys",
+			line:               `a"b=1`,
+			expectErrToContain: []string{"unexpected quote on key"},
+		},
+		{
+			desc:               "should reject quotes on unquoted values",
+			line:               `a=b"c"`,
+			expectErrToContain: []string{"unexpected quote on unquoted value"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			record, err := DecodeLogfmt([]byte(test.line))
+			if test.expectErrToContain != nil {
+				tt.AssertErrContains(t, err, test.expectErrToContain...)
+				return
+			}
+			tt.AssertNoErr(t, err)
+
+			tt.AssertEqual(t, string(record), test.expectedRecord)
+		})
+	}
+}
+
+func TestLogfmtSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	writeFile(t, path, []byte(`level=info msg="request done" status=200 dur=12ms`+"\n"+`level=error status=500 cached`+"\n"+`msg="broken`), 0)
+
+	records := readAll(t, NewLogfmtSource("app", path))
+	tt.AssertEqual(t, len(records), 3)
+
+	assertRecord(t, records[0], map[string]any{
+		"level":   "info",
+		"msg":     "request done",
+		"status":  200.0,
+		"dur":     "12ms",
+		"$file":   path,
+		"$line":   1.0,
+		"$offset": 0.0,
+	})
+	tt.AssertErrContains(t, records[2].Err, "unable to decode line", "line = 3", "unterminated quoted value")
+
+	// Expressions should work the same way they work for JSON records:
+	expr, err := eparser.Parse(`status >= 500 && level == "error" && cached && !exists(dur)`)
+	tt.AssertNoErr(t, err)
+
+	match, err := expr.Evaluate(records[0].Raw)
+	tt.AssertNoErr(t, err)
+	tt.AssertEqual(t, match, false)
+
+	match, err = expr.Evaluate(records[1].Raw)
+	tt.AssertNoErr(t, err)
+	tt.AssertEqual(t, match, true)
+}
@@ -0,0 +1,80 @@
+package datasources
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+
+	"github.com/vingarcia/insights"
+	"github.com/vingarcia/insights/internal"
+)
+
+// Repo is a DataSourceRepo for a fixed set of data sources
+type Repo map[string]internal.DataSource
+
+func (r Repo) FindByName(name string) internal.DataSource {
+	return r[name]
+}
+
+// NewMemorySource returns a data source that reads the given records,
+// which is mostly useful for tests and for small static datasets
+func NewMemorySource(name string, records []map[string]any) internal.DataSource {
+	return internal.DataSource{
+		Name: name,
+		Type: "memory",
+		Open: func(ctx context.Context) (internal.RecordIterator, error) {
+			return &memoryIterator{
+				ctx:     ctx,
+				records: records,
+			}, nil
+		},
+	}
+}
+
+type memoryIterator struct {
+	ctx     context.Context
+	records []map[string]any
+}
+
+func (m *memoryIterator) Next() (internal.Record, error) {
+	if m.ctx.Err() != nil {
+		return internal.Record{}, m.ctx.Err()
+	}
+
+	if len(m.records) == 0 {
+		return internal.Record{}, io.EOF
+	}
+	record := m.records[0]
+	m.records = m.records[1:]
+
+	rawRecord, err := json.Marshal(record)
+	if err != nil {
+		return internal.Record{
+			Err: insights.RuntimeErr("unable to encode record as JSON", map[string]any{
+				"error": err,
+			}),
+		}, nil
+	}
+
+	return internal.Record{
+		Raw: rawRecord,
+	}, nil
+}
+
+func (m *memoryIterator) NextBatch(max int) ([]internal.Record, error) {
+	var batch []internal.Record
+	for len(batch) < max {
+		record, err := m.Next()
+		if err != nil {
+			return batch, err
+		}
+		batch = append(batch, record)
+	}
+
+	return batch, nil
+}
+
+func (m *memoryIterator) Close() error {
+	m.records = nil
+	return nil
+}
@@ -0,0 +1,78 @@
+package datasources
+
+import (
+	"context"
+	"io"
+	"math"
+	"testing"
+
+	"github.com/vingarcia/insights/internal"
+	tt "github.com/vingarcia/insights/internal/testtools"
+)
+
+func TestMemorySource(t *testing.T) {
+	records := []map[string]any{
+		{"a": 1},
+		{"a": math.Inf(1)},
+		{"a": "3"},
+	}
+
+	t.Run("should read the records one at a time", func(t *testing.T) {
+		iterator, err := NewMemorySource("test", records).Open(context.Background())
+		tt.AssertNoErr(t, err)
+		defer iterator.Close()
+
+		record, err := iterator.Next()
+		tt.AssertNoErr(t, err)
+		tt.AssertEqual(t, string(record.Raw), `{"a":1}`)
+
+		// Encoding errors only affect a single record:
+		record, err = iterator.Next()
+		tt.AssertNoErr(t, err)
+		tt.AssertErrContains(t, record.Err, "unable to encode record as JSON")
+
+		record, err = iterator.Next()
+		tt.AssertNoErr(t, err)
+		tt.AssertEqual(t, string(record.Raw), `{"a":"3"}`)
+
+		_, err = iterator.Next()
+		tt.AssertEqual(t, err, io.EOF)
+	})
+
+	t.Run("should read the records in batches", func(t *testing.T) {
+		iterator, err := NewMemorySource("test", records).Open(context.Background())
+		tt.AssertNoErr(t, err)
+		defer iterator.Close()
+
+		batchIterator := iterator.(internal.BatchIterator)
+
+		batch, err := batchIterator.NextBatch(2)
+		tt.AssertNoErr(t, err)
+		tt.AssertEqual(t, len(batch), 2)
+
+		batch, err = batchIterator.NextBatch(2)
+		tt.AssertEqual(t, err, io.EOF)
+		tt.AssertEqual(t, len(batch), 1)
+		tt.AssertEqual(t, string(batch[0].Raw), `{"a":"3"}`)
+	})
+
+	t.Run("should stop reading when the context is canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		iterator, err := NewMemorySource("test", records).Open(ctx)
+		tt.AssertNoErr(t, err)
+		defer iterator.Close()
+
+		_, err = iterator.Next()
+		tt.AssertNoErr(t, err)
+
+		cancel()
+		_, err = iterator.Next()
+		tt.AssertEqual(t, err, context.Canceled)
+	})
+
+	t.Run("should find sources by name", func(t *testing.T) {
+		repo := Repo{"test": NewMemorySource("test", records)}
+		tt.AssertEqual(t, repo.FindByName("test").Type, "memory")
+		tt.AssertEqual(t, repo.FindByName("other").Open == nil, true)
+	})
+}
@@ -7,4 +7,36 @@ import "encoding/json"
 // input representing a nested set of a variables.
 type Expression interface {
 	Evaluate(logLine json.RawMessage) (bool, error)
+	EvaluateRecord(record *Record) (bool, error)
+}
+
+// ValueExpression represents a compiled expression that
+// evaluates to a value given a JSON input representing
+// a nested set of variables.
+//
+// The resulting value is always one of the plain Go types:
+// nil, bool, int64, *big.Int (for integers that don't fit on
+// an int64), float64, string, []any or map[string]any, except
+// for the time bucketing functions which produce TimeBuckets.
+//
+// Both null and missing fields evaluate to nil.
+type ValueExpression interface {
+	Evaluate(logLine json.RawMessage) (any, error)
+	EvaluateRecord(record *Record) (any, error)
+}
+
+// Record is a JSON input shared by several expressions, so
+// it is only parsed once no matter how many expressions are
+// evaluated against it, e.g. the WHERE and SELECT of a query.
+type Record struct {
+	Raw json.RawMessage
+
+	// Parsed is set by the expressions when Raw is parsed for
+	// the first time, and should only be read by them
+	Parsed any
+}
+
+// NewRecord wraps a JSON input on a Record
+func NewRecord(logLine json.RawMessage) *Record {
+	return &Record{Raw: logLine}
 }
@@ -82,6 +82,20 @@ type KeyValuePair struct {
 	Value Token
 }
 
+func newKeyValuePair(key Token, value Token) (KeyValuePair, error) {
+	str, ok := key.(strToken)
+	if !ok {
+		return KeyValuePair{}, insights.SyntaxErr("map keys must be strings", map[string]any{
+			"key": key,
+		})
+	}
+
+	return KeyValuePair{
+		Key:   string(str),
+		Value: value,
+	}, nil
+}
+
 func (k KeyValuePair) Clone() Token {
 	return k
 }
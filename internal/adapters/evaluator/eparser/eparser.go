@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"strconv"
+	"time"
 	"unicode"
 
 	"github.com/vingarcia/insights"
@@ -19,7 +20,11 @@ func Parse(strExpr string) (_ evaluator.Expression, err error) {
 type BoolExpr []Token
 
 func (rpn BoolExpr) Evaluate(logLine json.RawMessage) (bool, error) {
-	m, err := NewLazyJsonMap(logLine)
+	return rpn.EvaluateRecord(evaluator.NewRecord(logLine))
+}
+
+func (rpn BoolExpr) EvaluateRecord(record *evaluator.Record) (bool, error) {
+	m, err := recordVars(record)
 	if err != nil {
 		return false, err
 	}
@@ -29,6 +34,12 @@ func (rpn BoolExpr) Evaluate(logLine json.RawMessage) (bool, error) {
 		return false, err
 	}
 
+	// Records where the expression can't be decided
+	// due to missing fields are not matched:
+	if isMissing(token) {
+		return false, nil
+	}
+
 	bToken, ok := token.(boolToken)
 	if !ok {
 		return false, insights.InternalErr("expression should evaluate to a boolean", map[string]any{
@@ -39,6 +50,50 @@ func (rpn BoolExpr) Evaluate(logLine json.RawMessage) (bool, error) {
 	return bool(bToken), nil
 }
 
+// ParseValue parses expressions that might evaluate
+// to any type of value, e.g. `latency_ms / 1000`
+func ParseValue(strExpr string) (_ evaluator.ValueExpression, err error) {
+	rpn, err := parse(strExpr, nil)
+
+	return ValueExpr(rpn), err
+}
+
+type ValueExpr []Token
+
+func (rpn ValueExpr) Evaluate(logLine json.RawMessage) (any, error) {
+	return rpn.EvaluateRecord(evaluator.NewRecord(logLine))
+}
+
+func (rpn ValueExpr) EvaluateRecord(record *evaluator.Record) (any, error) {
+	m, err := recordVars(record)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := evaluate(rpn, m)
+	if err != nil {
+		return nil, err
+	}
+
+	return tokenToValue(token)
+}
+
+// recordVars returns the variables of the record,
+// parsing it only on the first call
+func recordVars(record *evaluator.Record) (mapToken, error) {
+	if m, ok := record.Parsed.(mapToken); ok {
+		return m, nil
+	}
+
+	m, err := NewLazyJsonMap(record.Raw)
+	if err != nil {
+		return nil, err
+	}
+	record.Parsed = m
+
+	return m, nil
+}
+
 type ParsingCtx struct {
 	currentLine   int
 	lastLineStart int
@@ -71,24 +126,37 @@ func parse(strExpr string, vars map[string]Token) (_ []Token, err error) {
 
 	i := consumeSpaces(expr, 0, &parsingCtx)
 
+	// lastTokenWasVar is used for folding static paths such as
+	// `a.b[0]` into a single varToken: []string{"a", "b", "0"}
+	lastTokenWasVar := false
+
 	// Each iteration of this loop should produce a token or an operator
 	for i < len(expr) && expr[i] != ';' {
+		prevTokenWasVar := lastTokenWasVar
+		lastTokenWasVar = false
+
 		switch {
 		case unicode.IsNumber(expr[i]):
+			start := i
 			var num Token
 			i, num, err = parseNumber(expr, i)
 			if err != nil {
 				return nil, err
 			}
 
-			rpnBuilder.handleToken(num)
+			err = rpnBuilder.handleToken(num)
+			if err != nil {
+				return nil, unexpectedTokenErr(num, parsingCtx, start, err)
+			}
 
-		case isVarChar(expr[i]):
+		case isVarChar(expr[i]) || expr[i] == '$':
+			// Note: Names starting with `$` are reserved for
+			// special variables such as `$root`
+			start := i
 			var varName string
 			i, varName = parseVar(expr, i)
-			rpnBuilder.handleToken(varToken([]string{varName}))
 
-			parser := reservedWordParsers[varName]
+			parser := lookupReservedWord(varName)
 			if parser != nil {
 				i, err = parser(expr, &parsingCtx, &rpnBuilder, i)
 				if err != nil {
@@ -104,100 +172,137 @@ func parse(strExpr string, vars map[string]Token) (_ []Token, err error) {
 						originalValue: token,
 					})
 					if err != nil {
-						return nil, err
+						return nil, unexpectedTokenErr(varToken{varName}, parsingCtx, start, err)
 					}
 				} else {
 					// Save the variable name:
-					rpnBuilder.handleToken(varToken([]string{varName}))
+					err := rpnBuilder.handleToken(varToken{varName})
+					if err != nil {
+						return nil, unexpectedTokenErr(varToken{varName}, parsingCtx, start, err)
+					}
+					lastTokenWasVar = true
 				}
 			}
 
 		case expr[i] == '\'' || expr[i] == '"':
 			// If it is a string literal, parse it and
 			// add to the output queue.
-			quote := expr[i]
-			formattedPos := parsingCtx.FormatLineCol(i)
-
-			i++
-			str := []rune{}
-			for i < len(expr) && expr[i] != quote && expr[i] != '\n' {
-				if expr[i] == '\\' {
-					switch expr[i+1] {
-					case 'n':
-						i += 2
-						str = append(str, '\n')
-
-					case 't':
-						i += 2
-						str = append(str, '\t')
-
-					default:
-						switch expr[i+1] {
-						case '"', '\'':
-							i++
-						case '\n':
-							i++
-							parsingCtx.HandleNewLine(i)
-						}
-						str = append(str, expr[i])
-						i++
-					}
-				} else {
-					str = append(str, expr[i])
-					i++
-				}
+			start := i
+			var str string
+			i, str, err = parseStrLiteral(expr, i, &parsingCtx)
+			if err != nil {
+				return nil, err
 			}
 
-			if expr[i] != quote {
-				return nil, insights.SyntaxErr("string literal not terminated", map[string]any{
-					"startedAt": formattedPos,
-				})
+			err = rpnBuilder.handleToken(strToken(str))
+			if err != nil {
+				return nil, unexpectedTokenErr(strToken(str), parsingCtx, start, err)
 			}
-			i++
-			rpnBuilder.handleToken(strToken(string(str)))
 		default:
 			// Otherwise, the variable is an operator or parenthesis.
 			switch expr[i] {
 			case '(':
 				// If it is a function call:
 				if rpnBuilder.lastTokenWasOp == "no" {
+					err = resolveFunctionName(&rpnBuilder, parsingCtx, i)
+					if err != nil {
+						return nil, err
+					}
+
 					// This counts as a bracket and as an operator:
-					rpnBuilder.handleOp("()")
+					err = rpnBuilder.handleOp("()")
+					if err != nil {
+						return nil, unexpectedOpErr("()", parsingCtx, i, err)
+					}
 					// Add it as a bracket to the op stack:
 				}
 				rpnBuilder.openBracket("(")
 				i++
+			case '.':
+				start := i
+				var fieldName string
+				i, fieldName, err = parseFieldName(expr, i, &parsingCtx)
+				if err != nil {
+					return nil, err
+				}
+
+				if prevTokenWasVar {
+					rpnBuilder.extendLastVar(fieldName)
+					lastTokenWasVar = true
+					break
+				}
+
+				// Access the field of a computed value, e.g. `f(x).y`:
+				err = rpnBuilder.handleOp(".")
+				if err != nil {
+					return nil, unexpectedOpErr(".", parsingCtx, start, err)
+				}
+				err = rpnBuilder.handleToken(strToken(fieldName))
+				if err != nil {
+					return nil, unexpectedTokenErr(strToken(fieldName), parsingCtx, start, err)
+				}
 			case '[':
+				if prevTokenWasVar {
+					newIndex, key, isStatic, err := parseStaticSubscript(expr, i, &parsingCtx)
+					if err != nil {
+						return nil, err
+					}
+
+					if isStatic {
+						i = newIndex
+						rpnBuilder.extendLastVar(key)
+						lastTokenWasVar = true
+						break
+					}
+				}
+
 				if rpnBuilder.lastTokenWasOp == "no" {
 					// If it is an operator:
-					rpnBuilder.handleOp("[]")
+					err = rpnBuilder.handleOp("[]")
 				} else {
 					// If it is the list constructor:
 					// Add the list constructor to the rpn:
-					rpnBuilder.handleToken(Function(NewListToken))
-
-					// We make the program see it as a normal function call:
-					rpnBuilder.handleOp("()")
+					err = rpnBuilder.handleToken(Function(NewListToken))
+					if err == nil {
+						// We make the program see it as a normal function call:
+						err = rpnBuilder.handleOp("()")
+					}
+				}
+				if err != nil {
+					return nil, unexpectedOpErr("[", parsingCtx, i, err)
 				}
 				// Add it as a bracket to the op stack:
 				rpnBuilder.openBracket("[")
 				i++
 			case '{':
 				// Add a map constructor call to the rpn:
-				rpnBuilder.handleToken(Function(NewMapToken))
-
-				// We make the program see it as a normal function call:
-				rpnBuilder.handleOp("()")
+				err = rpnBuilder.handleToken(Function(NewMapToken))
+				if err == nil {
+					// We make the program see it as a normal function call:
+					err = rpnBuilder.handleOp("()")
+				}
+				if err != nil {
+					return nil, unexpectedOpErr("{", parsingCtx, i, err)
+				}
 				rpnBuilder.openBracket("{")
 				i++
 			case ')':
-				rpnBuilder.closeBracket("(")
+				err = rpnBuilder.closeBracket("(")
+				if err != nil {
+					return nil, err
+				}
 				i++
 			case ']':
-				rpnBuilder.closeBracket("[")
+				err = rpnBuilder.closeBracket("[")
+				if err != nil {
+					return nil, err
+				}
 				i++
 			case '}':
-				rpnBuilder.closeBracket("{")
+				err = rpnBuilder.closeBracket("{")
+				if err != nil {
+					return nil, err
+				}
 				i++
 			default:
 				{
@@ -222,7 +327,8 @@ func parse(strExpr string, vars map[string]Token) (_ []Token, err error) {
 					// Evaluate the meaning of this operator in the following order:
 					// 1. Is it a reserved word?
 					// 2. Is it a valid operator?
-					// 3. Is there a character parser for its first character?
+					// 3. Is it a sequence of valid operators? e.g. `!!`
+					// 4. Is there a character parser for its first character?
 					parser, isReservedWord := reservedWordParsers[op]
 					if isReservedWord {
 						// Parse reserved operators:
@@ -230,8 +336,19 @@ func parse(strExpr string, vars map[string]Token) (_ []Token, err error) {
 						if err != nil {
 							return nil, err
 						}
-					} else if _, isKnownOp := opPrecedence[op]; isKnownOp {
-						rpnBuilder.handleOp(op)
+					} else if isKnownOp(op) {
+						err = rpnBuilder.handleOp(op)
+						if err != nil {
+							return nil, unexpectedOpErr(op, parsingCtx, start, err)
+						}
+					} else if prefix := longestKnownOpPrefix(opRunes); prefix != "" {
+						// Handle only the prefix for now, the rest
+						// will be parsed on the next iterations:
+						i = start + len([]rune(prefix))
+						err = rpnBuilder.handleOp(prefix)
+						if err != nil {
+							return nil, unexpectedOpErr(prefix, parsingCtx, start, err)
+						}
 						// Maybe just the first character is an operator:
 					} else if parser, isReservedWord := reservedWordParsers[op[0:1]]; isReservedWord {
 						i = start + 1
@@ -285,10 +402,22 @@ func evaluate(originalRpn []Token, vars map[string]Token) (_ Token, err error) {
 	l := len(rpn)
 	for i := 0; i < l; i++ {
 		token := rpn[i]
+		if marker, ok := token.(shortCircuitToken); ok {
+			// The left operand is on the top of the stack, if it already
+			// decides the result we keep it and skip the right operand:
+			if len(evalStack) > 0 && decidesShortCircuit(marker.op, evalStack[len(evalStack)-1]) {
+				i = marker.target
+			}
+			continue
+		}
+
 		op, isOperator := token.(opToken)
 		if !isOperator {
 			if v, isVar := token.(varToken); isVar {
-				token = v.Resolve(data.Vars)
+				token, err = v.Resolve(data.Vars)
+				if err != nil {
+					return nil, err
+				}
 			}
 
 			evalStack = append(evalStack, token)
@@ -304,7 +433,10 @@ func evaluate(originalRpn []Token, vars map[string]Token) (_ Token, err error) {
 		switch v := right.(type) {
 		case refToken:
 			data.RightRef = v
-			right = v.Resolve(data.Vars)
+			right, err = v.Resolve(data.Vars)
+			if err != nil {
+				return nil, err
+			}
 		case varToken:
 			data.RightRef = refToken{key: v}
 		default:
@@ -314,14 +446,31 @@ func evaluate(originalRpn []Token, vars map[string]Token) (_ Token, err error) {
 		switch v := left.(type) {
 		case refToken:
 			data.LeftRef = v
-			left = v.Resolve(data.Vars)
+			left, err = v.Resolve(data.Vars)
+			if err != nil {
+				return nil, err
+			}
 		case varToken:
 			data.LeftRef = refToken{key: v}
 		default:
 			data.LeftRef = refToken{}
 		}
 
-		if fn, ok := left.(Function); ok && op == "()" {
+		if op == "," {
+			// Build the argument list of a function call:
+			if tuple, ok := left.(tupleToken); ok {
+				evalStack = append(evalStack, append(tuple, right))
+			} else {
+				evalStack = append(evalStack, tupleToken{left, right})
+			}
+		} else if op == ":" {
+			kv, err := newKeyValuePair(left, right)
+			if err != nil {
+				return nil, err
+			}
+
+			evalStack = append(evalStack, kv)
+		} else if fn, ok := left.(Function); ok && op == "()" {
 			var args tupleToken
 			if tuple, ok := right.(tupleToken); ok {
 				args = tuple
@@ -337,7 +486,7 @@ func evaluate(originalRpn []Token, vars map[string]Token) (_ Token, err error) {
 
 			resp, err := execFunc(fnReceiver, fn, args, data.Vars)
 			if err != nil {
-				return nil, insights.RuntimeErr("error parsing function", map[string]any{
+				return nil, insights.RuntimeErr("error executing function", map[string]any{
 					"error": err,
 				})
 			}
@@ -367,6 +516,22 @@ func evaluate(originalRpn []Token, vars map[string]Token) (_ Token, err error) {
 	return evalStack[0], nil
 }
 
+func decidesShortCircuit(op opToken, left Token) bool {
+	b, ok := left.(boolToken)
+	if !ok {
+		return false
+	}
+
+	switch op {
+	case "&&":
+		return !bool(b)
+	case "||":
+		return bool(b)
+	}
+
+	return false
+}
+
 func findAndRunOperator(op opToken, left Token, right Token, data *EvaluationData) (Token, error) {
 	opGroup := operators[op]
 	if opGroup == nil {
@@ -377,6 +542,25 @@ func findAndRunOperator(op opToken, left Token, right Token, data *EvaluationDat
 
 	opFunc := opGroup[newOpTypePair(left, right)]
 
+	// Missing values propagate through all operators
+	// except for the logical ones, see missingToken:
+	if opFunc == nil && (isMissing(left) || isMissing(right)) {
+		return missingToken{}, nil
+	}
+
+	// Any value can be compared with null:
+	if opFunc == nil && (op == "==" || op == "!=") && (isNull(left) || isNull(right)) {
+		return boolToken(isNull(left) == isNull(right) == (op == "==")), nil
+	}
+
+	if opFunc == nil && isBitwiseOp(op) {
+		return nil, insights.SyntaxErr("bitwise operators only support integer operands", map[string]any{
+			"op":         op,
+			"leftToken":  left,
+			"rightToken": right,
+		})
+	}
+
 	if opFunc == nil {
 		return nil, insights.SyntaxErr("unsupported types for operator", map[string]any{
 			"op":         op,
@@ -422,6 +606,158 @@ func consumeSpaces(expr []rune, index int, parsingCtx *ParsingCtx) (newIndex int
 	return index
 }
 
+// parseStrLiteral expects expr[index] to be the opening quote
+func parseStrLiteral(expr []rune, index int, parsingCtx *ParsingCtx) (newIndex int, _ string, _ error) {
+	quote := expr[index]
+	formattedPos := parsingCtx.FormatLineCol(index)
+
+	i := index + 1
+	str := []rune{}
+	for i < len(expr) && expr[i] != quote && expr[i] != '\n' {
+		if expr[i] == '\\' && i+1 < len(expr) {
+			switch expr[i+1] {
+			case 'n':
+				i += 2
+				str = append(str, '\n')
+
+			case 't':
+				i += 2
+				str = append(str, '\t')
+
+			default:
+				switch expr[i+1] {
+				case '"', '\'', '\\':
+					i++
+				case '\n':
+					i++
+					parsingCtx.HandleNewLine(i)
+				}
+				str = append(str, expr[i])
+				i++
+			}
+		} else {
+			str = append(str, expr[i])
+			i++
+		}
+	}
+
+	if i >= len(expr) || expr[i] != quote {
+		return 0, "", insights.SyntaxErr("string literal not terminated", map[string]any{
+			"startedAt": formattedPos,
+		})
+	}
+
+	return i + 1, string(str), nil
+}
+
+// parseFieldName parses the name after the member access operator
+// `.`, so it expects expr[index] to be the `.` character.
+func parseFieldName(expr []rune, index int, parsingCtx *ParsingCtx) (newIndex int, fieldName string, _ error) {
+	i := consumeSpaces(expr, index+1, parsingCtx)
+	if i >= len(expr) || !isVarChar(expr[i]) {
+		return 0, "", insights.SyntaxErr("expected field name after '.'", map[string]any{
+			"pos": parsingCtx.FormatLineCol(index),
+		})
+	}
+
+	i, fieldName = parseVar(expr, i)
+	return i, fieldName, nil
+}
+
+// parseStaticSubscript checks if the subscript starting at expr[index],
+// i.e. the `[` character, contains a single string or non-negative
+// integer literal, e.g. `["user-agent"]` or `[0]`, so it can be
+// folded into the path of the preceding varToken.
+//
+// Path segments that are integers are only used for indexing lists,
+// so string literals containing integers are never folded.
+//
+// If the subscript is not static isStatic will be false and
+// it should be parsed as a normal `[]` operator.
+func parseStaticSubscript(expr []rune, index int, parsingCtx *ParsingCtx) (newIndex int, key string, isStatic bool, _ error) {
+	// Parse it on a copy of the context so we can discard it if
+	// the subscript turns out not to be static:
+	ctx := *parsingCtx
+
+	i := consumeSpaces(expr, index+1, &ctx)
+	if i >= len(expr) {
+		return 0, "", false, nil
+	}
+
+	switch {
+	case expr[i] == '"' || expr[i] == '\'':
+		var err error
+		i, key, err = parseStrLiteral(expr, i, &ctx)
+		if err != nil {
+			return 0, "", false, err
+		}
+
+		// Keys that look like indexes, e.g. `["0"]`, are left for the
+		// `[]` operator, since they should not index into lists:
+		if _, err := strconv.Atoi(key); err == nil {
+			return 0, "", false, nil
+		}
+
+	case unicode.IsDigit(expr[i]):
+		start := i
+		for i < len(expr) && unicode.IsDigit(expr[i]) {
+			i++
+		}
+		key = string(expr[start:i])
+
+		// Only decimal literals are folded, e.g. 0x10 or 1.5
+		// are left for the `[]` operator to handle:
+		if len(key) > 1 && key[0] == '0' {
+			return 0, "", false, nil
+		}
+
+	default:
+		return 0, "", false, nil
+	}
+
+	i = consumeSpaces(expr, i, &ctx)
+	if i >= len(expr) || expr[i] != ']' {
+		return 0, "", false, nil
+	}
+
+	*parsingCtx = ctx
+	return i + 1, key, true, nil
+}
+
+func unexpectedTokenErr(token Token, parsingCtx ParsingCtx, index int, err error) error {
+	return insights.SyntaxErr("unexpected token", map[string]any{
+		"token": token,
+		"pos":   parsingCtx.FormatLineCol(index),
+		"error": err,
+	})
+}
+
+func unexpectedOpErr(op string, parsingCtx ParsingCtx, index int, err error) error {
+	return insights.SyntaxErr("unexpected operator", map[string]any{
+		"op":    op,
+		"pos":   parsingCtx.FormatLineCol(index),
+		"error": err,
+	})
+}
+
+// isKnownOp checks if op is a binary or a unary operator:
+func isKnownOp(op string) bool {
+	_, isBinary := opPrecedence[op]
+	_, isLeftUnary := opPrecedence["L"+op]
+	_, isRightUnary := opPrecedence["R"+op]
+	return isBinary || isLeftUnary || isRightUnary
+}
+
+func longestKnownOpPrefix(opRunes []rune) string {
+	for l := len(opRunes) - 1; l > 0; l-- {
+		if isKnownOp(string(opRunes[:l])) {
+			return string(opRunes[:l])
+		}
+	}
+
+	return ""
+}
+
 // isVarChar checks if a character is the first character of a variable:
 func isVarChar(c rune) bool {
 	return unicode.IsLetter(c) || c == '_'
@@ -483,23 +819,42 @@ func parseNumber(expr []rune, index int) (newIndex int, token Token, err error)
 		isNumberFn = isValidHexDigit
 	}
 
+	// Find the end of the integer part of the literal:
+	for i < len(expr) && isNumberFn(expr[i]) {
+		i++
+	}
+
 	isFloat := false
 
-	// Find the end of the numerical literal:
-	for ; i < len(expr) && isNumberFn(expr[i]); i++ {
-		// Consume the decimal part of the number:
-		if expr[i] == '.' {
+	// Consume the decimal part of the number:
+	if i+1 < len(expr) && expr[i] == '.' && unicode.IsNumber(expr[i+1]) {
+		isFloat = true
+		i++
+		for i < len(expr) && unicode.IsNumber(expr[i]) {
 			i++
-			isFloat = true
+		}
+	}
 
-			for i < len(expr) && isNumberFn(expr[i]) {
+	// Consume the exponent part of the number, e.g. 1e6 or 2.5E-3:
+	if base == 10 && i < len(expr) && (expr[i] == 'e' || expr[i] == 'E') {
+		j := i + 1
+		if j < len(expr) && (expr[j] == '+' || expr[j] == '-') {
+			j++
+		}
+		if j < len(expr) && unicode.IsNumber(expr[j]) {
+			isFloat = true
+			i = j
+			for i < len(expr) && unicode.IsNumber(expr[i]) {
 				i++
 			}
-
-			break
 		}
 	}
 
+	// Numbers followed by a unit are duration literals, e.g. `5m`:
+	if base == 10 && i < len(expr) && unicode.IsLetter(expr[i]) {
+		return parseDurationLiteral(expr, index)
+	}
+
 	if isFloat {
 		if base != 10 {
 			return 0, nil, insights.SyntaxErr("only base 10 literals can have decimals", map[string]any{
@@ -509,17 +864,16 @@ func parseNumber(expr []rune, index int) (newIndex int, token Token, err error)
 
 		num, err := strconv.ParseFloat(string(expr[index:i]), 64)
 		if err != nil {
-			panic(
-				fmt.Errorf("unexpected error parsing a previously validated number '%s': %w",
-					string(expr[index:i]), err,
-				),
-			)
+			return 0, nil, insights.SyntaxErr("error parsing numeric literal", map[string]any{
+				"literal": string(expr[index:i]),
+				"error":   err,
+			})
 		}
 
 		return i, floatToken(num), nil
 	}
 
-	num, err := strconv.ParseInt(string(expr[index:i]), 0, 64)
+	num, err := parseIntLiteral(string(expr[index:i]), 0)
 	if err != nil {
 		return 0, nil, insights.SyntaxErr("error parsing numeric literal", map[string]any{
 			"literal": string(expr[index:i]),
@@ -527,15 +881,102 @@ func parseNumber(expr []rune, index int) (newIndex int, token Token, err error)
 		})
 	}
 
-	return i, intToken(num), nil
+	return i, num, nil
+}
+
+// durationUnits are the units accepted on duration literals,
+// note that days and weeks always have 24h and 7 days.
+var durationUnits = map[string]time.Duration{
+	"ns": time.Nanosecond,
+	"us": time.Microsecond,
+	"ms": time.Millisecond,
+	"s":  time.Second,
+	"m":  time.Minute,
+	"h":  time.Hour,
+	"d":  24 * time.Hour,
+	"w":  7 * 24 * time.Hour,
+}
+
+// parseDurationLiteral parses literals such as `5m`, `1.5h` or
+// `1h30m`, i.e. one or more numbers each followed by a unit
+func parseDurationLiteral(expr []rune, index int) (newIndex int, token Token, err error) {
+	var total time.Duration
+	i := index
+	for i < len(expr) && unicode.IsNumber(expr[i]) {
+		start := i
+		for i < len(expr) && (unicode.IsNumber(expr[i]) || expr[i] == '.') {
+			i++
+		}
+		num, err := strconv.ParseFloat(string(expr[start:i]), 64)
+		if err != nil {
+			return 0, nil, insights.SyntaxErr("invalid duration literal", map[string]any{
+				"literal": string(expr[index:i]),
+				"error":   err,
+			})
+		}
+
+		start = i
+		for i < len(expr) && unicode.IsLetter(expr[i]) {
+			i++
+		}
+		unit, found := durationUnits[string(expr[start:i])]
+		if !found {
+			return 0, nil, insights.SyntaxErr("invalid duration unit", map[string]any{
+				"literal": string(expr[index:i]),
+				"unit":    string(expr[start:i]),
+				"valid":   "ns, us, ms, s, m, h, d or w",
+			})
+		}
+
+		total += time.Duration(num * float64(unit))
+	}
+
+	return i, durationToken(total), nil
 }
 
 func execFunc(this mapToken, fn Function, args tupleToken, vars mapToken) (Token, error) {
 	vars = vars.getChildMap()
-	fn(args, mapToken{
+	resp, err := fn(args, mapToken{
 		"$parent": vars,
 		"this":    this,
 	})
+	if err != nil {
+		return nil, err
+	}
+
+	if resp == nil {
+		return nil, insights.InternalErr("function returned a nil token", map[string]any{
+			"args": args,
+		})
+	}
+
+	return resp, nil
+}
+
+// resolveFunctionName replaces the name of the function
+// being called, which is the last token on the rpn,
+// with the matching function from the builtin registry.
+func resolveFunctionName(rpnBuilder *RPNBuilder, parsingCtx ParsingCtx, index int) error {
+	l := len(rpnBuilder.rpn)
+	name, isVar := rpnBuilder.rpn[l-1].(varToken)
+	if !isVar {
+		// Not a named function call, e.g. `(a)(b)`, so we
+		// let the evaluation decide if this is valid:
+		return nil
+	}
+
+	fn, found := builtinFunctions[name.String()]
+	if len(name) != 1 || !found {
+		return insights.SyntaxErr("unknown function", map[string]any{
+			"name": name.String(),
+			"pos":  parsingCtx.FormatLineCol(index),
+		})
+	}
+
+	if !missingAwareFunctions[name.String()] {
+		fn = propagateMissing(fn)
+	}
 
-	return nil, nil
+	rpnBuilder.rpn[l-1] = fn
+	return nil
 }
@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	"github.com/vingarcia/insights/internal/adapters/evaluator"
+	tt "github.com/vingarcia/insights/internal/testtools"
 )
 
 func TestParse(t *testing.T) {
@@ -12,3 +13,124 @@ func TestParse(t *testing.T) {
 		return Parse(expr)
 	})
 }
+
+func TestParseValue(t *testing.T) {
+	// This Test function runs all the value expression interface tests at once:
+	evaluator.TestValue(t, func(expr string) (evaluator.ValueExpression, error) {
+		return ParseValue(expr)
+	})
+}
+
+func TestShortCircuit(t *testing.T) {
+	tests := []struct {
+		expr           string
+		expectedResult bool
+	}{
+		{expr: "a == 0 && b == 1", expectedResult: false},
+		{expr: "a == 1 || b == 1", expectedResult: true},
+		{expr: "(a == 0 && b == 1) || a == 1", expectedResult: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.expr, func(t *testing.T) {
+			rpn, err := parse(test.expr, nil)
+			tt.AssertNoErr(t, err)
+
+			// `b` contains invalid JSON so decoding it would panic:
+			result, err := evaluate(rpn, mapToken{
+				"a": &lazyJsonToken{json: []byte("1")},
+				"b": &lazyJsonToken{json: []byte("not valid JSON")},
+			})
+			tt.AssertNoErr(t, err)
+			tt.AssertEqual(t, result, boolToken(test.expectedResult))
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		expr               string
+		expectErrToContain []string
+	}{
+		{expr: "-", expectErrToContain: []string{"SyntaxErr", "expected operand after unary operator", "-"}},
+		{expr: "a == !", expectErrToContain: []string{"SyntaxErr", "expected operand after unary operator", "!"}},
+		{expr: "(-)", expectErrToContain: []string{"SyntaxErr", "expected operand after unary operator"}},
+		{expr: "1 +", expectErrToContain: []string{"SyntaxErr", "expected operand after operator", "+"}},
+		{expr: "(1 +)", expectErrToContain: []string{"SyntaxErr", "expected operand after operator", "+"}},
+		{expr: "1 * * 2", expectErrToContain: []string{"SyntaxErr", "unrecognized unary operator", "*"}},
+		{expr: "(1 + 2", expectErrToContain: []string{"SyntaxErr", "missing closing bracket"}},
+		{expr: "1 + 2)", expectErrToContain: []string{"SyntaxErr", "extra closing bracket"}},
+		{expr: "a > 1e400", expectErrToContain: []string{"SyntaxErr", "error parsing numeric literal", "1e400"}},
+		{expr: "unknown_fn(a) == 1", expectErrToContain: []string{"SyntaxErr", "unknown function", "unknown_fn"}},
+		{expr: `bin(ts, 5x) == ""`, expectErrToContain: []string{"SyntaxErr", "invalid duration unit", "5x"}},
+		{expr: "x == .foo", expectErrToContain: []string{"SyntaxErr", "unexpected operator", "op = .", "pos = 0:5"}},
+		{expr: ".foo", expectErrToContain: []string{"SyntaxErr", "unexpected operator", "op = .", "pos = 0:0"}},
+		{expr: "a == 1 2", expectErrToContain: []string{"SyntaxErr", "unexpected token", "token = 2", "pos = 0:7"}},
+		{expr: "(a) b", expectErrToContain: []string{"SyntaxErr", "unexpected token", "token = b", "pos = 0:4"}},
+		{expr: `a "b"`, expectErrToContain: []string{"SyntaxErr", "unexpected token", "pos = 0:2"}},
+		{expr: "status is [500]", expectErrToContain: []string{"SyntaxErr", "unexpected token", "token = is", "pos = 0:7"}},
+		{expr: "a {}", expectErrToContain: []string{"SyntaxErr", "unexpected operator", "pos = 0:2"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.expr, func(t *testing.T) {
+			_, err := Parse(test.expr)
+			tt.AssertErrContains(t, err, test.expectErrToContain...)
+		})
+	}
+}
+
+func TestStaticPathFolding(t *testing.T) {
+	rpn, err := parse(`a.b[0]["c d"] . e`, nil)
+	tt.AssertNoErr(t, err)
+	tt.AssertEqual(t, rpn, []Token{varToken{"a", "b", "0", "c d", "e"}})
+
+	// Only the values on the path should be decoded, so
+	// the invalid JSON on `a.other` should be ignored:
+	m := mapToken{
+		"a": mapToken{
+			"b": listToken{
+				&lazyJsonToken{json: []byte(`{"c d": {"e": 42}}`)},
+			},
+			"other": &lazyJsonToken{json: []byte("not valid JSON")},
+		},
+	}
+
+	result, err := evaluate(rpn, m)
+	tt.AssertNoErr(t, err)
+	tt.AssertEqual(t, result, intToken(42))
+}
+
+func TestEvaluateRecord(t *testing.T) {
+	where, err := Parse("status >= 500")
+	tt.AssertNoErr(t, err)
+	latency, err := ParseValue("latency_ms / 1000")
+	tt.AssertNoErr(t, err)
+
+	record := evaluator.NewRecord([]byte(`{"status": 502, "latency_ms": 250}`))
+	match, err := where.EvaluateRecord(record)
+	tt.AssertNoErr(t, err)
+	tt.AssertEqual(t, match, true)
+
+	// The record should only be parsed once, so changing
+	// the raw JSON should not affect the next expressions:
+	record.Raw = []byte("not valid JSON")
+	value, err := latency.EvaluateRecord(record)
+	tt.AssertNoErr(t, err)
+	tt.AssertEqual(t, value, 0.25)
+
+	_, err = where.EvaluateRecord(evaluator.NewRecord([]byte("not valid JSON")))
+	tt.AssertErrContains(t, err, "bad input json received")
+}
+
+func TestLazyJsonTokenCache(t *testing.T) {
+	token := &lazyJsonToken{json: []byte(`{"a": 1}`)}
+	value, err := token.Value()
+	tt.AssertNoErr(t, err)
+
+	// The value should be decoded only once:
+	token.json = []byte("not valid JSON")
+	cached, err := token.Value()
+	tt.AssertNoErr(t, err)
+	tt.AssertEqual(t, cached, value)
+}
@@ -0,0 +1,327 @@
+package eparser
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/vingarcia/insights"
+)
+
+// builtinFunctions is the registry of functions available on
+// expressions, any identifier followed by `(` is resolved
+// against this map at parsing time, e.g. `len(tags)`
+var builtinFunctions = map[string]Function{
+	"len":         lenFunc,
+	"lower":       lowerFunc,
+	"upper":       upperFunc,
+	"trim":        trimFunc,
+	"contains":    containsFunc,
+	"starts_with": startsWithFunc,
+	"ends_with":   endsWithFunc,
+	"regex_match": regexMatchFunc,
+	"int":         intFunc,
+	"float":       floatFunc,
+	"str":         strFunc,
+	"abs":         absFunc,
+	"exists":      existsFunc,
+	"is_null":     isNullFunc,
+	"bin":         binFunc,
+	"date_trunc":  dateTruncFunc,
+}
+
+// missingAwareFunctions lists the functions that receive missing
+// arguments, all other functions just return missing when any of
+// their arguments is missing, see missingToken for more details.
+var missingAwareFunctions = map[string]bool{
+	"exists":  true,
+	"is_null": true,
+}
+
+func propagateMissing(fn Function) Function {
+	return func(args []Token, scope mapToken) (Token, error) {
+		for _, arg := range args {
+			if isMissing(arg) {
+				return missingToken{}, nil
+			}
+		}
+
+		return fn(args, scope)
+	}
+}
+
+// existsFunc returns true if the field exists
+// on the record even if its value is null
+func existsFunc(args []Token, scope mapToken) (Token, error) {
+	err := expectNumArgs("exists", args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	return boolToken(!isMissing(args[0])), nil
+}
+
+// isNullFunc returns true if the field is either
+// null or doesn't exist on the record
+func isNullFunc(args []Token, scope mapToken) (Token, error) {
+	err := expectNumArgs("is_null", args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	return boolToken(isMissing(args[0]) || isNull(args[0])), nil
+}
+
+func lenFunc(args []Token, scope mapToken) (Token, error) {
+	err := expectNumArgs("len", args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	switch v := args[0].(type) {
+	case strToken:
+		return intToken(utf8.RuneCountInString(string(v))), nil
+	case listToken:
+		return intToken(len(v)), nil
+	case mapToken:
+		return intToken(len(v)), nil
+	}
+
+	return nil, unexpectedArgErr("len", 0, "a string, list or map", args[0])
+}
+
+func lowerFunc(args []Token, scope mapToken) (Token, error) {
+	str, err := expectSingleStrArg("lower", args)
+	if err != nil {
+		return nil, err
+	}
+
+	return strToken(strings.ToLower(str)), nil
+}
+
+func upperFunc(args []Token, scope mapToken) (Token, error) {
+	str, err := expectSingleStrArg("upper", args)
+	if err != nil {
+		return nil, err
+	}
+
+	return strToken(strings.ToUpper(str)), nil
+}
+
+func trimFunc(args []Token, scope mapToken) (Token, error) {
+	str, err := expectSingleStrArg("trim", args)
+	if err != nil {
+		return nil, err
+	}
+
+	return strToken(strings.TrimSpace(str)), nil
+}
+
+func containsFunc(args []Token, scope mapToken) (Token, error) {
+	str, substr, err := expectTwoStrArgs("contains", args)
+	if err != nil {
+		return nil, err
+	}
+
+	return boolToken(strings.Contains(str, substr)), nil
+}
+
+func startsWithFunc(args []Token, scope mapToken) (Token, error) {
+	str, prefix, err := expectTwoStrArgs("starts_with", args)
+	if err != nil {
+		return nil, err
+	}
+
+	return boolToken(strings.HasPrefix(str, prefix)), nil
+}
+
+func endsWithFunc(args []Token, scope mapToken) (Token, error) {
+	str, suffix, err := expectTwoStrArgs("ends_with", args)
+	if err != nil {
+		return nil, err
+	}
+
+	return boolToken(strings.HasSuffix(str, suffix)), nil
+}
+
+// regexCache avoids recompiling the same pattern for every
+// evaluated record, since patterns are almost always literals
+var regexCache sync.Map
+
+func regexMatchFunc(args []Token, scope mapToken) (Token, error) {
+	str, pattern, err := expectTwoStrArgs("regex_match", args)
+	if err != nil {
+		return nil, err
+	}
+
+	re, found := regexCache.Load(pattern)
+	if !found {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, insights.RuntimeErr("invalid regular expression", map[string]any{
+				"pattern": pattern,
+				"error":   err,
+			})
+		}
+		re, _ = regexCache.LoadOrStore(pattern, compiled)
+	}
+
+	return boolToken(re.(*regexp.Regexp).MatchString(str)), nil
+}
+
+func intFunc(args []Token, scope mapToken) (Token, error) {
+	err := expectNumArgs("int", args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	switch v := args[0].(type) {
+	case intToken:
+		return v, nil
+	case floatToken:
+		if math.IsNaN(float64(v)) || v >= math.MaxInt64 || v < math.MinInt64 {
+			return nil, insights.RuntimeErr("float value out of the int range", map[string]any{
+				"value": v,
+			})
+		}
+		return intToken(v), nil
+	case strToken:
+		i, err := strconv.ParseInt(strings.TrimSpace(string(v)), 0, 64)
+		if err != nil {
+			return nil, insights.RuntimeErr("unable to convert string to int", map[string]any{
+				"value": v,
+				"error": err,
+			})
+		}
+		return intToken(i), nil
+	case boolToken:
+		if v {
+			return intToken(1), nil
+		}
+		return intToken(0), nil
+	}
+
+	return nil, unexpectedArgErr("int", 0, "a number, string or bool", args[0])
+}
+
+func floatFunc(args []Token, scope mapToken) (Token, error) {
+	err := expectNumArgs("float", args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	switch v := args[0].(type) {
+	case intToken:
+		return floatToken(v), nil
+	case floatToken:
+		return v, nil
+	case strToken:
+		f, err := strconv.ParseFloat(strings.TrimSpace(string(v)), 64)
+		if err != nil {
+			return nil, insights.RuntimeErr("unable to convert string to float", map[string]any{
+				"value": v,
+				"error": err,
+			})
+		}
+		return floatToken(f), nil
+	}
+
+	return nil, unexpectedArgErr("float", 0, "a number or string", args[0])
+}
+
+func strFunc(args []Token, scope mapToken) (Token, error) {
+	err := expectNumArgs("str", args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	if str, ok := args[0].(strToken); ok {
+		return str, nil
+	}
+
+	return strToken(args[0].String()), nil
+}
+
+func absFunc(args []Token, scope mapToken) (Token, error) {
+	err := expectNumArgs("abs", args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	switch v := args[0].(type) {
+	case intToken:
+		if v == math.MinInt64 {
+			return nil, insights.RuntimeErr("integer overflow", map[string]any{
+				"function": "abs",
+				"value":    v,
+			})
+		}
+		if v < 0 {
+			return -v, nil
+		}
+		return v, nil
+	case floatToken:
+		return floatToken(math.Abs(float64(v))), nil
+	}
+
+	return nil, unexpectedArgErr("abs", 0, "a number", args[0])
+}
+
+// * * * * * Argument validation helpers: * * * * * //
+
+func expectNumArgs(fnName string, args []Token, n int) error {
+	if len(args) != n {
+		return insights.RuntimeErr("wrong number of arguments for function", map[string]any{
+			"function": fnName,
+			"expected": n,
+			"received": len(args),
+		})
+	}
+
+	return nil
+}
+
+func expectSingleStrArg(fnName string, args []Token) (string, error) {
+	err := expectNumArgs(fnName, args, 1)
+	if err != nil {
+		return "", err
+	}
+
+	str, ok := args[0].(strToken)
+	if !ok {
+		return "", unexpectedArgErr(fnName, 0, "a string", args[0])
+	}
+
+	return string(str), nil
+}
+
+func expectTwoStrArgs(fnName string, args []Token) (string, string, error) {
+	err := expectNumArgs(fnName, args, 2)
+	if err != nil {
+		return "", "", err
+	}
+
+	str1, ok := args[0].(strToken)
+	if !ok {
+		return "", "", unexpectedArgErr(fnName, 0, "a string", args[0])
+	}
+
+	str2, ok := args[1].(strToken)
+	if !ok {
+		return "", "", unexpectedArgErr(fnName, 1, "a string", args[1])
+	}
+
+	return string(str1), string(str2), nil
+}
+
+func unexpectedArgErr(fnName string, argIdx int, expected string, received Token) error {
+	return insights.RuntimeErr("unexpected argument type for function", map[string]any{
+		"function": fnName,
+		"argument": argIdx,
+		"expected": expected,
+		"received": received,
+	})
+}
@@ -1,8 +1,13 @@
 package eparser
 
 import (
-	"fmt"
+	"math"
 	"reflect"
+	"strings"
+	"unicode"
+
+	"github.com/vingarcia/insights"
+	"github.com/vingarcia/insights/internal"
 )
 
 // Operator represents all types of operators including
@@ -15,17 +20,22 @@ type Operator func(Token, Token, opToken, *EvaluationData) (Token, error)
 // Create the operator precedence map based on C++ default
 // precedence order as described on cppreference website:
 // http://en.cppreference.com/w/cpp/language/operator_precedence
+//
+// The only exception are the bitwise operators `&`, `^` and `|`
+// which bind tighter than the comparison operators (as in Go)
+// so that `flags & 0x04 != 0` works as expected.
 var opPrecedence = map[string]int{
 	"[]": 2, "()": 2, ".": 2,
 	"**": 3,
 	"*":  5, "/": 5, "%": 5,
 	"+": 6, "-": 6,
 	"<<": 7, ">>": 7,
-	"<": 9, "<=": 9, ">=": 9, ">": 9,
-	"==": 10, "!=": 10,
-	"&":  11,
-	"^":  12,
-	"|":  13,
+	"&": 8,
+	"^": 9,
+	"|": 10,
+	"<": 11, "<=": 11, ">=": 11, ">": 11,
+	"in": 11,
+	"==": 12, "!=": 12,
 	"&&": 14,
 	"||": 15,
 	"=":  16,
@@ -35,10 +45,26 @@ var opPrecedence = map[string]int{
 	// Unary operators' precence is prefixed by L or R implying
 	// they operate on the left or on the right side of the token.
 	// E.g. ++ in Go is a right side unary operator, ! is a left side.
+	//
+	// Note that `-2 ** 2 == -4` since `**` is right associative
+	// and `a.b` binds tighter so `-a.b` is the same as `-(a.b)`.
 	"L-": 3, "L+": 3, "L!": 3,
 
-	// TODO(vingarcia): Check if we really need this one:
-	"!": 3,
+	// The word `not` binds looser than the comparisons just like
+	// on SQL and Python, so `not a == 1` is the same as `!(a == 1)`:
+	"Lnot": 13,
+
+	// Open brackets should never be popped by other operators, only
+	// by their matching closing bracket, so they get the lowest priority:
+	"(": maxPrecedence, "[": maxPrecedence, "{": maxPrecedence,
+}
+
+const maxPrecedence = 0xFFFFFF
+
+// rightAssociativeOps lists the operators that group from right
+// to left, e.g. `2 ** 3 ** 2` is evaluated as `2 ** (3 ** 2)`
+var rightAssociativeOps = map[string]bool{
+	"**": true,
 }
 
 type opTypePair struct {
@@ -54,20 +80,160 @@ func newOpTypePair(t1 any, t2 any) opTypePair {
 }
 
 var operators = map[opToken]map[opTypePair]Operator{
-	// ">":  greaterThanOp,
-	// "<":  lesserThanOp,
-	"==": map[opTypePair]Operator{
-		newOpTypePair(floatToken(0), floatToken(0)): equalsFloatOp,
-		newOpTypePair(intToken(0), intToken(0)):     equalsIntOp,
-		newOpTypePair(floatToken(0), intToken(0)):   equalsFloatIntOp,
-		newOpTypePair(intToken(0), floatToken(0)):   equalsIntFloatOp,
-	},
+	"==": equalityOps,
 	"!=": map[opTypePair]Operator{
-		newOpTypePair(floatToken(0), floatToken(0)): differsOp,
-		newOpTypePair(intToken(0), intToken(0)):     differsOp,
-		newOpTypePair(floatToken(0), intToken(0)):   differsFloatIntOp,
-		newOpTypePair(intToken(0), floatToken(0)):   differsIntFloatOp,
+		newOpTypePair(floatToken(0), floatToken(0)):       differsOp,
+		newOpTypePair(intToken(0), intToken(0)):           differsOp,
+		newOpTypePair(floatToken(0), intToken(0)):         differsFloatIntOp,
+		newOpTypePair(intToken(0), floatToken(0)):         differsIntFloatOp,
+		newOpTypePair(strToken(""), strToken("")):         differsOp,
+		newOpTypePair(boolToken(false), boolToken(false)): differsOp,
+	},
+	"&&": map[opTypePair]Operator{
+		newOpTypePair(boolToken(false), boolToken(false)): andOp,
+		newOpTypePair(boolToken(false), missingToken{}):   andOp,
+		newOpTypePair(missingToken{}, boolToken(false)):   andOp,
+		newOpTypePair(missingToken{}, missingToken{}):     andOp,
+	},
+	"||": map[opTypePair]Operator{
+		newOpTypePair(boolToken(false), boolToken(false)): orOp,
+		newOpTypePair(boolToken(false), missingToken{}):   orOp,
+		newOpTypePair(missingToken{}, boolToken(false)):   orOp,
+		newOpTypePair(missingToken{}, missingToken{}):     orOp,
+	},
+	"<":  orderingOps,
+	"<=": orderingOps,
+	">":  orderingOps,
+	">=": orderingOps,
+	"+": map[opTypePair]Operator{
+		newOpTypePair(unaryPlaceholderToken{}, intToken(0)):   unaryPlusOp,
+		newOpTypePair(unaryPlaceholderToken{}, floatToken(0)): unaryPlusOp,
+
+		newOpTypePair(intToken(0), intToken(0)):     intArithmeticOp,
+		newOpTypePair(floatToken(0), floatToken(0)): floatArithmeticOp,
+		newOpTypePair(intToken(0), floatToken(0)):   intFloatArithmeticOp,
+		newOpTypePair(floatToken(0), intToken(0)):   floatIntArithmeticOp,
+		newOpTypePair(strToken(""), strToken("")):   concatStrOp,
+	},
+	"-": map[opTypePair]Operator{
+		newOpTypePair(unaryPlaceholderToken{}, intToken(0)):   negateIntOp,
+		newOpTypePair(unaryPlaceholderToken{}, floatToken(0)): negateFloatOp,
+
+		newOpTypePair(intToken(0), intToken(0)):     intArithmeticOp,
+		newOpTypePair(floatToken(0), floatToken(0)): floatArithmeticOp,
+		newOpTypePair(intToken(0), floatToken(0)):   intFloatArithmeticOp,
+		newOpTypePair(floatToken(0), intToken(0)):   floatIntArithmeticOp,
+	},
+	"&":  bitwiseOps,
+	"|":  bitwiseOps,
+	"^":  bitwiseOps,
+	"<<": bitwiseOps,
+	">>": bitwiseOps,
+	"in": map[opTypePair]Operator{
+		newOpTypePair(intToken(0), listToken{}):      inListOp,
+		newOpTypePair(bigIntToken(""), listToken{}):  inListOp,
+		newOpTypePair(floatToken(0), listToken{}):    inListOp,
+		newOpTypePair(strToken(""), listToken{}):     inListOp,
+		newOpTypePair(boolToken(false), listToken{}): inListOp,
+		newOpTypePair(nullToken{}, listToken{}):      inListOp,
+		newOpTypePair(strToken(""), mapToken{}):      inMapOp,
+		newOpTypePair(strToken(""), strToken("")):    inStrOp,
+	},
+	".": map[opTypePair]Operator{
+		newOpTypePair(mapToken{}, strToken("")): subscriptOp,
 	},
+	"[]": map[opTypePair]Operator{
+		newOpTypePair(mapToken{}, strToken("")):  subscriptOp,
+		newOpTypePair(listToken{}, intToken(0)):  subscriptOp,
+		newOpTypePair(listToken{}, strToken("")): subscriptOp,
+	},
+	"!": map[opTypePair]Operator{
+		newOpTypePair(unaryPlaceholderToken{}, boolToken(false)): notOp,
+	},
+	"not": map[opTypePair]Operator{
+		newOpTypePair(unaryPlaceholderToken{}, boolToken(false)): notOp,
+	},
+	"*":  arithmeticOps,
+	"/":  arithmeticOps,
+	"%":  arithmeticOps,
+	"**": arithmeticOps,
+}
+
+// arithmeticOps are shared by the arithmetic operators.
+//
+// The promotion rules are: if both operands are ints the result is
+// also an int, otherwise the int operand is promoted to float and
+// the result is a float.
+//
+// The exceptions are `/`, which always produces a float so the type
+// of the result doesn't depend on the values, e.g. `7 / 2 == 3.5`
+// and `6 / 2 == 3.0`, use `int(7 / 2)` for truncating, and `**`
+// with a negative int exponent, e.g. `2 ** -1 == 0.5`.
+var arithmeticOps = map[opTypePair]Operator{
+	newOpTypePair(intToken(0), intToken(0)):     intArithmeticOp,
+	newOpTypePair(floatToken(0), floatToken(0)): floatArithmeticOp,
+	newOpTypePair(intToken(0), floatToken(0)):   intFloatArithmeticOp,
+	newOpTypePair(floatToken(0), intToken(0)):   floatIntArithmeticOp,
+}
+
+var equalityOps = map[opTypePair]Operator{
+	newOpTypePair(floatToken(0), floatToken(0)):       equalsFloatOp,
+	newOpTypePair(intToken(0), intToken(0)):           equalsIntOp,
+	newOpTypePair(floatToken(0), intToken(0)):         equalsFloatIntOp,
+	newOpTypePair(intToken(0), floatToken(0)):         equalsIntFloatOp,
+	newOpTypePair(strToken(""), strToken("")):         equalsOp,
+	newOpTypePair(boolToken(false), boolToken(false)): equalsOp,
+}
+
+// orderingOps are shared by all the ordering comparison
+// operators, i.e. `<`, `<=`, `>` and `>=`, the actual
+// comparison is decided by the opToken received.
+var orderingOps = map[opTypePair]Operator{
+	newOpTypePair(intToken(0), intToken(0)):     compareIntOp,
+	newOpTypePair(floatToken(0), floatToken(0)): compareFloatOp,
+	newOpTypePair(intToken(0), floatToken(0)):   compareIntFloatOp,
+	newOpTypePair(floatToken(0), intToken(0)):   compareFloatIntOp,
+	newOpTypePair(strToken(""), strToken("")):   compareStrOp,
+}
+
+// bigIntTypePairs lists all the type pairs involving bigIntTokens,
+// which are registered on the comparison and arithmetic operators
+// by the init function below.
+var bigIntTypePairs = []opTypePair{
+	newOpTypePair(bigIntToken(""), bigIntToken("")),
+	newOpTypePair(bigIntToken(""), intToken(0)),
+	newOpTypePair(intToken(0), bigIntToken("")),
+	newOpTypePair(bigIntToken(""), floatToken(0)),
+	newOpTypePair(floatToken(0), bigIntToken("")),
+}
+
+func init() {
+	for _, pair := range bigIntTypePairs {
+		for _, op := range []opToken{"==", "!=", "<", "<=", ">", ">="} {
+			operators[op][pair] = compareBigIntOp
+		}
+		for _, op := range []opToken{"+", "-", "*", "/", "%", "**"} {
+			operators[op][pair] = bigIntArithmeticOp
+		}
+	}
+
+	operators["-"][newOpTypePair(unaryPlaceholderToken{}, bigIntToken(""))] = negateBigIntOp
+	operators["+"][newOpTypePair(unaryPlaceholderToken{}, bigIntToken(""))] = unaryPlusOp
+}
+
+// bitwiseOps are shared by the bitwise and shift operators,
+// which are only defined for int operands.
+var bitwiseOps = map[opTypePair]Operator{
+	newOpTypePair(intToken(0), intToken(0)): bitwiseOp,
+}
+
+func isBitwiseOp(op opToken) bool {
+	switch op {
+	case "&", "|", "^", "<<", ">>":
+		return true
+	}
+
+	return false
 }
 
 // opRunes contains the list of runes used
@@ -78,6 +244,11 @@ var opRunesSet = func() (runes map[rune]bool) {
 	runeSet := map[rune]bool{}
 	for k := range operators {
 		for _, c := range k {
+			// Letters are used by word operators such as `in`,
+			// which are parsed as reserved words instead:
+			if unicode.IsLetter(c) {
+				continue
+			}
 			runeSet[c] = true
 		}
 	}
@@ -85,32 +256,409 @@ var opRunesSet = func() (runes map[rune]bool) {
 	return runeSet
 }()
 
-func greaterThanOp(t1 Token, t2 Token, op opToken, data *EvaluationData) (Token, error) {
-	f1, ok := t1.(floatToken)
-	if !ok {
-		return nil, fmt.Errorf("expected numeral as the left operand for operator '>', but got: %v", t1)
+func compareIntOp(t1 Token, t2 Token, op opToken, data *EvaluationData) (Token, error) {
+	return compare(op, t1.(intToken), t2.(intToken))
+}
+
+func compareFloatOp(t1 Token, t2 Token, op opToken, data *EvaluationData) (Token, error) {
+	return compare(op, t1.(floatToken), t2.(floatToken))
+}
+
+// compareIntFloatOp promotes the int operand to float before comparing:
+func compareIntFloatOp(t1 Token, t2 Token, op opToken, data *EvaluationData) (Token, error) {
+	return compare(op, floatToken(t1.(intToken)), t2.(floatToken))
+}
+
+// compareFloatIntOp promotes the int operand to float before comparing:
+func compareFloatIntOp(t1 Token, t2 Token, op opToken, data *EvaluationData) (Token, error) {
+	return compare(op, t1.(floatToken), floatToken(t2.(intToken)))
+}
+
+// compareStrOp compares strings lexicographically byte by byte,
+// which for UTF-8 strings is the same as comparing by code points.
+func compareStrOp(t1 Token, t2 Token, op opToken, data *EvaluationData) (Token, error) {
+	return compare(op, t1.(strToken), t2.(strToken))
+}
+
+func compare[T intToken | floatToken | strToken](op opToken, v1 T, v2 T) (Token, error) {
+	switch op {
+	case "<":
+		return boolToken(v1 < v2), nil
+	case "<=":
+		return boolToken(v1 <= v2), nil
+	case ">":
+		return boolToken(v1 > v2), nil
+	case ">=":
+		return boolToken(v1 >= v2), nil
 	}
 
-	f2, ok := t2.(floatToken)
-	if !ok {
-		return nil, fmt.Errorf("expected numeral as the right operand for operator '>', but got: %v", t2)
+	return nil, insights.InternalErr("unexpected operator for ordering comparison", map[string]any{
+		"op": op,
+	})
+}
+
+// subscriptOp handles the member access operators `.` and `[]`
+// when they can't be folded into a varToken at parsing time,
+// e.g. on `tags[len(tags)-1]` or `f(x).y`.
+//
+// Negative list indexes count from the end of the list, and
+// missing keys or out of range indexes return a missingToken.
+func subscriptOp(t1 Token, t2 Token, op opToken, data *EvaluationData) (Token, error) {
+	switch container := t1.(type) {
+	case mapToken:
+		value, found := container[string(t2.(strToken))]
+		if !found {
+			return missingToken{}, nil
+		}
+		return unwrapLazy(value)
+
+	case listToken:
+		// Lists can only be indexed by ints, so keys such
+		// as `"0"` are handled just like missing fields:
+		intIdx, isInt := t2.(intToken)
+		if !isInt {
+			return missingToken{}, nil
+		}
+
+		idx := int(intIdx)
+		if idx < 0 {
+			idx += len(container)
+		}
+		if idx < 0 || idx >= len(container) {
+			return missingToken{}, nil
+		}
+		return unwrapLazy(container[idx])
 	}
 
-	return boolToken(f1 > f2), nil
+	return nil, insights.InternalErr("unexpected container type for subscript operator", map[string]any{
+		"container": t1,
+	})
 }
 
-func lesserThanOp(t1 Token, t2 Token, op opToken, data *EvaluationData) (Token, error) {
-	f1, ok := t1.(floatToken)
-	if !ok {
-		return nil, fmt.Errorf("expected numeral as the left operand for operator '<', but got: %v", t1)
+// inListOp checks if any of the items of the list is equal to
+// the left operand using the same semantics as the `==` operator
+func inListOp(t1 Token, t2 Token, op opToken, data *EvaluationData) (Token, error) {
+	for _, item := range t2.(listToken) {
+		item, err := unwrapLazy(item)
+		if err != nil {
+			return nil, err
+		}
+
+		if tokensEqual(t1, item, data) {
+			return boolToken(true), nil
+		}
+	}
+
+	return boolToken(false), nil
+}
+
+func inMapOp(t1 Token, t2 Token, op opToken, data *EvaluationData) (Token, error) {
+	_, found := t2.(mapToken)[string(t1.(strToken))]
+	return boolToken(found), nil
+}
+
+func inStrOp(t1 Token, t2 Token, op opToken, data *EvaluationData) (Token, error) {
+	return boolToken(strings.Contains(string(t2.(strToken)), string(t1.(strToken)))), nil
+}
+
+// tokensEqual compares two tokens using the `==` operator,
+// tokens of incompatible types are never equal.
+func tokensEqual(t1 Token, t2 Token, data *EvaluationData) bool {
+	opFunc := equalityOps[newOpTypePair(t1, t2)]
+	if opFunc == nil {
+		return isNull(t1) && isNull(t2)
 	}
 
-	f2, ok := t2.(floatToken)
+	result, err := opFunc(t1, t2, "==", data)
+	return err == nil && result == boolToken(true)
+}
+
+// compareBigIntOp compares numbers exactly when at least
+// one of them is a bigIntToken
+func compareBigIntOp(t1 Token, t2 Token, op opToken, data *EvaluationData) (Token, error) {
+	c, ok := internal.CompareNumbers(numberValue(t1), numberValue(t2))
 	if !ok {
-		return nil, fmt.Errorf("expected numeral as the right operand for operator '<', but got: %v", t2)
+		// NaN is never equal, lesser or greater than any number:
+		return boolToken(op == "!="), nil
 	}
 
-	return boolToken(f1 < f2), nil
+	switch op {
+	case "==":
+		return boolToken(c == 0), nil
+	case "!=":
+		return boolToken(c != 0), nil
+	case "<":
+		return boolToken(c < 0), nil
+	case "<=":
+		return boolToken(c <= 0), nil
+	case ">":
+		return boolToken(c > 0), nil
+	case ">=":
+		return boolToken(c >= 0), nil
+	}
+
+	return nil, insights.InternalErr("unexpected operator for big int comparison", map[string]any{
+		"op": op,
+	})
+}
+
+// numberValue converts number tokens into the numbers
+// expected by the helpers of the internal package
+func numberValue(t Token) any {
+	switch v := t.(type) {
+	case bigIntToken:
+		return v.bigInt()
+	case intToken:
+		return int64(v)
+	case floatToken:
+		return float64(v)
+	}
+
+	return nil
+}
+
+// bigIntArithmeticOp converts both operands to float,
+// so the result might not be exact.
+func bigIntArithmeticOp(t1 Token, t2 Token, op opToken, data *EvaluationData) (Token, error) {
+	return calculateFloats(op, toFloatToken(t1), toFloatToken(t2))
+}
+
+func toFloatToken(t Token) floatToken {
+	f, _ := internal.ToFloat64(numberValue(t))
+	return floatToken(f)
+}
+
+func negateBigIntOp(t1 Token, t2 Token, op opToken, data *EvaluationData) (Token, error) {
+	str := string(t2.(bigIntToken))
+	if str[0] == '-' {
+		str = str[1:]
+	} else {
+		str = "-" + str
+	}
+
+	// The negation of 9223372036854775808 fits on an intToken:
+	return parseIntLiteral(str, 10)
+}
+
+func unaryPlusOp(t1 Token, t2 Token, op opToken, data *EvaluationData) (Token, error) {
+	return t2, nil
+}
+
+func negateIntOp(t1 Token, t2 Token, op opToken, data *EvaluationData) (Token, error) {
+	v := t2.(intToken)
+	if v == math.MinInt64 {
+		return nil, insights.RuntimeErr("integer overflow", map[string]any{
+			"op":      "L-",
+			"operand": v,
+		})
+	}
+
+	return -v, nil
+}
+
+func negateFloatOp(t1 Token, t2 Token, op opToken, data *EvaluationData) (Token, error) {
+	return -t2.(floatToken), nil
+}
+
+func notOp(t1 Token, t2 Token, op opToken, data *EvaluationData) (Token, error) {
+	return !t2.(boolToken), nil
+}
+
+func intArithmeticOp(t1 Token, t2 Token, op opToken, data *EvaluationData) (Token, error) {
+	v1, v2 := t1.(intToken), t2.(intToken)
+
+	switch op {
+	case "+":
+		if (v2 > 0 && v1 > math.MaxInt64-v2) || (v2 < 0 && v1 < math.MinInt64-v2) {
+			return nil, intOverflowErr(op, v1, v2)
+		}
+		return v1 + v2, nil
+	case "-":
+		if (v2 < 0 && v1 > math.MaxInt64+v2) || (v2 > 0 && v1 < math.MinInt64+v2) {
+			return nil, intOverflowErr(op, v1, v2)
+		}
+		return v1 - v2, nil
+	case "*":
+		result, ok := multiplyInts(v1, v2)
+		if !ok {
+			return nil, intOverflowErr(op, v1, v2)
+		}
+		return result, nil
+	case "/", "%":
+		if v2 == 0 {
+			return nil, insights.RuntimeErr("division by zero", map[string]any{
+				"op":    op,
+				"left":  v1,
+				"right": v2,
+			})
+		}
+		if op == "/" {
+			return floatToken(float64(v1) / float64(v2)), nil
+		}
+		return v1 % v2, nil
+	case "**":
+		if v2 < 0 {
+			return floatToken(math.Pow(float64(v1), float64(v2))), nil
+		}
+
+		// Exponentiation by squaring:
+		result, base := intToken(1), v1
+		for exp := v2; exp > 0; exp >>= 1 {
+			ok := true
+			if exp&1 == 1 {
+				result, ok = multiplyInts(result, base)
+			}
+			if ok && exp > 1 {
+				base, ok = multiplyInts(base, base)
+			}
+			if !ok {
+				return nil, intOverflowErr(op, v1, v2)
+			}
+		}
+		return result, nil
+	}
+
+	return nil, insights.InternalErr("unexpected arithmetic operator", map[string]any{
+		"op": op,
+	})
+}
+
+func intOverflowErr(op opToken, v1 intToken, v2 intToken) error {
+	return insights.RuntimeErr("integer overflow", map[string]any{
+		"op":    op,
+		"left":  v1,
+		"right": v2,
+	})
+}
+
+// multiplyInts returns false if the multiplication overflows
+func multiplyInts(v1 intToken, v2 intToken) (intToken, bool) {
+	if v1 == 0 || v2 == 0 {
+		return 0, true
+	}
+
+	result := v1 * v2
+	if result/v2 != v1 || (v1 == -1 && v2 == math.MinInt64) || (v2 == -1 && v1 == math.MinInt64) {
+		return 0, false
+	}
+
+	return result, true
+}
+
+func bitwiseOp(t1 Token, t2 Token, op opToken, data *EvaluationData) (Token, error) {
+	v1, v2 := t1.(intToken), t2.(intToken)
+
+	switch op {
+	case "&":
+		return v1 & v2, nil
+	case "|":
+		return v1 | v2, nil
+	case "^":
+		return v1 ^ v2, nil
+	case "<<", ">>":
+		if v2 < 0 {
+			return nil, insights.RuntimeErr("negative shift count", map[string]any{
+				"op":    op,
+				"left":  v1,
+				"right": v2,
+			})
+		}
+
+		if op == ">>" {
+			return v1 >> v2, nil
+		}
+
+		result := v1 << v2
+		if result>>v2 != v1 {
+			return nil, insights.RuntimeErr("integer overflow", map[string]any{
+				"op":    op,
+				"left":  v1,
+				"right": v2,
+			})
+		}
+		return result, nil
+	}
+
+	return nil, insights.InternalErr("unexpected bitwise operator", map[string]any{
+		"op": op,
+	})
+}
+
+func floatArithmeticOp(t1 Token, t2 Token, op opToken, data *EvaluationData) (Token, error) {
+	return calculateFloats(op, t1.(floatToken), t2.(floatToken))
+}
+
+func intFloatArithmeticOp(t1 Token, t2 Token, op opToken, data *EvaluationData) (Token, error) {
+	return calculateFloats(op, floatToken(t1.(intToken)), t2.(floatToken))
+}
+
+func floatIntArithmeticOp(t1 Token, t2 Token, op opToken, data *EvaluationData) (Token, error) {
+	return calculateFloats(op, t1.(floatToken), floatToken(t2.(intToken)))
+}
+
+func calculateFloats(op opToken, v1 floatToken, v2 floatToken) (Token, error) {
+	switch op {
+	case "+":
+		return v1 + v2, nil
+	case "-":
+		return v1 - v2, nil
+	case "*":
+		return v1 * v2, nil
+	case "/", "%":
+		if v2 == 0 {
+			return nil, insights.RuntimeErr("division by zero", map[string]any{
+				"op":    op,
+				"left":  v1,
+				"right": v2,
+			})
+		}
+		if op == "%" {
+			return floatToken(math.Mod(float64(v1), float64(v2))), nil
+		}
+		return v1 / v2, nil
+	case "**":
+		return floatToken(math.Pow(float64(v1), float64(v2))), nil
+	}
+
+	return nil, insights.InternalErr("unexpected arithmetic operator", map[string]any{
+		"op": op,
+	})
+}
+
+func concatStrOp(t1 Token, t2 Token, op opToken, data *EvaluationData) (Token, error) {
+	return t1.(strToken) + t2.(strToken), nil
+}
+
+// andOp only runs when the left operand is true or missing,
+// otherwise the evaluation short-circuits before reaching this operator.
+func andOp(t1 Token, t2 Token, op opToken, data *EvaluationData) (Token, error) {
+	if t2 == boolToken(false) {
+		return t2, nil
+	}
+
+	if isMissing(t1) || isMissing(t2) {
+		return missingToken{}, nil
+	}
+
+	return boolToken(true), nil
+}
+
+// orOp only runs when the left operand is false or missing,
+// otherwise the evaluation short-circuits before reaching this operator.
+func orOp(t1 Token, t2 Token, op opToken, data *EvaluationData) (Token, error) {
+	if t2 == boolToken(true) {
+		return t2, nil
+	}
+
+	if isMissing(t1) || isMissing(t2) {
+		return missingToken{}, nil
+	}
+
+	return boolToken(false), nil
+}
+
+func equalsOp(t1 Token, t2 Token, op opToken, data *EvaluationData) (Token, error) {
+	return boolToken(t1 == t2), nil
 }
 
 func equalsIntOp(t1 Token, t2 Token, op opToken, data *EvaluationData) (Token, error) {
@@ -1,5 +1,64 @@
 package eparser
 
+import (
+	"strings"
+
+	"github.com/vingarcia/insights"
+)
+
 type ReservedWordParser func(expr []rune, parsingCtx *ParsingCtx, rpnBuilder *RPNBuilder, index int) (newIndex int, err error)
 
-var reservedWordParsers = map[string]ReservedWordParser{}
+// reservedWordParsers are indexed by the lowercase keywords, since
+// keywords are case insensitive, e.g. `NOT a IN [1, 2]`
+var reservedWordParsers = map[string]ReservedWordParser{
+	"true":  literalParser(boolToken(true)),
+	"false": literalParser(boolToken(false)),
+	"null":  literalParser(nullToken{}),
+
+	// Word aliases for the symbolic operators:
+	"and": operatorParser("and", "&&"),
+	"or":  operatorParser("or", "||"),
+	"not": operatorParser("not", "not"),
+
+	"in": operatorParser("in", "in"),
+}
+
+// IsReservedWord checks if word is a keyword of the expressions,
+// e.g. `and` or `NULL`, instead of a field name
+func IsReservedWord(word string) bool {
+	return lookupReservedWord(word) != nil
+}
+
+func lookupReservedWord(word string) ReservedWordParser {
+	return reservedWordParsers[strings.ToLower(word)]
+}
+
+func literalParser(token Token) ReservedWordParser {
+	return func(expr []rune, parsingCtx *ParsingCtx, rpnBuilder *RPNBuilder, index int) (newIndex int, err error) {
+		err = rpnBuilder.handleToken(token)
+		if err != nil {
+			return 0, insights.SyntaxErr("unexpected literal", map[string]any{
+				"literal": token,
+				"pos":     parsingCtx.FormatLineCol(index),
+				"error":   err,
+			})
+		}
+
+		return index, nil
+	}
+}
+
+func operatorParser(word string, op string) ReservedWordParser {
+	return func(expr []rune, parsingCtx *ParsingCtx, rpnBuilder *RPNBuilder, index int) (newIndex int, err error) {
+		err = rpnBuilder.handleOp(op)
+		if err != nil {
+			return 0, insights.SyntaxErr("unexpected operator", map[string]any{
+				"op":    word,
+				"pos":   parsingCtx.FormatLineCol(index),
+				"error": err,
+			})
+		}
+
+		return index, nil
+	}
+}
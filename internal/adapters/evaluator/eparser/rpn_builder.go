@@ -74,6 +74,14 @@ func (r *RPNBuilder) handleOp(op string) error {
 
 func (r *RPNBuilder) handleBinaryOp(op string) {
 	r.handleOpStack(op)
+
+	// At this point the left operand is complete on the rpn,
+	// so we add a marker allowing the evaluation to skip the
+	// right operand when the left one already decides the result:
+	if shortCircuitOps[op] {
+		r.rpn = append(r.rpn, shortCircuitToken{op: opToken(op)})
+	}
+
 	r.opStack = append(r.opStack, op)
 }
 
@@ -107,7 +115,7 @@ func (r *RPNBuilder) handleOpStack(op string) {
 	var currentOp string
 
 	l := len(r.opStack)
-	for ; l > 0 && opPrecedence[op] >= opPrecedence[r.opStack[l-1]]; l-- {
+	for ; l > 0 && shouldPopOp(op, r.opStack[l-1]); l-- {
 		currentOp = normalizeOp(r.opStack[l-1])
 		r.rpn = append(r.rpn, opToken(currentOp))
 	}
@@ -116,6 +124,16 @@ func (r *RPNBuilder) handleOpStack(op string) {
 	r.opStack = r.opStack[:l]
 }
 
+// shouldPopOp decides if the operator on the top of the opStack
+// should be evaluated before the new incoming operator.
+func shouldPopOp(newOp string, stackOp string) bool {
+	if rightAssociativeOps[newOp] {
+		return opPrecedence[newOp] > opPrecedence[stackOp]
+	}
+
+	return opPrecedence[newOp] >= opPrecedence[stackOp]
+}
+
 func (r *RPNBuilder) FinishAndReturnRPN(expr []rune, index int, parsingCtx ParsingCtx) (rpn []Token, _ error) {
 	l := len(r.opStack)
 
@@ -127,6 +145,14 @@ func (r *RPNBuilder) FinishAndReturnRPN(expr []rune, index int, parsingCtx Parsi
 		})
 	}
 
+	// Check for expressions ending on a binary operator i.e. 10 +
+	if r.lastTokenWasOp != "no" && r.bracketLevel == 0 {
+		return nil, insights.SyntaxErr("expected operand after operator", map[string]any{
+			"operator": r.lastTokenWasOp,
+			"pos":      parsingCtx.FormatLineCol(index),
+		})
+	}
+
 	var currentOp string
 
 	for ; l > 0; l-- {
@@ -137,6 +163,17 @@ func (r *RPNBuilder) FinishAndReturnRPN(expr []rune, index int, parsingCtx Parsi
 	// Drop all the tokens from the stack:
 	r.opStack = r.opStack[:0]
 
+	if r.bracketLevel > 0 {
+		return nil, insights.SyntaxErr("missing closing bracket on the expression", map[string]any{
+			"pos": parsingCtx.FormatLineCol(index),
+		})
+	}
+
+	err := linkShortCircuitTokens(r.rpn)
+	if err != nil {
+		return nil, err
+	}
+
 	// In case one of the custom parsers left an empty expression:
 	if len(r.rpn) == 0 {
 		return nil, insights.ParserErr("invalid state: the final rpn ended up empty", map[string]any{
@@ -161,6 +198,14 @@ func (r *RPNBuilder) handleToken(token Token) error {
 	return nil
 }
 
+// extendLastVar appends a new key to the path of the varToken
+// on the top of the rpn, e.g. converting `a` into `a.b`
+func (r *RPNBuilder) extendLastVar(key string) {
+	l := len(r.rpn)
+	v := r.rpn[l-1].(varToken)
+	r.rpn[l-1] = append(v.Clone().(varToken), key)
+}
+
 func (r *RPNBuilder) openBracket(bracket string) {
 	r.opStack = append(r.opStack, bracket)
 	r.lastTokenWasOp = bracket
@@ -170,7 +215,28 @@ func (r *RPNBuilder) openBracket(bracket string) {
 
 func (r *RPNBuilder) closeBracket(bracket string) error {
 	if r.lastTokenWasOp == bracket {
-		return insights.SyntaxErr("bracket unexpectedly closed with no elements", map[string]any{
+		l := len(r.opStack)
+		if l < 2 || r.opStack[l-2] != "()" {
+			return insights.SyntaxErr("bracket unexpectedly closed with no elements", map[string]any{
+				"bracketType": bracket,
+			})
+		}
+
+		// Function calls such as `now()` and the empty list and map
+		// constructors receive an empty list of arguments:
+		r.handleToken(tupleToken{})
+	}
+
+	if r.lastTokenWasUnary {
+		return insights.SyntaxErr("expected operand after unary operator", map[string]any{
+			"operator":    r.lastTokenWasOp,
+			"bracketType": bracket,
+		})
+	}
+
+	if r.lastTokenWasOp != "no" {
+		return insights.SyntaxErr("expected operand after operator", map[string]any{
+			"operator":    r.lastTokenWasOp,
 			"bracketType": bracket,
 		})
 	}
@@ -203,6 +269,47 @@ func (r *RPNBuilder) closeBracket(bracket string) error {
 
 // * * * * * Static parsing helpers: * * * * * //
 
+// linkShortCircuitTokens sets the jump target of each shortCircuitToken
+// to the position of its matching operator on the rpn.
+//
+// Since the right operand of a binary operator is always a contiguous
+// sub expression on the rpn the markers and their operators are nested
+// just like brackets, so we can match them using a stack.
+func linkShortCircuitTokens(rpn []Token) error {
+	markers := []int{}
+	for i, token := range rpn {
+		switch t := token.(type) {
+		case shortCircuitToken:
+			markers = append(markers, i)
+		case opToken:
+			if !shortCircuitOps[string(t)] {
+				continue
+			}
+
+			l := len(markers)
+			if l == 0 || rpn[markers[l-1]].(shortCircuitToken).op != t {
+				return insights.InternalErr("short-circuit operator without a matching marker", map[string]any{
+					"op": t,
+				})
+			}
+
+			rpn[markers[l-1]] = shortCircuitToken{
+				op:     t,
+				target: i,
+			}
+			markers = markers[:l-1]
+		}
+	}
+
+	if len(markers) > 0 {
+		return insights.InternalErr("short-circuit marker without a matching operator", map[string]any{
+			"rpn": rpn,
+		})
+	}
+
+	return nil
+}
+
 func normalizeOp(op string) string {
 	// The prefix L and R is used for denoting left and right unary operators
 	if op[0] == 'L' || op[0] == 'R' {
@@ -0,0 +1,187 @@
+package eparser
+
+import (
+	"math"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/vingarcia/insights"
+	"github.com/vingarcia/insights/internal/adapters/evaluator"
+)
+
+// timestampLayouts are the formats accepted for string timestamps,
+// the ones without a time zone are interpreted as UTC
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02",
+}
+
+// binFunc groups timestamps on fixed width buckets aligned
+// to the Unix epoch, e.g. `bin(ts, 5m)` for 5 minute buckets
+func binFunc(args []Token, scope mapToken) (Token, error) {
+	err := expectNumArgs("bin", args, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	width, ok := args[1].(durationToken)
+	if !ok || width <= 0 {
+		return nil, unexpectedArgErr("bin", 1, "a positive duration, e.g. 5m", args[1])
+	}
+
+	if isNull(args[0]) {
+		return nullToken{}, nil
+	}
+
+	t, err := parseTimestamp("bin", 0, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return timeBucketToken(evaluator.TimeBucket{
+		Start: t.Add(-binOffset(t, int64(width))),
+		Width: time.Duration(width),
+	}), nil
+}
+
+// binOffset returns the time since the start of the bin of
+// width w containing t, bins are aligned to the Unix epoch
+func binOffset(t time.Time, w int64) time.Duration {
+	// UnixNano only works for dates between 1678 and 2262:
+	if year := t.Year(); year > 1678 && year < 2262 {
+		offset := t.UnixNano() % w
+		if offset < 0 {
+			offset += w
+		}
+		return time.Duration(offset)
+	}
+
+	ns := new(big.Int).Mul(big.NewInt(t.Unix()), big.NewInt(1e9))
+	ns.Add(ns, big.NewInt(int64(t.Nanosecond())))
+	return time.Duration(ns.Mod(ns, big.NewInt(w)).Int64())
+}
+
+// dateTruncFunc groups timestamps on calendar units, e.g.
+// `date_trunc("month", ts)`, weeks start on Mondays
+func dateTruncFunc(args []Token, scope mapToken) (Token, error) {
+	err := expectNumArgs("date_trunc", args, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	unit, ok := args[0].(strToken)
+	if !ok {
+		return nil, unexpectedArgErr("date_trunc", 0, "a string", args[0])
+	}
+
+	if isNull(args[1]) {
+		return nullToken{}, nil
+	}
+
+	t, err := parseTimestamp("date_trunc", 1, args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	bucket := evaluator.TimeBucket{}
+	switch strings.ToLower(string(unit)) {
+	case "second":
+		bucket.Width = time.Second
+	case "minute":
+		bucket.Width = time.Minute
+	case "hour":
+		bucket.Width = time.Hour
+	case "day":
+		bucket.Width = 24 * time.Hour
+	case "week":
+		bucket.Unit = "week"
+		daysSinceMonday := (int(t.Weekday()) + 6) % 7
+		bucket.Start = time.Date(t.Year(), t.Month(), t.Day()-daysSinceMonday, 0, 0, 0, 0, time.UTC)
+	case "month":
+		bucket.Unit = "month"
+		bucket.Start = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	case "quarter":
+		bucket.Unit = "quarter"
+		firstMonth := (t.Month()-1)/3*3 + 1
+		bucket.Start = time.Date(t.Year(), firstMonth, 1, 0, 0, 0, 0, time.UTC)
+	case "year":
+		bucket.Unit = "year"
+		bucket.Start = time.Date(t.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+	default:
+		return nil, insights.RuntimeErr("invalid unit for date_trunc", map[string]any{
+			"unit":  unit,
+			"valid": "second, minute, hour, day, week, month, quarter or year",
+		})
+	}
+
+	if bucket.Width > 0 {
+		bucket.Start = t.Truncate(bucket.Width)
+	}
+
+	return timeBucketToken(bucket), nil
+}
+
+// parseTimestamp accepts strings on the timestampLayouts formats
+// and numbers representing the time since the Unix epoch, whose
+// unit is guessed by their magnitude: numbers below 1e11 are
+// seconds, below 1e14 milliseconds, below 1e17 microseconds and
+// nanoseconds otherwise, which works for dates from 1973 to 5138.
+func parseTimestamp(fnName string, argIdx int, token Token) (time.Time, error) {
+	switch v := token.(type) {
+	case strToken:
+		for _, layout := range timestampLayouts {
+			t, err := time.Parse(layout, string(v))
+			if err == nil {
+				return t.UTC(), nil
+			}
+		}
+		return time.Time{}, insights.RuntimeErr("unable to parse timestamp", map[string]any{
+			"function":  fnName,
+			"timestamp": string(v),
+			"formats":   "RFC3339, `2006-01-02 15:04:05` or `2006-01-02`",
+		})
+	case intToken:
+		abs := math.Abs(float64(v))
+		switch {
+		case abs < 1e11:
+			return time.Unix(int64(v), 0).UTC(), nil
+		case abs < 1e14:
+			return time.UnixMilli(int64(v)).UTC(), nil
+		case abs < 1e17:
+			return time.UnixMicro(int64(v)).UTC(), nil
+		default:
+			return time.Unix(0, int64(v)).UTC(), nil
+		}
+	case bigIntToken:
+		// Integers that don't fit on an int64 are always nanoseconds,
+		// e.g. uint64 timestamps after 2262:
+		n, _ := new(big.Int).SetString(string(v), 10)
+		secs, nanos := new(big.Int).DivMod(n, big.NewInt(1e9), new(big.Int))
+		if !secs.IsInt64() {
+			return time.Time{}, insights.RuntimeErr("timestamp out of range", map[string]any{
+				"function":  fnName,
+				"timestamp": string(v),
+			})
+		}
+		return time.Unix(secs.Int64(), nanos.Int64()).UTC(), nil
+	case floatToken:
+		secs := float64(v)
+		switch abs := math.Abs(secs); {
+		case abs >= 1e17:
+			secs /= 1e9
+		case abs >= 1e14:
+			secs /= 1e6
+		case abs >= 1e11:
+			secs /= 1e3
+		}
+		return time.Unix(0, int64(math.Round(secs*1e9))).UTC(), nil
+	case timeBucketToken:
+		return v.Start, nil
+	}
+
+	return time.Time{}, unexpectedArgErr(fnName, argIdx, "a timestamp string or number", token)
+}
@@ -3,12 +3,15 @@ package eparser
 import (
 	"bytes"
 	"encoding/json"
-	"fmt"
+	"math/big"
 	"strconv"
 	"strings"
+	"time"
 	"unicode"
 
 	"github.com/vingarcia/insights"
+	"github.com/vingarcia/insights/internal"
+	"github.com/vingarcia/insights/internal/adapters/evaluator"
 )
 
 type Token interface {
@@ -41,6 +44,33 @@ func (unaryPlaceholderToken) String() string {
 	return "UnaryToken"
 }
 
+// shortCircuitToken marks the point on the rpn where the
+// left operand of a short-circuiting operator such as `&&`
+// has already been evaluated.
+//
+// If the left operand alone decides the result the evaluation
+// jumps directly to the operator at position `target`, skipping
+// the right operand completely.
+type shortCircuitToken struct {
+	op     opToken
+	target int
+}
+
+func (s shortCircuitToken) Clone() Token {
+	return s
+}
+
+func (s shortCircuitToken) String() string {
+	return "?" + string(s.op)
+}
+
+// shortCircuitOps lists the operators that may skip
+// the evaluation of their right operand:
+var shortCircuitOps = map[string]bool{
+	"&&": true,
+	"||": true,
+}
+
 // Function represents a custom function for our parser
 type Function func(args []Token, scope mapToken) (Token, error)
 
@@ -87,6 +117,67 @@ func (f floatToken) String() string {
 	return strconv.FormatFloat(float64(f), 'f', -1, 64)
 }
 
+// bigIntToken represents integers that don't fit on an intToken,
+// e.g. uint64 IDs, it holds the canonical decimal representation
+// of the number so equal numbers are always equal tokens.
+//
+// Comparisons between bigIntTokens and other numbers are always
+// exact, but arithmetic operations convert them to float.
+type bigIntToken string
+
+func (b bigIntToken) Clone() Token {
+	return b
+}
+
+func (b bigIntToken) String() string {
+	return string(b)
+}
+
+func (b bigIntToken) bigInt() *big.Int {
+	n, _ := new(big.Int).SetString(string(b), 10)
+	return n
+}
+
+// parseIntLiteral parses integers keeping the exact value
+// of numbers that don't fit on an int as a bigIntToken.
+func parseIntLiteral(literal string, base int) (Token, error) {
+	i, err := strconv.ParseInt(literal, base, 64)
+	if err == nil {
+		return intToken(i), nil
+	}
+
+	n, ok := new(big.Int).SetString(literal, base)
+	if !ok {
+		return nil, err
+	}
+
+	return bigIntToken(n.String()), nil
+}
+
+// durationToken represents duration literals such as `5m`,
+// which are used as arguments of time bucketing functions
+type durationToken time.Duration
+
+func (d durationToken) Clone() Token {
+	return d
+}
+
+func (d durationToken) String() string {
+	return time.Duration(d).String()
+}
+
+// timeBucketToken represents the time windows produced
+// by the time bucketing functions, e.g. `bin(ts, 5m)`
+type timeBucketToken evaluator.TimeBucket
+
+func (t timeBucketToken) Clone() Token {
+	return t
+}
+
+func (t timeBucketToken) String() string {
+	return evaluator.TimeBucket(t).String()
+}
+
 // boolToken represent boolean values
 type boolToken bool
 
@@ -101,6 +192,52 @@ func (b boolToken) String() string {
 	return "false"
 }
 
+// nullToken represents the null value
+type nullToken struct{}
+
+func (n nullToken) Clone() Token {
+	return n
+}
+
+func (nullToken) String() string {
+	return "null"
+}
+
+// missingToken represents the value of a field that doesn't
+// exist on the input record, it is different from null which
+// is a value explicitly set on the record.
+//
+// Missing values follow a three-valued logic similar to SQL's NULL:
+//
+//   - Comparisons, arithmetic and most functions return missing
+//     when any of their operands is missing, e.g. `a == 1` and
+//     `!(a == 1)` are both missing if `a` doesn't exist.
+//   - `false && missing` is false and `true || missing` is true,
+//     otherwise `&&` and `||` return missing.
+//   - A boolean expression that evaluates to missing doesn't match.
+//
+// The only ways of testing for missing values are the
+// functions `exists(path)` and `is_null(path)`.
+type missingToken struct{}
+
+func (m missingToken) Clone() Token {
+	return m
+}
+
+func (missingToken) String() string {
+	return "missing"
+}
+
+func isMissing(token Token) bool {
+	_, ok := token.(missingToken)
+	return ok
+}
+
+func isNull(token Token) bool {
+	_, ok := token.(nullToken)
+	return ok
+}
+
 // refToken is used to keep references
 type refToken struct {
 	// The value found at compilation time
@@ -121,21 +258,25 @@ func (r refToken) String() string {
 	return "&" + strings.Join(r.key, ".")
 }
 
-func (r refToken) Resolve(localScope map[string]Token) Token {
+func (r refToken) Resolve(localScope map[string]Token) (Token, error) {
 	// Local variables have no map of origin,
 	// thus, require a localScope to be resolved:
 	if r.origin == nil && localScope != nil {
 		// Get the most recent value from the local scope:
-		refValue := r.key.Resolve(localScope)
-		if refValue != nil {
+		refValue, err := r.key.Resolve(localScope)
+		if err != nil {
+			return nil, err
+		}
+
+		if !isMissing(refValue) {
 			// TODO(vingarcia): Consider cloning this value first
-			return refValue
+			return refValue, nil
 		}
 	}
 
 	// In last case return the compilation-time value:
 	// TODO(vingarcia): Consider cloning this value first
-	return r.originalValue
+	return r.originalValue, nil
 }
 
 // varToken represent variable references
@@ -165,8 +306,11 @@ func (v varToken) String() string {
 			}
 		}
 
+		_, isIndex := strconv.Atoi(str)
 		if onlyVarChars {
 			out += "." + str
+		} else if isIndex == nil {
+			out += "[" + str + "]"
 		} else {
 			b, _ := json.Marshal(str)
 			out += "[" + string(b) + "]"
@@ -175,29 +319,63 @@ func (v varToken) String() string {
 	return out
 }
 
-func (v varToken) Resolve(vars map[string]Token) Token {
-	value := vars[v[0]]
-	if lazy, ok := value.(lazyJsonToken); ok {
-		value = lazy.Value()
+// Resolve walks the path of the variable through maps and lists,
+// decoding the lazy JSON values only as required.
+//
+// Path segments on lists are interpreted as integer indexes, if the
+// index is out of range it is handled just like a missing field.
+func (v varToken) Resolve(vars map[string]Token) (Token, error) {
+	value, err := unwrapLazy(vars[v[0]])
+	if err != nil {
+		return nil, err
 	}
 
 	for _, str := range v[1:] {
-		m, ok := value.(mapToken)
-		if !ok {
-			return strToken(v.String())
+		var found bool
+		value, found, err = lookupPathSegment(value, str)
+		if err != nil {
+			return nil, err
 		}
 
-		value = m[str]
-		if lazy, ok := value.(lazyJsonToken); ok {
-			value = lazy.Value()
+		if !found {
+			return missingToken{}, nil
 		}
 	}
 
 	if value == nil {
-		return strToken(v.String())
+		return missingToken{}, nil
+	}
+
+	return value, nil
+}
+
+func lookupPathSegment(container Token, key string) (_ Token, found bool, _ error) {
+	switch c := container.(type) {
+	case mapToken:
+		value, found := c[key]
+		if !found {
+			return nil, false, nil
+		}
+		value, err := unwrapLazy(value)
+		return value, true, err
+	case listToken:
+		idx, err := strconv.Atoi(key)
+		if err != nil || idx < 0 || idx >= len(c) {
+			return nil, false, nil
+		}
+		value, err := unwrapLazy(c[idx])
+		return value, true, err
 	}
 
-	return value
+	return nil, false, nil
+}
+
+func unwrapLazy(token Token) (Token, error) {
+	if lazy, ok := token.(*lazyJsonToken); ok {
+		return lazy.Value()
+	}
+
+	return token, nil
 }
 
 // lazyJsonToken will unmarshal from
@@ -209,73 +387,126 @@ type lazyJsonToken struct {
 	json  json.RawMessage
 }
 
+// rootVarName is the name of the variable that holds the whole
+// input record, this is the only way of accessing records that
+// are not JSON objects, e.g. `$root[0] == 1` for `[1, 2, 3]`
+const rootVarName = "$root"
+
 // NewLazyJsonMap will parse the map in an lazy way so we don't
 // unmarshal anything we don't need to at first
 // It also validates the input JSON so we don't need to handle
 // issues with invalid JSON later on
 func NewLazyJsonMap(b []byte) (mapToken, error) {
-	var m map[string]json.RawMessage
-	err := json.Unmarshal(b, &m)
-	if err != nil {
+	token := mapToken{}
+
+	trimmed := bytes.TrimSpace(b)
+	if len(trimmed) > 0 && trimmed[0] == '{' {
+		var m map[string]json.RawMessage
+		err := json.Unmarshal(trimmed, &m)
+		if err != nil {
+			return mapToken{}, insights.ParserErr("bad input json received", map[string]any{
+				"invalidJson": string(b),
+				"error":       err.Error(),
+			})
+		}
+
+		for k, v := range m {
+			token[k] = &lazyJsonToken{
+				json: v,
+			}
+		}
+	} else if !json.Valid(trimmed) {
 		return mapToken{}, insights.ParserErr("bad input json received", map[string]any{
 			"invalidJson": string(b),
-			"error":       err.Error(),
 		})
 	}
 
-	token := mapToken{}
-	for k, v := range m {
-		token[k] = lazyJsonToken{
-			json: v,
+	if _, exists := token[rootVarName]; !exists {
+		token[rootVarName] = &lazyJsonToken{
+			json: trimmed,
 		}
 	}
 
 	return token, nil
 }
 
-func (l lazyJsonToken) Clone() Token {
+func (l *lazyJsonToken) Clone() Token {
 	return l
 }
 
-func (l lazyJsonToken) String() string {
+func (l *lazyJsonToken) String() string {
 	if l.value != nil {
 		return l.value.String()
 	}
 	return string(l.json)
 }
 
-func (l lazyJsonToken) Value() Token {
-	if l.value == nil {
-		var err error
-		l.value, err = unmarshalLazyValue(l.json)
-		if err != nil {
-			panic(fmt.Sprintf(
-				`invalid JSON received for lazyJsonToken, this should have been validated before calling Evaluate!: %s`,
-				err,
-			))
-		}
+// Value decodes the JSON value, the returned error should only happen
+// if the JSON was not validated before, e.g. by NewLazyJsonMap, or if
+// the number it contains doesn't fit on a float64.
+//
+// The decoded value is cached, so records shared by several
+// expressions are only decoded once.
+func (l *lazyJsonToken) Value() (Token, error) {
+	if l.value != nil {
+		return l.value, nil
 	}
 
-	return l.value
+	value, err := unmarshalLazyValue(l.json)
+	if err != nil {
+		return nil, insights.RuntimeErr("unable to decode JSON value", map[string]any{
+			"json":  string(l.json),
+			"error": err,
+		})
+	}
+	l.value = value
+
+	return value, nil
 }
 
 func unmarshalLazyValue(rawJSON []byte) (Token, error) {
 	rawJSON = bytes.TrimSpace(rawJSON)
+	if len(rawJSON) == 0 {
+		return nil, insights.InternalErr("empty JSON value received on unmarshalLazyValue", nil)
+	}
+
 	switch rawJSON[0] {
 	case
+		byte('-'),
 		byte('0'), byte('1'), byte('2'), byte('3'), byte('4'),
 		byte('5'), byte('6'), byte('7'), byte('8'), byte('9'):
 
-		var f float64
-		return floatToken(f), json.Unmarshal(rawJSON, &f)
+		n, err := internal.ParseNumber(string(rawJSON))
+		if err != nil {
+			return nil, err
+		}
+
+		switch n := n.(type) {
+		case int64:
+			return intToken(n), nil
+		case *big.Int:
+			return bigIntToken(n.String()), nil
+		default:
+			return floatToken(n.(float64)), nil
+		}
+
+	case byte('n'):
+		if string(rawJSON) != "null" {
+			return nil, insights.InternalErr("invalid JSON value received on unmarshalLazyValue", map[string]any{
+				"value": string(rawJSON),
+			})
+		}
+		return nullToken{}, nil
 
 	case byte('"'):
 		var s string
-		return strToken(s), json.Unmarshal(rawJSON, &s)
+		err := json.Unmarshal(rawJSON, &s)
+		return strToken(s), err
 
 	case byte('f'), byte('t'):
 		var b bool
-		return boolToken(b), json.Unmarshal(rawJSON, &b)
+		err := json.Unmarshal(rawJSON, &b)
+		return boolToken(b), err
 
 	case byte('{'):
 		var m map[string]json.RawMessage
@@ -286,7 +517,7 @@ func unmarshalLazyValue(rawJSON []byte) (Token, error) {
 
 		token := mapToken{}
 		for k, v := range m {
-			token[k] = lazyJsonToken{
+			token[k] = &lazyJsonToken{
 				json: v,
 			}
 		}
@@ -301,7 +532,7 @@ func unmarshalLazyValue(rawJSON []byte) (Token, error) {
 
 		token := listToken{}
 		for _, v := range l {
-			token = append(token, lazyJsonToken{
+			token = append(token, &lazyJsonToken{
 				json: v,
 			})
 		}
@@ -313,3 +544,63 @@ func unmarshalLazyValue(rawJSON []byte) (Token, error) {
 		})
 	}
 }
+
+// tokenToValue converts a token into its plain Go representation,
+// fully decoding any lazy JSON values it might contain.
+func tokenToValue(token Token) (any, error) {
+	switch t := token.(type) {
+	case nullToken, missingToken:
+		return nil, nil
+	case boolToken:
+		return bool(t), nil
+	case intToken:
+		return int64(t), nil
+	case bigIntToken:
+		return t.bigInt(), nil
+	case floatToken:
+		return float64(t), nil
+	case strToken:
+		return string(t), nil
+	case durationToken:
+		return t.String(), nil
+	case timeBucketToken:
+		return evaluator.TimeBucket(t), nil
+	case *lazyJsonToken:
+		value, err := t.Value()
+		if err != nil {
+			return nil, err
+		}
+		return tokenToValue(value)
+	case listToken:
+		return tokensToValues(t)
+	case tupleToken:
+		return tokensToValues(t)
+	case mapToken:
+		m := make(map[string]any, len(t))
+		for k, v := range t {
+			var err error
+			m[k], err = tokenToValue(v)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	}
+
+	return nil, insights.RuntimeErr("expression evaluated to a value that is not convertible to a plain value", map[string]any{
+		"value": token,
+	})
+}
+
+func tokensToValues(tokens []Token) ([]any, error) {
+	list := make([]any, 0, len(tokens))
+	for _, token := range tokens {
+		v, err := tokenToValue(token)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+	}
+
+	return list, nil
+}
@@ -2,7 +2,9 @@ package evaluator
 
 import (
 	"encoding/json"
+	"math/big"
 	"testing"
+	"time"
 
 	tt "github.com/vingarcia/insights/internal/testtools"
 )
@@ -10,8 +12,13 @@ import (
 func Test(t *testing.T, factory func(expr string) (Expression, error)) {
 
 	tests := []struct {
-		expr               string
-		vars               map[string]any
+		expr string
+		vars map[string]any
+
+		// rawJSON is used instead of vars when set, for
+		// testing inputs that can't be built from a map
+		rawJSON string
+
 		expectedResult     bool
 		expectErrToContain []string
 	}{
@@ -57,15 +64,1276 @@ func Test(t *testing.T, factory func(expr string) (Expression, error)) {
 			},
 			expectedResult: true,
 		},
-	}
-
-	for _, test := range tests {
-		t.Run(test.expr, func(t *testing.T) {
-			evaluator, err := factory(test.expr)
-			tt.AssertNoErr(t, err)
-
-			rawJSON, err := json.Marshal(test.vars)
-			tt.AssertNoErr(t, err)
+		{
+			expr: "a > 500",
+			vars: map[string]any{
+				"a": 501,
+			},
+			expectedResult: true,
+		},
+		{
+			expr: "a > 500",
+			vars: map[string]any{
+				"a": 500,
+			},
+			expectedResult: false,
+		},
+		{
+			expr: "a >= 500",
+			vars: map[string]any{
+				"a": 500,
+			},
+			expectedResult: true,
+		},
+		{
+			expr: "a < 1.5",
+			vars: map[string]any{
+				"a": 1,
+			},
+			expectedResult: true,
+		},
+		{
+			expr: "a <= 0.5",
+			vars: map[string]any{
+				"a": 0.75,
+			},
+			expectedResult: false,
+		},
+		{
+			expr: "a > 1e3",
+			vars: map[string]any{
+				"a": 1500,
+			},
+			expectedResult: true,
+		},
+		{
+			expr:           "2 > 1",
+			vars:           map[string]any{},
+			expectedResult: true,
+		},
+		{
+			expr:           "2 <= 1",
+			vars:           map[string]any{},
+			expectedResult: false,
+		},
+		{
+			expr:           "2 < 2.5",
+			vars:           map[string]any{},
+			expectedResult: true,
+		},
+		{
+			expr:           "2.5 >= 3",
+			vars:           map[string]any{},
+			expectedResult: false,
+		},
+		{
+			expr: "a<2",
+			vars: map[string]any{
+				"a": 1,
+			},
+			expectedResult: true,
+		},
+		{
+			expr: `name < "bob"`,
+			vars: map[string]any{
+				"name": "alice",
+			},
+			expectedResult: true,
+		},
+		{
+			expr: `name >= "bob"`,
+			vars: map[string]any{
+				"name": "bobby",
+			},
+			expectedResult: true,
+		},
+		{
+			expr: `name == "bob"`,
+			vars: map[string]any{
+				"name": "bob",
+			},
+			expectedResult: true,
+		},
+		{
+			expr: `name != "bob"`,
+			vars: map[string]any{
+				"name": "bob",
+			},
+			expectedResult: false,
+		},
+		{
+			expr: `name > 10`,
+			vars: map[string]any{
+				"name": "bob",
+			},
+			expectErrToContain: []string{"unsupported types", ">"},
+		},
+		{
+			expr: "a == 1 && b == 2",
+			vars: map[string]any{
+				"a": 1,
+				"b": 2,
+			},
+			expectedResult: true,
+		},
+		{
+			expr: "a == 1 && b == 2",
+			vars: map[string]any{
+				"a": 1,
+				"b": 3,
+			},
+			expectedResult: false,
+		},
+		{
+			expr: "a == 0 || b == 2",
+			vars: map[string]any{
+				"a": 1,
+				"b": 2,
+			},
+			expectedResult: true,
+		},
+		{
+			expr: "a == 0 || b == 0",
+			vars: map[string]any{
+				"a": 1,
+				"b": 2,
+			},
+			expectedResult: false,
+		},
+		{
+			expr: "a == 1 || a == 2 && a == 3",
+			vars: map[string]any{
+				"a": 1,
+			},
+			expectedResult: true,
+		},
+		{
+			expr: "(a == 1 || a == 2) && a == 3",
+			vars: map[string]any{
+				"a": 1,
+			},
+			expectedResult: false,
+		},
+		{
+			expr: "a > 0 && (b > 0 || c > 0) && d > 0",
+			vars: map[string]any{
+				"a": 1,
+				"b": 0,
+				"c": 1,
+				"d": 1,
+			},
+			expectedResult: true,
+		},
+		{
+			// The right operand would fail if evaluated:
+			expr: `a == 0 && a > "text"`,
+			vars: map[string]any{
+				"a": 1,
+			},
+			expectedResult: false,
+		},
+		{
+			// The right operand would fail if evaluated:
+			expr: `a == 1 || a > "text"`,
+			vars: map[string]any{
+				"a": 1,
+			},
+			expectedResult: true,
+		},
+		{
+			expr: `a == 1 && a > "text"`,
+			vars: map[string]any{
+				"a": 1,
+			},
+			expectErrToContain: []string{"unsupported types", ">"},
+		},
+		{
+			expr: "a + 2 == 5",
+			vars: map[string]any{
+				"a": 3,
+			},
+			expectedResult: true,
+		},
+		{
+			expr: "a - 2 * 3 == 4",
+			vars: map[string]any{
+				"a": 10,
+			},
+			expectedResult: true,
+		},
+		{
+			expr: "(a - 2) * 3 == 24",
+			vars: map[string]any{
+				"a": 10,
+			},
+			expectedResult: true,
+		},
+		{
+			expr: "bytes_out / duration_s > 1e6",
+			vars: map[string]any{
+				"bytes_out":  5e6,
+				"duration_s": 2,
+			},
+			expectedResult: true,
+		},
+		{
+			expr:           "7 / 2 == 3.5 && 6 / 2 == 3 && int(7 / 2) == 3 && -7 / 2 == -3.5",
+			vars:           map[string]any{},
+			expectedResult: true,
+		},
+		{
+			expr:           "7 / 2.0 == 3.5",
+			vars:           map[string]any{},
+			expectedResult: true,
+		},
+		{
+			expr:           "a % -1 == 0 && a / -1 == 9223372036854775808.0",
+			rawJSON:        `{"a": -9223372036854775808}`,
+			expectedResult: true,
+		},
+		{
+			expr:           "7 % 4 == 3",
+			vars:           map[string]any{},
+			expectedResult: true,
+		},
+		{
+			expr:           "7.5 % 2 == 1.5",
+			vars:           map[string]any{},
+			expectedResult: true,
+		},
+		{
+			expr:           "2 ** 10 == 1024",
+			vars:           map[string]any{},
+			expectedResult: true,
+		},
+		{
+			expr:           "2 ** 3 ** 2 == 512",
+			vars:           map[string]any{},
+			expectedResult: true,
+		},
+		{
+			expr:           "2 ** 0.5 > 1.41",
+			vars:           map[string]any{},
+			expectedResult: true,
+		},
+		{
+			expr: `a + "bar" == "foobar"`,
+			vars: map[string]any{
+				"a": "foo",
+			},
+			expectedResult: true,
+		},
+		{
+			expr:               "1 / 0 == 0",
+			vars:               map[string]any{},
+			expectErrToContain: []string{"RuntimeErr", "division by zero"},
+		},
+		{
+			expr: "a / 0 == 0",
+			vars: map[string]any{
+				"a": 1.5,
+			},
+			expectErrToContain: []string{"RuntimeErr", "division by zero"},
+		},
+		{
+			expr:               "1 % 0 == 0",
+			vars:               map[string]any{},
+			expectErrToContain: []string{"RuntimeErr", "division by zero"},
+		},
+		{
+			expr:               "0x7FFFFFFFFFFFFFFF + 1 > 0",
+			vars:               map[string]any{},
+			expectErrToContain: []string{"RuntimeErr", "integer overflow", "9223372036854775807"},
+		},
+		{
+			expr:               "0x7FFFFFFFFFFFFFFF * 2 > 0",
+			vars:               map[string]any{},
+			expectErrToContain: []string{"RuntimeErr", "integer overflow"},
+		},
+		{
+			expr:               "2 ** 64 > 0",
+			vars:               map[string]any{},
+			expectErrToContain: []string{"RuntimeErr", "integer overflow"},
+		},
+		{
+			expr: `a - "bar" == 1`,
+			vars: map[string]any{
+				"a": 1,
+			},
+			expectErrToContain: []string{"unsupported types", "-"},
+		},
+		{
+			expr: "-a == 0-5",
+			vars: map[string]any{
+				"a": 5,
+			},
+			expectedResult: true,
+		},
+		{
+			expr: "+a == 5",
+			vars: map[string]any{
+				"a": 5,
+			},
+			expectedResult: true,
+		},
+		{
+			expr:           "10 * -3 == 0-30",
+			vars:           map[string]any{},
+			expectedResult: true,
+		},
+		{
+			expr:           "10 *-3 < -29",
+			vars:           map[string]any{},
+			expectedResult: true,
+		},
+		{
+			expr:           "1 - -1 == 2",
+			vars:           map[string]any{},
+			expectedResult: true,
+		},
+		{
+			expr:           "- -1 == 1",
+			vars:           map[string]any{},
+			expectedResult: true,
+		},
+		{
+			expr:           "-2 ** 2 == -4",
+			vars:           map[string]any{},
+			expectedResult: true,
+		},
+		{
+			expr:           "2 ** -1 == 0.5",
+			vars:           map[string]any{},
+			expectedResult: true,
+		},
+		{
+			expr:           "-1.5 < -1",
+			vars:           map[string]any{},
+			expectedResult: true,
+		},
+		{
+			expr: "!(a == 1)",
+			vars: map[string]any{
+				"a": 1,
+			},
+			expectedResult: false,
+		},
+		{
+			expr: "!!(a == 1)",
+			vars: map[string]any{
+				"a": 1,
+			},
+			expectedResult: true,
+		},
+		{
+			expr: "!(a > 1) && a > 0",
+			vars: map[string]any{
+				"a": 1,
+			},
+			expectedResult: true,
+		},
+		{
+			expr: "!a",
+			vars: map[string]any{
+				"a": 1,
+			},
+			expectErrToContain: []string{"unsupported types", "!"},
+		},
+		{
+			expr: "flags & 0x04 != 0",
+			vars: map[string]any{
+				"flags": 6,
+			},
+			expectedResult: true,
+		},
+		{
+			expr: "flags & 0x04 != 0",
+			vars: map[string]any{
+				"flags": 2,
+			},
+			expectedResult: false,
+		},
+		{
+			expr: "flags & 0x04 != 0",
+			vars: map[string]any{
+				"flags": 6.5,
+			},
+			expectErrToContain: []string{"bitwise operators only support integer operands", "&"},
+		},
+		{
+			expr:           "0b0110 & 0x04 != 0",
+			vars:           map[string]any{},
+			expectedResult: true,
+		},
+		{
+			expr:           "0b0010 & 0x04 == 0",
+			vars:           map[string]any{},
+			expectedResult: true,
+		},
+		{
+			expr:           "0b0110 | 0b1001 == 0xF",
+			vars:           map[string]any{},
+			expectedResult: true,
+		},
+		{
+			expr:           "0b0110 ^ 0b0011 == 0b0101",
+			vars:           map[string]any{},
+			expectedResult: true,
+		},
+		{
+			expr:           "0b0001 | 0b0110 & 0b0100 == 5",
+			vars:           map[string]any{},
+			expectedResult: true,
+		},
+		{
+			expr:           "1 << 4 == 16",
+			vars:           map[string]any{},
+			expectedResult: true,
+		},
+		{
+			expr:           "256 >> 4 == 16",
+			vars:           map[string]any{},
+			expectedResult: true,
+		},
+		{
+			expr:           "1 << 2 + 1 == 8",
+			vars:           map[string]any{},
+			expectedResult: true,
+		},
+		{
+			expr:               "1 << 64 > 0",
+			vars:               map[string]any{},
+			expectErrToContain: []string{"RuntimeErr", "integer overflow"},
+		},
+		{
+			expr:               "1 >> -1 > 0",
+			vars:               map[string]any{},
+			expectErrToContain: []string{"RuntimeErr", "negative shift count"},
+		},
+		{
+			expr:               "1.5 | 1 > 0",
+			vars:               map[string]any{},
+			expectErrToContain: []string{"bitwise operators only support integer operands", "1.5"},
+		},
+		{
+			expr:               `"a" << 1 > 0`,
+			vars:               map[string]any{},
+			expectErrToContain: []string{"bitwise operators only support integer operands", "<<"},
+		},
+		{
+			expr: "len(tags) == 3",
+			vars: map[string]any{
+				"tags": []any{"a", "b", "c"},
+			},
+			expectedResult: true,
+		},
+		{
+			expr: `lower(msg) == "request failed"`,
+			vars: map[string]any{
+				"msg": "Request FAILED",
+			},
+			expectedResult: true,
+		},
+		{
+			expr: `contains(path, "/api")`,
+			vars: map[string]any{
+				"path": "/v1/api/users",
+			},
+			expectedResult: true,
+		},
+		{
+			expr: `starts_with(path, "/api") || ends_with(path, ".png")`,
+			vars: map[string]any{
+				"path": "/static/logo.png",
+			},
+			expectedResult: true,
+		},
+		{
+			expr: `level == "error" && regex_match(msg, "time(d)? ?out")`,
+			vars: map[string]any{
+				"level": "error",
+				"msg":   "request timed out",
+			},
+			expectedResult: true,
+		},
+		{
+			// The invalid regex is never compiled thanks to short-circuiting:
+			expr: `level == "error" && regex_match(msg, "(")`,
+			vars: map[string]any{
+				"level": "info",
+				"msg":   "request timed out",
+			},
+			expectedResult: false,
+		},
+		{
+			expr: `regex_match(msg, "(")`,
+			vars: map[string]any{
+				"msg": "request timed out",
+			},
+			expectErrToContain: []string{"RuntimeErr", "invalid regular expression"},
+		},
+		{
+			expr: "len(upper(trim(name))) == 3",
+			vars: map[string]any{
+				"name": " bob ",
+			},
+			expectedResult: true,
+		},
+		{
+			expr:           `int("42") + int(2.9) == 44`,
+			vars:           map[string]any{},
+			expectedResult: true,
+		},
+		{
+			expr:           `float("1.5") == 1.5 && str(10) == "10"`,
+			vars:           map[string]any{},
+			expectedResult: true,
+		},
+		{
+			expr:           "abs(-3) == 3",
+			vars:           map[string]any{},
+			expectedResult: true,
+		},
+		{
+			expr:           "len([1, 2, 3]) == 3",
+			vars:           map[string]any{},
+			expectedResult: true,
+		},
+		{
+			expr:           "len([]) == 0",
+			vars:           map[string]any{},
+			expectedResult: true,
+		},
+		{
+			expr:           `len({"a": 1, "b": 2}) == 2`,
+			vars:           map[string]any{},
+			expectedResult: true,
+		},
+		{
+			expr:               `len({"a": 1, "a": 2}) == 2`,
+			vars:               map[string]any{},
+			expectErrToContain: []string{"duplicate key in map literal"},
+		},
+		{
+			expr: "len(a, b) == 1",
+			vars: map[string]any{
+				"a": "foo",
+				"b": "bar",
+			},
+			expectErrToContain: []string{"wrong number of arguments", "len"},
+		},
+		{
+			expr: "lower(a) == 1",
+			vars: map[string]any{
+				"a": 10,
+			},
+			expectErrToContain: []string{"unexpected argument type", "lower"},
+		},
+		{
+			expr: `request.headers["user-agent"] == "curl"`,
+			vars: map[string]any{
+				"request": map[string]any{
+					"headers": map[string]any{
+						"user-agent": "curl",
+					},
+				},
+			},
+			expectedResult: true,
+		},
+		{
+			expr: `items[0].sku == "A1" && items[1].sku == "B2"`,
+			vars: map[string]any{
+				"items": []any{
+					map[string]any{"sku": "A1"},
+					map[string]any{"sku": "B2"},
+				},
+			},
+			expectedResult: true,
+		},
+		{
+			expr: `resp.body.errors[2] == "timeout"`,
+			vars: map[string]any{
+				"resp": map[string]any{
+					"body": map[string]any{
+						"errors": []any{"a", "b", "timeout"},
+					},
+				},
+			},
+			expectedResult: true,
+		},
+		{
+			expr: `a [ 'b c' ] . d == 1`,
+			vars: map[string]any{
+				"a": map[string]any{
+					"b c": map[string]any{"d": 1},
+				},
+			},
+			expectedResult: true,
+		},
+		{
+			expr: `matrix[1][0] == 3`,
+			vars: map[string]any{
+				"matrix": []any{[]any{1, 2}, []any{3, 4}},
+			},
+			expectedResult: true,
+		},
+		{
+			expr: `tags[len(tags) - 1] == "last"`,
+			vars: map[string]any{
+				"tags": []any{"first", "last"},
+			},
+			expectedResult: true,
+		},
+		{
+			expr: `tags[-1] == "last"`,
+			vars: map[string]any{
+				"tags": []any{"first", "last"},
+			},
+			expectedResult: true,
+		},
+		{
+			// Only ints can index lists, string keys are handled as missing fields:
+			expr: `!exists(tags["0"]) && !exists(tags["+1"]) && !exists(tags[str(0)]) && exists(tags[0])`,
+			vars: map[string]any{
+				"tags": []any{"first", "last"},
+			},
+			expectedResult: true,
+		},
+		{
+			expr: `codes["0"] == "ok" && !exists(codes["00"])`,
+			vars: map[string]any{
+				"codes": map[string]any{"0": "ok"},
+			},
+			expectedResult: true,
+		},
+		{
+			expr: `lower(a.b).c == 1`,
+			vars: map[string]any{
+				"a": map[string]any{"b": "B"},
+			},
+			expectErrToContain: []string{"unsupported types", "."},
+		},
+		{
+			expr: `{"x": a.b}.x == 2`,
+			vars: map[string]any{
+				"a": map[string]any{"b": 2},
+			},
+			expectedResult: true,
+		},
+		{
+			// Out of range indexes are handled as missing fields:
+			expr: `tags[len(tags)] == "last"`,
+			vars: map[string]any{
+				"tags": []any{"first", "last"},
+			},
+			expectedResult: false,
+		},
+		{
+			expr:           `{"x": 1}["y"] == 1`,
+			vars:           map[string]any{},
+			expectedResult: false,
+		},
+		{
+			expr: "ok == true",
+			vars: map[string]any{
+				"ok": true,
+			},
+			expectedResult: true,
+		},
+		{
+			expr: "ok != false",
+			vars: map[string]any{
+				"ok": false,
+			},
+			expectedResult: false,
+		},
+		{
+			expr:           "true",
+			vars:           map[string]any{},
+			expectedResult: true,
+		},
+		{
+			expr: "a == null",
+			vars: map[string]any{
+				"a": 1,
+			},
+			expectedResult: false,
+		},
+		{
+			expr: "a != null",
+			vars: map[string]any{
+				"a": "foo",
+			},
+			expectedResult: true,
+		},
+		{
+			expr:           "null == null",
+			vars:           map[string]any{},
+			expectedResult: true,
+		},
+		{
+			expr: "status in [500, 502, 503] and not retried",
+			vars: map[string]any{
+				"status":  502,
+				"retried": false,
+			},
+			expectedResult: true,
+		},
+		{
+			expr: "status in [500, 502, 503] and not retried",
+			vars: map[string]any{
+				"status":  502,
+				"retried": true,
+			},
+			expectedResult: false,
+		},
+		{
+			expr: "not a == 1 and not a in [2, 3] and not a > 5",
+			vars: map[string]any{
+				"a": 4,
+			},
+			expectedResult: true,
+		},
+		{
+			expr: "not a == 1 or not a in [2, 3]",
+			vars: map[string]any{
+				"a": 2,
+			},
+			expectedResult: true,
+		},
+		{
+			expr: "not a == 1",
+			vars: map[string]any{
+				"a": 1,
+			},
+			expectedResult: false,
+		},
+		{
+			expr: "NOT a == 1 AND Not a IN [2, 3] OR FALSE",
+			vars: map[string]any{
+				"a": 4,
+			},
+			expectedResult: true,
+		},
+		{
+			expr: "a == NULL Or a == TRUE",
+			vars: map[string]any{
+				"a": true,
+			},
+			expectedResult: true,
+		},
+		{
+			expr: "not not ok and ok",
+			vars: map[string]any{
+				"ok": true,
+			},
+			expectedResult: true,
+		},
+		{
+			expr: "a == 1 or a == 2",
+			vars: map[string]any{
+				"a": 2,
+			},
+			expectedResult: true,
+		},
+		{
+			expr: "status in codes",
+			vars: map[string]any{
+				"status": 404,
+				"codes":  []any{400, 404.0},
+			},
+			expectedResult: true,
+		},
+		{
+			expr:           `"b" in ["a", 1, "b"]`,
+			vars:           map[string]any{},
+			expectedResult: true,
+		},
+		{
+			expr:           `"c" in ["a", 1, "b"]`,
+			vars:           map[string]any{},
+			expectedResult: false,
+		},
+		{
+			expr: `"user-agent" in headers`,
+			vars: map[string]any{
+				"headers": map[string]any{"user-agent": "curl"},
+			},
+			expectedResult: true,
+		},
+		{
+			expr: `"/api" in path and "admin" in path == false`,
+			vars: map[string]any{
+				"path": "/v1/api/users",
+			},
+			expectedResult: true,
+		},
+		{
+			expr:               `1 in "123"`,
+			vars:               map[string]any{},
+			expectErrToContain: []string{"unsupported types", "in"},
+		},
+		{
+			expr: `user == "user"`,
+			vars: map[string]any{
+				"other": "user",
+			},
+			expectedResult: false,
+		},
+		{
+			expr: `user != "user"`,
+			vars: map[string]any{
+				"other": "user",
+			},
+			expectedResult: false,
+		},
+		{
+			expr: "!(a == 1)",
+			vars: map[string]any{
+				"b": 1,
+			},
+			expectedResult: false,
+		},
+		{
+			expr: "a == 1 || b == 1",
+			vars: map[string]any{
+				"b": 1,
+			},
+			expectedResult: true,
+		},
+		{
+			expr: "b == 1 || a == 1",
+			vars: map[string]any{
+				"b": 1,
+			},
+			expectedResult: true,
+		},
+		{
+			expr: "!(a == 1 && b == 2)",
+			vars: map[string]any{
+				"b": 1,
+			},
+			expectedResult: true,
+		},
+		{
+			expr: "!(a == 1 && b == 1)",
+			vars: map[string]any{
+				"b": 1,
+			},
+			expectedResult: false,
+		},
+		{
+			expr: "a + 1 > 0 || lower(a) == \"x\"",
+			vars: map[string]any{
+				"b": 1,
+			},
+			expectedResult: false,
+		},
+		{
+			expr: "a.b.c == 1",
+			vars: map[string]any{
+				"a": 1,
+			},
+			expectedResult: false,
+		},
+		{
+			expr: "exists(a.b) && !exists(a.c)",
+			vars: map[string]any{
+				"a": map[string]any{"b": 1},
+			},
+			expectedResult: true,
+		},
+		{
+			expr: "is_null(a) && !is_null(b)",
+			vars: map[string]any{
+				"b": 1,
+			},
+			expectedResult: true,
+		},
+		{
+			expr:           "a in [1, 2]",
+			vars:           map[string]any{},
+			expectedResult: false,
+		},
+		{
+			expr:           "!(a in [1, 2])",
+			vars:           map[string]any{},
+			expectedResult: false,
+		},
+		{
+			expr: "a == -5 && b < -1.5e3",
+			vars: map[string]any{
+				"a": -5,
+				"b": -2000.0,
+			},
+			expectedResult: true,
+		},
+		{
+			expr:           "a == null && b != null",
+			rawJSON:        `{"a": null, "b": 0}`,
+			expectedResult: true,
+		},
+		{
+			expr:           "is_null(a) && exists(a)",
+			rawJSON:        `{"a": null}`,
+			expectedResult: true,
+		},
+		{
+			expr:           "a == 1e3 && a == 1000",
+			rawJSON:        `{"a": 1E3}`,
+			expectedResult: true,
+		},
+		{
+			expr:           "flags & 0x04 != 0",
+			rawJSON:        `{"flags": 6.0}`,
+			expectedResult: true,
+		},
+		{
+			expr:           "id == 9007199254740993 && id != 9007199254740992",
+			rawJSON:        `{"id": 9007199254740993}`,
+			expectedResult: true,
+		},
+		{
+			expr:           "id == 18446744073709551615 && id > 9223372036854775807",
+			rawJSON:        `{"id": 18446744073709551615}`,
+			expectedResult: true,
+		},
+		{
+			expr:           "id != 18446744073709551614 && id > 1.5 && id < 1e20",
+			rawJSON:        `{"id": 18446744073709551615}`,
+			expectedResult: true,
+		},
+		{
+			expr:           "id in [1, 18446744073709551615]",
+			rawJSON:        `{"id": 18446744073709551615}`,
+			expectedResult: true,
+		},
+		{
+			expr:           "-id == -9223372036854775808",
+			rawJSON:        `{"id": 9223372036854775808}`,
+			expectedResult: true,
+		},
+		{
+			expr:           "len($root) == 3 && $root[0] == 1",
+			rawJSON:        `[1, 2, 3]`,
+			expectedResult: true,
+		},
+		{
+			expr:           `$root == "plain string"`,
+			rawJSON:        `"plain string"`,
+			expectedResult: true,
+		},
+		{
+			expr:           "$root.a == a",
+			rawJSON:        `{"a": 1}`,
+			expectedResult: true,
+		},
+		{
+			expr:               "a > 0",
+			rawJSON:            `{"a": 1e400}`,
+			expectErrToContain: []string{"RuntimeErr", "unable to decode JSON value"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.expr, func(t *testing.T) {
+			evaluator, err := factory(test.expr)
+			tt.AssertNoErr(t, err)
+
+			rawJSON, err := json.Marshal(test.vars)
+			tt.AssertNoErr(t, err)
+			if test.rawJSON != "" {
+				rawJSON = []byte(test.rawJSON)
+			}
+
+			result, err := evaluator.Evaluate(rawJSON)
+			if test.expectErrToContain != nil {
+				tt.AssertErrContains(t, err, test.expectErrToContain...)
+				t.Skip()
+			}
+			tt.AssertNoErr(t, err)
+
+			tt.AssertEqual(t, result, test.expectedResult)
+		})
+	}
+}
+
+func TestValue(t *testing.T, factory func(expr string) (ValueExpression, error)) {
+
+	tests := []struct {
+		expr string
+		vars map[string]any
+
+		// rawJSON is used instead of vars when set, for
+		// testing inputs that can't be built from a map
+		rawJSON string
+
+		expectedResult     any
+		expectErrToContain []string
+	}{
+		{
+			expr: "a",
+			vars: map[string]any{
+				"a": 1,
+			},
+			expectedResult: int64(1),
+		},
+		{
+			expr: "latency_ms / 1000.0",
+			vars: map[string]any{
+				"latency_ms": 1500,
+			},
+			expectedResult: 1.5,
+		},
+		{
+			expr: "latency_ms / 1000",
+			vars: map[string]any{
+				"latency_ms": 250,
+			},
+			expectedResult: 0.25,
+		},
+		{
+			expr: "latency_ms / 1000",
+			vars: map[string]any{
+				"latency_ms": 2000,
+			},
+			expectedResult: 2.0,
+		},
+		{
+			expr: "a + 1 == 2",
+			vars: map[string]any{
+				"a": 1,
+			},
+			expectedResult: true,
+		},
+		{
+			expr: `lower(msg) + "!"`,
+			vars: map[string]any{
+				"msg": "HELLO",
+			},
+			expectedResult: "hello!",
+		},
+		{
+			expr: "request.tags",
+			vars: map[string]any{
+				"request": map[string]any{
+					"tags": []any{"a", 1, true, nil},
+				},
+			},
+			expectedResult: []any{"a", int64(1), true, nil},
+		},
+		{
+			expr: "request",
+			vars: map[string]any{
+				"request": map[string]any{
+					"route": "/api",
+					"sizes": []any{1.5, map[string]any{"b": 2}},
+				},
+			},
+			expectedResult: map[string]any{
+				"route": "/api",
+				"sizes": []any{1.5, map[string]any{"b": int64(2)}},
+			},
+		},
+		{
+			expr: `{"x": a, "y": [a, 2]}`,
+			vars: map[string]any{
+				"a": "foo",
+			},
+			expectedResult: map[string]any{
+				"x": "foo",
+				"y": []any{"foo", int64(2)},
+			},
+		},
+		{
+			expr:           "a",
+			rawJSON:        `{"a": null}`,
+			expectedResult: nil,
+		},
+		{
+			expr: "missing_field",
+			vars: map[string]any{
+				"a": 1,
+			},
+			expectedResult: nil,
+		},
+		{
+			expr: "missing_field + 1",
+			vars: map[string]any{
+				"a": 1,
+			},
+			expectedResult: nil,
+		},
+		{
+			expr:           "id",
+			rawJSON:        `{"id": 18446744073709551615}`,
+			expectedResult: new(big.Int).SetUint64(18446744073709551615),
+		},
+		{
+			expr:           "$root",
+			rawJSON:        `[1, "two"]`,
+			expectedResult: []any{int64(1), "two"},
+		},
+		{
+			expr:           "[a, b, c, d]",
+			rawJSON:        `{"a": 1.0, "b": -1.5e3, "c": 1e20, "d": 2.5}`,
+			expectedResult: []any{int64(1), int64(-1500), 1e20, 2.5},
+		},
+		{
+			expr: "bin(ts, 5m)",
+			vars: map[string]any{
+				"ts": "2024-03-10T14:07:31.5Z",
+			},
+			expectedResult: TimeBucket{
+				Start: time.Date(2024, 3, 10, 14, 5, 0, 0, time.UTC),
+				Width: 5 * time.Minute,
+			},
+		},
+		{
+			expr: "bin(ts, 1h30m)",
+			vars: map[string]any{
+				"ts": "2024-03-10T14:07:31-03:00",
+			},
+			expectedResult: TimeBucket{
+				Start: time.Date(2024, 3, 10, 16, 30, 0, 0, time.UTC),
+				Width: 90 * time.Minute,
+			},
+		},
+		{
+			expr: "bin(ts, 1d)",
+			vars: map[string]any{
+				"ts": 1710079651,
+			},
+			expectedResult: TimeBucket{
+				Start: time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC),
+				Width: 24 * time.Hour,
+			},
+		},
+		{
+			expr: "bin(ts, 1.5s)",
+			vars: map[string]any{
+				"ts": 1710079651999,
+			},
+			expectedResult: TimeBucket{
+				Start: time.Date(2024, 3, 10, 14, 7, 31, 500000000, time.UTC),
+				Width: 1500 * time.Millisecond,
+			},
+		},
+		{
+			expr:    "bin(ts, 1h)",
+			rawJSON: `{"ts": 10000000000000000000}`,
+			expectedResult: TimeBucket{
+				Start: time.Date(2286, 11, 20, 17, 0, 0, 0, time.UTC),
+				Width: time.Hour,
+			},
+		},
+		{
+			expr:               "bin(ts, 1h)",
+			rawJSON:            `{"ts": 100000000000000000000000000000000000000}`,
+			expectErrToContain: []string{"RuntimeErr", "timestamp out of range"},
+		},
+		{
+			expr: "bin(ts, 1m)",
+			vars: map[string]any{
+				"ts": 1710079651.25,
+			},
+			expectedResult: TimeBucket{
+				Start: time.Date(2024, 3, 10, 14, 7, 0, 0, time.UTC),
+				Width: time.Minute,
+			},
+		},
+		{
+			expr: "bin(ts, 1m)",
+			vars: map[string]any{
+				"ts": nil,
+			},
+			expectedResult: nil,
+		},
+		{
+			expr:           "bin(ts, 1m)",
+			vars:           map[string]any{},
+			expectedResult: nil,
+		},
+		{
+			expr: `date_trunc("hour", ts)`,
+			vars: map[string]any{
+				"ts": "2024-03-10 14:07:31",
+			},
+			expectedResult: TimeBucket{
+				Start: time.Date(2024, 3, 10, 14, 0, 0, 0, time.UTC),
+				Width: time.Hour,
+			},
+		},
+		{
+			expr: `date_trunc("week", ts)`,
+			vars: map[string]any{
+				"ts": "2024-03-10",
+			},
+			expectedResult: TimeBucket{
+				Start: time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC),
+				Unit:  "week",
+			},
+		},
+		{
+			expr: `date_trunc("quarter", ts)`,
+			vars: map[string]any{
+				"ts": "2024-08-10T10:00:00Z",
+			},
+			expectedResult: TimeBucket{
+				Start: time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC),
+				Unit:  "quarter",
+			},
+		},
+		{
+			expr: `date_trunc("year", bin(ts, 1h))`,
+			vars: map[string]any{
+				"ts": "2024-08-10T10:00:00Z",
+			},
+			expectedResult: TimeBucket{
+				Start: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+				Unit:  "year",
+			},
+		},
+		{
+			expr: `date_trunc("fortnight", ts)`,
+			vars: map[string]any{
+				"ts": "2024-08-10T10:00:00Z",
+			},
+			expectErrToContain: []string{"RuntimeErr", "invalid unit", "fortnight"},
+		},
+		{
+			expr: "bin(ts, 5m)",
+			vars: map[string]any{
+				"ts": "yesterday",
+			},
+			expectErrToContain: []string{"RuntimeErr", "unable to parse timestamp", "yesterday"},
+		},
+		{
+			expr: "bin(ts, 300)",
+			vars: map[string]any{
+				"ts": "2024-08-10T10:00:00Z",
+			},
+			expectErrToContain: []string{"RuntimeErr", "positive duration"},
+		},
+		{
+			expr: "a / 0",
+			vars: map[string]any{
+				"a": 1,
+			},
+			expectErrToContain: []string{"RuntimeErr", "division by zero"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.expr, func(t *testing.T) {
+			evaluator, err := factory(test.expr)
+			tt.AssertNoErr(t, err)
+
+			rawJSON, err := json.Marshal(test.vars)
+			tt.AssertNoErr(t, err)
+			if test.rawJSON != "" {
+				rawJSON = []byte(test.rawJSON)
+			}
 
 			result, err := evaluator.Evaluate(rawJSON)
 			if test.expectErrToContain != nil {
@@ -0,0 +1,48 @@
+package evaluator
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// TimeBucket is the value produced by the time bucketing functions,
+// e.g. `bin(ts, 5m)` or `date_trunc("month", ts)`, it represents
+// the time window starting at Start.
+//
+// Windows have either a fixed Width or, for calendar units
+// whose duration varies such as "month", a Unit.
+type TimeBucket struct {
+	Start time.Time
+	Width time.Duration
+	Unit  string
+}
+
+// Next returns the bucket right after this one
+func (b TimeBucket) Next() TimeBucket {
+	next := b
+	switch b.Unit {
+	case "week":
+		next.Start = b.Start.AddDate(0, 0, 7)
+	case "month":
+		next.Start = b.Start.AddDate(0, 1, 0)
+	case "quarter":
+		next.Start = b.Start.AddDate(0, 3, 0)
+	case "year":
+		next.Start = b.Start.AddDate(1, 0, 0)
+	default:
+		next.Start = b.Start.Add(b.Width)
+	}
+
+	return next
+}
+
+// String returns the start of the bucket formatted as RFC3339
+func (b TimeBucket) String() string {
+	return b.Start.Format(time.RFC3339Nano)
+}
+
+// MarshalJSON encodes the bucket as its start time, so
+// buckets look just like timestamps on the output
+func (b TimeBucket) MarshalJSON() ([]byte, error) {
+	return json.Marshal(b.String())
+}
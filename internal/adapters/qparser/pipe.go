@@ -0,0 +1,339 @@
+package qparser
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/vingarcia/insights"
+	"github.com/vingarcia/insights/internal"
+	"github.com/vingarcia/insights/internal/adapters/evaluator"
+	"github.com/vingarcia/insights/internal/adapters/evaluator/eparser"
+)
+
+// defaultHeadSize is the number of rows kept by `head` with no arguments
+const defaultHeadSize = 10
+
+// ParsePipe parses queries written as a pipeline of stages, e.g.:
+//
+//	app_logs
+//	| where status >= 500
+//	| stats count(), p95(latency) as p95 by route
+//	| sort -count
+//	| head 20
+//
+// The available stages are:
+//
+//   - `where <expr>`: filters the records or, after stats, the rows
+//     using the names of the columns, the aggregations can also be
+//     referenced by their calls or function names, e.g. `where count
+//     > 10` or `where count() > 10`
+//   - `stats <aggregation> [as <name>], ... [by <expr>, ...]`
+//   - `sort [-]<column> [asc|desc] [nulls first|last], ...`: `-` sorts on descending order,
+//     aggregations can be referenced just by their function name, e.g. `-count`
+//   - `head [n]`: keeps only the first n rows, 10 by default
+//
+// Stages are mapped to the returned Query whenever possible, the
+// remaining ones are returned as post-processing stages that must
+// be applied, in order, to the result of the query.
+//
+// Note that `|` is the pipe separator, so the bitwise or operator
+// must be used inside brackets, e.g. `where (flags | 4) == flags`.
+func ParsePipe(query string) (internal.Query, []internal.Stage, error) {
+	s := newScanner(query)
+	s.pipeMode = true
+
+	from, err := s.scanName("a data source name")
+	if err != nil {
+		return internal.Query{}, nil, err
+	}
+
+	q := internal.Query{
+		From: from.text,
+	}
+
+	var whereExprs, havingExprs []exprText
+	var stages []internal.Stage
+	var stats selection
+	hasStats := false
+	for s.consumeRune('|') {
+		s.skipSpaces()
+		stageStart := s.pos
+		switch stageName := s.scanFuncName(); stageName {
+		case "where":
+			expr, err := s.scanExpr(nil)
+			if err != nil {
+				return internal.Query{}, nil, err
+			}
+
+			if hasStats {
+				expr, err = s.rewriteStatsColumnRefs(stats, expr)
+				if err != nil {
+					return internal.Query{}, nil, err
+				}
+			}
+
+			where, err := eparser.Parse(expr.text)
+			if err != nil && hasStats {
+				return internal.Query{}, nil, s.invalidRewrittenExprErr("where", expr, err)
+			}
+			if err != nil {
+				return internal.Query{}, nil, s.invalidExprErr("where", expr, err)
+			}
+
+			switch {
+			case q.OrderBy != nil || q.Limit != 0 || len(stages) > 0:
+				stages = append(stages, internal.FilterStage{Where: where})
+			case hasStats:
+				havingExprs = append(havingExprs, expr)
+			default:
+				whereExprs = append(whereExprs, expr)
+			}
+
+		case "stats":
+			if hasStats || q.OrderBy != nil || q.Limit != 0 || len(stages) > 0 {
+				return internal.Query{}, nil, insights.SyntaxErr("stats must come before any other stats, sort or head stages", map[string]any{
+					"pos": s.formatPos(stageStart),
+				})
+			}
+
+			stats, err = s.parseStats()
+			if err != nil {
+				return internal.Query{}, nil, err
+			}
+			q.GroupBy = stats.groupBy
+			hasStats = true
+
+		case "sort":
+			orderBy, err := s.parseSort(stats, hasStats)
+			if err != nil {
+				return internal.Query{}, nil, err
+			}
+
+			if q.OrderBy == nil && q.Limit == 0 && len(stages) == 0 {
+				q.OrderBy = orderBy
+			} else {
+				stages = append(stages, internal.SortStage{OrderBy: orderBy})
+			}
+
+		case "head":
+			n, err := s.parseHead()
+			if err != nil {
+				return internal.Query{}, nil, err
+			}
+
+			if q.Limit == 0 && len(stages) == 0 {
+				q.Limit = n
+			} else {
+				stages = append(stages, internal.HeadStage{N: n})
+			}
+
+		default:
+			return internal.Query{}, nil, insights.SyntaxErr("unknown pipeline stage", map[string]any{
+				"pos":   s.formatPos(stageStart),
+				"stage": stageName,
+				"valid": "where, stats, sort or head",
+			})
+		}
+	}
+
+	err = s.expectEnd()
+	if err != nil {
+		return internal.Query{}, nil, err
+	}
+
+	q.Where, err = combineExprs(whereExprs)
+	if err != nil {
+		return internal.Query{}, nil, err
+	}
+
+	q.Having, err = combineExprs(havingExprs)
+	if err != nil {
+		return internal.Query{}, nil, err
+	}
+
+	return q, stages, nil
+}
+
+// combineExprs combines consecutive where stages into a single
+// expression, returning nil if there are no expressions
+func combineExprs(exprs []exprText) (evaluator.Expression, error) {
+	if len(exprs) == 0 {
+		return nil, nil
+	}
+
+	if len(exprs) == 1 {
+		return eparser.Parse(exprs[0].text)
+	}
+
+	conditions := []string{}
+	for _, expr := range exprs {
+		conditions = append(conditions, "("+expr.text+")")
+	}
+
+	return eparser.Parse(strings.Join(conditions, " and "))
+}
+
+// parseStats parses the stats stage, returning the GroupBy along
+// with the columns it produces, just like the SELECT clause does
+func (s *scanner) parseStats() (selection, error) {
+	sel := selection{
+		columns: map[string]string{},
+	}
+	for {
+		item, err := s.scanExpr([][]string{{"as"}, {"by"}})
+		if err != nil {
+			return selection{}, err
+		}
+
+		aggregation, isAggregation, err := s.parseAggregation(item)
+		if err != nil {
+			return selection{}, err
+		}
+		if !isAggregation {
+			return selection{}, insights.SyntaxErr("stats expects aggregation functions", map[string]any{
+				"pos":  s.formatPos(item.start),
+				"expr": item.text,
+			})
+		}
+
+		if s.consumeKeyword("as") {
+			alias, err := s.scanName("a column name")
+			if err != nil {
+				return selection{}, err
+			}
+			aggregation.Name = alias.text
+			sel.columns[normalize(alias.text)] = alias.text
+		}
+		sel.columns[normalize(item.text)] = aggregation.Name
+
+		sel.groupBy.Aggregations = append(sel.groupBy.Aggregations, aggregation)
+		if !s.consumeRune(',') {
+			break
+		}
+	}
+
+	if s.consumeKeyword("by") {
+		keys, err := s.scanExprList(nil)
+		if err != nil {
+			return selection{}, err
+		}
+
+		for _, key := range keys {
+			_, err := eparser.ParseValue(key.text)
+			if err != nil {
+				return selection{}, s.invalidExprErr("stats", key, err)
+			}
+			sel.groupBy.Keys = append(sel.groupBy.Keys, key.text)
+			sel.columns[normalize(key.text)] = key.text
+		}
+	}
+
+	return sel, nil
+}
+
+// rewriteStatsColumnRefs rewrites the references to the columns
+// produced by stats on where stages, see rewriteColumnRefs
+func (s *scanner) rewriteStatsColumnRefs(stats selection, expr exprText) (exprText, error) {
+	rewritten, err := s.rewriteColumnRefs(expr, stats.columns, func(ref exprText, _ bool) (string, error) {
+		return s.resolveStatsColumn(stats, ref)
+	})
+	if err != nil {
+		return exprText{}, err
+	}
+
+	return exprText{
+		text:  rewritten,
+		start: expr.start,
+	}, nil
+}
+
+func (s *scanner) parseSort(stats selection, hasStats bool) ([]internal.OrderBy, error) {
+	var orderBy []internal.OrderBy
+	for {
+		desc := s.consumeRune('-')
+		if !desc {
+			s.consumeRune('+')
+		}
+
+		item, err := s.scanExpr([][]string{{"asc"}, {"desc"}, {"nulls", "first"}, {"nulls", "last"}})
+		if err != nil {
+			return nil, err
+		}
+
+		if s.consumeKeyword("desc") {
+			desc = true
+		} else {
+			s.consumeKeyword("asc")
+		}
+
+		var nulls internal.NullsOrder
+		if s.consumeKeyword("nulls", "first") {
+			nulls = internal.NullsFirst
+		} else if s.consumeKeyword("nulls", "last") {
+			nulls = internal.NullsLast
+		}
+
+		column := item.text
+		if hasStats {
+			column, err = s.resolveStatsColumn(stats, item)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		orderBy = append(orderBy, internal.OrderBy{
+			Column: column,
+			Desc:   desc,
+			Nulls:  nulls,
+		})
+
+		if !s.consumeRune(',') {
+			return orderBy, nil
+		}
+	}
+}
+
+// resolveStatsColumn finds the column produced by stats for the
+// given expression, aggregations can also be referenced just by
+// their function names, e.g. `count` for `count()`, when there
+// is a single aggregation using that function.
+func (s *scanner) resolveStatsColumn(stats selection, item exprText) (string, error) {
+	name := normalize(item.text)
+	column, found := stats.columns[name]
+	if found {
+		return column, nil
+	}
+
+	var funcMatches []string
+	for _, aggregation := range stats.groupBy.Aggregations {
+		if strings.EqualFold(aggregation.Func, name) {
+			funcMatches = append(funcMatches, aggregation.Name)
+		}
+	}
+
+	if len(funcMatches) == 1 {
+		return funcMatches[0], nil
+	}
+
+	return "", insights.SyntaxErr("unknown column", map[string]any{
+		"pos":    s.formatPos(item.start),
+		"column": item.text,
+	})
+}
+
+func (s *scanner) parseHead() (int, error) {
+	if s.atEnd() || s.isPipe(s.pos) {
+		return defaultHeadSize, nil
+	}
+
+	word := s.scanWord()
+	n, err := strconv.Atoi(word.text)
+	if err != nil || n <= 0 {
+		return 0, insights.SyntaxErr("head expects a positive integer", map[string]any{
+			"pos":      s.formatPos(word.start),
+			"received": word.text,
+		})
+	}
+
+	return n, nil
+}
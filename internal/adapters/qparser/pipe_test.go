@@ -0,0 +1,227 @@
+package qparser
+
+import (
+	"context"
+	"testing"
+
+	"github.com/vingarcia/insights/internal"
+	"github.com/vingarcia/insights/internal/adapters/datasources"
+	"github.com/vingarcia/insights/internal/adapters/evaluator/eparser"
+	"github.com/vingarcia/insights/internal/engine"
+	tt "github.com/vingarcia/insights/internal/testtools"
+)
+
+func TestParsePipe(t *testing.T) {
+	tests := []struct {
+		desc               string
+		query              string
+		expectedQuery      internal.Query
+		expectedStages     []internal.Stage
+		expectErrToContain []string
+	}{
+		{
+			desc:  "should parse a single source",
+			query: "app_logs",
+			expectedQuery: internal.Query{
+				From: "app_logs",
+			},
+		},
+		{
+			desc:  "should map stages to the query",
+			query: "app_logs | where status >= 500 | stats count(), p95(latency) by route | sort -count | head 20",
+			expectedQuery: internal.Query{
+				From:  "app_logs",
+				Where: mustParse(t, "status >= 500"),
+				GroupBy: internal.GroupBy{
+					Keys: []string{"route"},
+					Aggregations: []internal.Aggregation{
+						{Name: "count()", Func: "count"},
+						{Name: "p95(latency)", Func: "p95", Args: mustParseValues(t, "latency")},
+					},
+				},
+				OrderBy: []internal.OrderBy{
+					{Column: "count()", Desc: true},
+				},
+				Limit: 20,
+			},
+		},
+		{
+			desc: "should combine consecutive where stages",
+			query: `
+				app_logs
+				| where status >= 500 || status == 429
+				| WHERE (flags | 4) == flags
+				| head
+			`,
+			expectedQuery: internal.Query{
+				From:  "app_logs",
+				Where: mustParse(t, "(status >= 500 || status == 429) and ((flags | 4) == flags)"),
+				Limit: 10,
+			},
+		},
+		{
+			desc:  "should return the stages that can't be mapped to the query",
+			query: "app_logs | stats count() as n, max(latency) by route, method | where n > 10 | sort route desc, -max nulls last | head 5 | where n < 100 | sort n",
+			expectedQuery: internal.Query{
+				From: "app_logs",
+				GroupBy: internal.GroupBy{
+					Keys: []string{"route", "method"},
+					Aggregations: []internal.Aggregation{
+						{Name: "n", Func: "count"},
+						{Name: "max(latency)", Func: "max", Args: mustParseValues(t, "latency")},
+					},
+				},
+				Having: mustParse(t, `$root["n"] > 10`),
+				OrderBy: []internal.OrderBy{
+					{Column: "route", Desc: true},
+					{Column: "max(latency)", Desc: true, Nulls: internal.NullsLast},
+				},
+				Limit: 5,
+			},
+			expectedStages: []internal.Stage{
+				internal.FilterStage{Where: mustParse(t, `$root["n"] < 100`)},
+				internal.SortStage{OrderBy: []internal.OrderBy{
+					{Column: "n"},
+				}},
+			},
+		},
+		{
+			desc:  "should resolve the stats columns on where stages",
+			query: `app_logs | stats count(), p95(latency) as p95 by req.route | where count > 10 and count() < 100 and p95(latency) > 1 and req.route != "/health"`,
+			expectedQuery: internal.Query{
+				From: "app_logs",
+				GroupBy: internal.GroupBy{
+					Keys: []string{"req.route"},
+					Aggregations: []internal.Aggregation{
+						{Name: "count()", Func: "count"},
+						{Name: "p95", Func: "p95", Args: mustParseValues(t, "latency")},
+					},
+				},
+				Having: mustParse(t, `$root["count()"] > 10 and $root["count()"] < 100 and $root["p95"] > 1 and $root["req.route"] != "/health"`),
+			},
+		},
+		{
+			desc:  "should sort records by field names",
+			query: "app_logs | sort -ts | head 3 | where level == 'error'",
+			expectedQuery: internal.Query{
+				From: "app_logs",
+				OrderBy: []internal.OrderBy{
+					{Column: "ts", Desc: true},
+				},
+				Limit: 3,
+			},
+			expectedStages: []internal.Stage{
+				internal.FilterStage{Where: mustParse(t, "level == 'error'")},
+			},
+		},
+		{
+			desc:               "should report unknown stages",
+			query:              "app_logs | where a == 1 | top 10",
+			expectErrToContain: []string{"SyntaxErr", "unknown pipeline stage", "top", "0:26"},
+		},
+		{
+			desc:               "should report invalid expressions",
+			query:              "app_logs\n| where a == ",
+			expectErrToContain: []string{"SyntaxErr", "invalid expression", "where", "1:12"},
+		},
+		{
+			desc:               "should report stats after sort stages",
+			query:              "app_logs | sort a | stats count()",
+			expectErrToContain: []string{"SyntaxErr", "stats must come before", "0:20"},
+		},
+		{
+			desc:               "should report stats with expressions that are not aggregations",
+			query:              "app_logs | stats route",
+			expectErrToContain: []string{"SyntaxErr", "stats expects aggregation functions", "route"},
+		},
+		{
+			desc:               "should report ambiguous sort columns",
+			query:              "app_logs | stats max(a), max(b) | sort max",
+			expectErrToContain: []string{"SyntaxErr", "unknown column", "max"},
+		},
+		{
+			desc:               "should report unknown columns on where stages after stats",
+			query:              "app_logs | stats count() by route | where status >= 500",
+			expectErrToContain: []string{"SyntaxErr", "unknown column", "status", "0:42"},
+		},
+		{
+			desc:               "should report aggregations missing from stats on where stages",
+			query:              "app_logs | stats count() by route | head 5 | where sum(latency) > 10",
+			expectErrToContain: []string{"SyntaxErr", "unknown column", "sum(latency)", "0:51"},
+		},
+		{
+			desc:               "should report invalid head sizes",
+			query:              "app_logs | head -1",
+			expectErrToContain: []string{"SyntaxErr", "head expects a positive integer", "-1"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			query, stages, err := ParsePipe(test.query)
+			if test.expectErrToContain != nil {
+				tt.AssertErrContains(t, err, test.expectErrToContain...)
+				return
+			}
+			tt.AssertNoErr(t, err)
+
+			tt.AssertEqual(t, query, test.expectedQuery)
+			tt.AssertEqual(t, stages, test.expectedStages)
+		})
+	}
+}
+
+func TestPipeResults(t *testing.T) {
+	repo := datasources.Repo{
+		"logs": datasources.NewMemorySource("logs", []map[string]any{
+			{"req": map[string]any{"route": "/users"}, "latency": 10},
+			{"req": map[string]any{"route": "/users"}, "latency": 30},
+			{"req": map[string]any{"route": "/orders"}, "latency": 20},
+			{"req": map[string]any{"route": "/health"}, "latency": 1},
+		}),
+	}
+
+	tests := []struct {
+		desc           string
+		query          string
+		expectedResult internal.ResultSet
+	}{
+		{
+			desc:  "should filter the rows after stats",
+			query: "logs | stats count(), max(latency) as max by req.route | where count > 1 or req.route == '/orders'",
+			expectedResult: internal.ResultSet{
+				Columns: []string{"req.route", "count()", "max"},
+				Rows: [][]any{
+					{"/users", int64(2), int64(30)},
+					{"/orders", int64(1), int64(20)},
+				},
+			},
+		},
+		{
+			desc:  "should filter the rows on post-processing stages",
+			query: "logs | stats count() by req.route | sort req.route | head 2 | where count() == 1",
+			expectedResult: internal.ResultSet{
+				Columns: []string{"req.route", "count()"},
+				Rows: [][]any{
+					{"/health", int64(1)},
+					{"/orders", int64(1)},
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			query, stages, err := ParsePipe(test.query)
+			tt.AssertNoErr(t, err)
+
+			result, err := engine.New(repo, eparser.ParseValue).Run(context.Background(), query)
+			tt.AssertNoErr(t, err)
+
+			result, err = engine.ApplyStages(result, stages)
+			tt.AssertNoErr(t, err)
+
+			tt.AssertEqual(t, result, test.expectedResult)
+		})
+	}
+}
@@ -0,0 +1,334 @@
+package qparser
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/vingarcia/insights"
+	"github.com/vingarcia/insights/internal/adapters/evaluator/eparser"
+	"github.com/vingarcia/insights/internal/aggregators"
+)
+
+// scanner splits a query into its clauses, the expressions
+// inside each clause are not parsed by the scanner, they
+// are just delimited and then parsed by the eparser package.
+type scanner struct {
+	input []rune
+	pos   int
+
+	// pipeMode makes expressions end on pipes, i.e. `|`
+	// characters that are not part of the `||` operator
+	pipeMode bool
+}
+
+// exprText is the source text of an expression, start is
+// its position on the query, used for reporting errors
+type exprText struct {
+	text  string
+	start int
+}
+
+func newScanner(query string) *scanner {
+	return &scanner{
+		input: []rune(query),
+	}
+}
+
+// formatPos formats positions on the query as line:col
+// the same way eparser does for positions on expressions
+func (s *scanner) formatPos(index int) string {
+	var ctx eparser.ParsingCtx
+	for i := 0; i < index && i < len(s.input); i++ {
+		if s.input[i] == '\n' {
+			ctx.HandleNewLine(i)
+		}
+	}
+
+	return ctx.FormatLineCol(index)
+}
+
+func (s *scanner) skipSpaces() {
+	for s.pos < len(s.input) && unicode.IsSpace(s.input[s.pos]) {
+		s.pos++
+	}
+}
+
+// matchKeyword checks if the input at index i contains the
+// given sequence of words, ignoring case, and returns the
+// index right after the last word.
+func (s *scanner) matchKeyword(i int, words ...string) (end int, match bool) {
+	if i > 0 && isWordChar(s.input[i-1]) {
+		return 0, false
+	}
+
+	for j, word := range words {
+		if j > 0 {
+			start := i
+			for i < len(s.input) && unicode.IsSpace(s.input[i]) {
+				i++
+			}
+			if i == start {
+				return 0, false
+			}
+		}
+
+		w := []rune(word)
+		if i+len(w) > len(s.input) || !strings.EqualFold(string(s.input[i:i+len(w)]), word) {
+			return 0, false
+		}
+		i += len(w)
+
+		if i < len(s.input) && isWordChar(s.input[i]) {
+			return 0, false
+		}
+	}
+
+	return i, true
+}
+
+// consumeKeyword skips the given sequence of words if they
+// are the next words on the input, e.g. `GROUP BY`
+func (s *scanner) consumeKeyword(words ...string) bool {
+	s.skipSpaces()
+	end, match := s.matchKeyword(s.pos, words...)
+	if match {
+		s.pos = end
+	}
+
+	return match
+}
+
+func (s *scanner) expectKeyword(words ...string) error {
+	if !s.consumeKeyword(words...) {
+		return s.unexpectedInputErr(strings.Join(words, " "))
+	}
+
+	return nil
+}
+
+// expectEnd checks there is nothing left to parse,
+// except for an optional `;` at the end of the query
+func (s *scanner) expectEnd() error {
+	s.skipSpaces()
+	if s.pos < len(s.input) && s.input[s.pos] == ';' {
+		s.pos++
+		s.skipSpaces()
+	}
+
+	if s.pos < len(s.input) {
+		return s.unexpectedInputErr("the end of the query")
+	}
+
+	return nil
+}
+
+func (s *scanner) atEnd() bool {
+	s.skipSpaces()
+	return s.pos >= len(s.input) || s.input[s.pos] == ';'
+}
+
+// scanExpr returns the text of the expression starting at the
+// current position, the expression ends on the first comma,
+// `;`, pipe or stop word that is not inside brackets or strings,
+// or on a closing bracket that was not opened by the expression.
+func (s *scanner) scanExpr(stopWords [][]string) (exprText, error) {
+	s.skipSpaces()
+	start := s.pos
+
+	depth := 0
+	i := s.pos
+loop:
+	for ; i < len(s.input); i++ {
+		switch c := s.input[i]; {
+		case c == '\'' || c == '"':
+			i = s.skipStrLiteral(i)
+		case c == '(' || c == '[' || c == '{':
+			depth++
+		case c == ')' || c == ']' || c == '}':
+			if depth == 0 {
+				break loop
+			}
+			depth--
+		case depth > 0:
+		case c == ',' || c == ';':
+			break loop
+		case s.pipeMode && s.isPipe(i):
+			break loop
+		default:
+			for _, words := range stopWords {
+				if _, match := s.matchKeyword(i, words...); match {
+					break loop
+				}
+			}
+		}
+	}
+	s.pos = i
+
+	text := strings.TrimSpace(string(s.input[start:i]))
+	if text == "" {
+		return exprText{}, s.unexpectedInputErr("an expression")
+	}
+
+	return exprText{
+		text:  text,
+		start: start,
+	}, nil
+}
+
+func (s *scanner) isPipe(i int) bool {
+	return s.input[i] == '|' &&
+		(i == 0 || s.input[i-1] != '|') &&
+		(i+1 == len(s.input) || s.input[i+1] != '|')
+}
+
+// scanExprList scans a list of comma separated expressions
+func (s *scanner) scanExprList(stopWords [][]string) ([]exprText, error) {
+	var exprs []exprText
+	for {
+		expr, err := s.scanExpr(stopWords)
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, expr)
+
+		if s.pos >= len(s.input) || s.input[s.pos] != ',' {
+			return exprs, nil
+		}
+		s.pos++
+	}
+}
+
+// skipStrLiteral returns the index of the
+// quote that closes the string starting at i
+func (s *scanner) skipStrLiteral(i int) int {
+	quote := s.input[i]
+	for i++; i < len(s.input) && s.input[i] != quote; i++ {
+		if s.input[i] == '\\' {
+			i++
+		}
+	}
+
+	return i
+}
+
+// scanWord scans identifiers, numbers and unquoted names such
+// as `logs` or `access.log`, the scanned word might be empty
+func (s *scanner) scanWord() exprText {
+	s.skipSpaces()
+	start := s.pos
+	for s.pos < len(s.input) && !unicode.IsSpace(s.input[s.pos]) && !strings.ContainsRune(",;()|", s.input[s.pos]) {
+		s.pos++
+	}
+
+	return exprText{
+		text:  string(s.input[start:s.pos]),
+		start: start,
+	}
+}
+
+// scanName scans names that might be quoted, e.g. `logs`
+// or `"logs/*.json"`, and returns them unquoted
+func (s *scanner) scanName(description string) (exprText, error) {
+	s.skipSpaces()
+	if s.pos < len(s.input) && (s.input[s.pos] == '\'' || s.input[s.pos] == '"') {
+		start := s.pos
+		end := s.skipStrLiteral(start)
+		if end >= len(s.input) {
+			return exprText{}, insights.SyntaxErr("missing closing quote", map[string]any{
+				"pos": s.formatPos(start),
+			})
+		}
+		s.pos = end + 1
+
+		return exprText{
+			text:  unescape(string(s.input[start+1 : end])),
+			start: start,
+		}, nil
+	}
+
+	name := s.scanWord()
+	if name.text == "" {
+		return exprText{}, s.unexpectedInputErr(description)
+	}
+
+	return name, nil
+}
+
+func (s *scanner) unexpectedInputErr(expected string) error {
+	found := "the end of the query"
+	if s.pos < len(s.input) {
+		found = string(s.input[s.pos:min(s.pos+20, len(s.input))])
+	}
+
+	return insights.SyntaxErr("unexpected input on query", map[string]any{
+		"pos":      s.formatPos(s.pos),
+		"expected": expected,
+		"found":    found,
+	})
+}
+
+func isWordChar(c rune) bool {
+	return unicode.IsLetter(c) || unicode.IsNumber(c) || c == '_' || c == '$' || c == '.'
+}
+
+func unescape(str string) string {
+	var b strings.Builder
+	for i := 0; i < len(str); i++ {
+		if str[i] == '\\' && i+1 < len(str) {
+			i++
+		}
+		b.WriteByte(str[i])
+	}
+
+	return b.String()
+}
+
+// normalize removes the spaces outside string literals that are
+// not between two words, so `count( x )` and `count(x)` are equal,
+// and lowercases the names of aggregations, e.g. `COUNT()`
+func normalize(expr string) string {
+	s := newScanner(strings.TrimSpace(expr))
+
+	var b strings.Builder
+	for i := 0; i < len(s.input); i++ {
+		switch c := s.input[i]; {
+		case c == '\'' || c == '"':
+			end := min(s.skipStrLiteral(i), len(s.input)-1)
+			b.WriteString(string(s.input[i : end+1]))
+			i = end
+		case unicode.IsSpace(c):
+			end := i
+			for end < len(s.input) && unicode.IsSpace(s.input[end]) {
+				end++
+			}
+			if isWordChar(s.input[i-1]) && end < len(s.input) && isWordChar(s.input[end]) {
+				b.WriteRune(' ')
+			}
+			i = end - 1
+		case isWordChar(c):
+			end := i
+			for end < len(s.input) && isWordChar(s.input[end]) {
+				end++
+			}
+			word := string(s.input[i:end])
+			if s.isCallAt(end) && aggregators.IsAggregation(strings.ToLower(word)) {
+				word = strings.ToLower(word)
+			}
+			b.WriteString(word)
+			i = end - 1
+		default:
+			b.WriteRune(c)
+		}
+	}
+
+	return b.String()
+}
+
+// isCallAt checks if the input at index i, ignoring spaces,
+// starts the arguments of a function call
+func (s *scanner) isCallAt(i int) bool {
+	for i < len(s.input) && unicode.IsSpace(s.input[i]) {
+		i++
+	}
+	return i < len(s.input) && s.input[i] == '('
+}
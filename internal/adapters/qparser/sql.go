@@ -0,0 +1,779 @@
+package qparser
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/vingarcia/insights"
+	"github.com/vingarcia/insights/internal"
+	"github.com/vingarcia/insights/internal/adapters/evaluator"
+	"github.com/vingarcia/insights/internal/adapters/evaluator/eparser"
+	"github.com/vingarcia/insights/internal/aggregators"
+)
+
+// clauseKeywords delimit the expressions of each clause
+var clauseKeywords = [][]string{
+	{"FROM"},
+	{"WHERE"},
+	{"GROUP", "BY"},
+	{"HAVING"},
+	{"ORDER", "BY"},
+	{"LIMIT"},
+	{"OFFSET"},
+}
+
+var selectKeywords = append([][]string{{"AS"}, {"EXCEPT"}}, clauseKeywords...)
+
+var orderByKeywords = append([][]string{
+	{"ASC"},
+	{"DESC"},
+	{"NULLS", "FIRST"},
+	{"NULLS", "LAST"},
+}, clauseKeywords...)
+
+// ParseSQL parses queries written on a SQL-like syntax, e.g.:
+//
+//	SELECT route, count() AS requests, p95(latency_ms)
+//	FROM logs
+//	WHERE status >= 500
+//	GROUP BY route
+//	HAVING count() > 10
+//	ORDER BY requests DESC NULLS LAST
+//	LIMIT 10 OFFSET 20
+//
+// Keywords are case insensitive and all clauses but SELECT and
+// FROM are optional. The expressions are parsed by the eparser
+// package and the errors report positions as line:col.
+//
+// On queries with aggregations or a GROUP BY clause, the selected
+// expressions must either be aggregation functions or group keys,
+// and the result has the keys followed by the aggregations.
+//
+// Other queries can select any expressions, e.g. `latency / 1000 AS
+// latency_s`, and the `*` wildcard for selecting the top level fields
+// of the records, optionally excluding some of them with `* EXCEPT
+// password` or `* EXCEPT (password, token)`. The columns follow the
+// order of the SELECT clause, with the fields selected by `*` in the
+// order they first appear on the records.
+//
+// The HAVING clause is evaluated on the rows of the result, so it
+// can only use the group keys and the selected aggregations, or
+// their aliases, e.g. `HAVING req.route != "/health" and n > 10`.
+func ParseSQL(query string) (internal.Query, error) {
+	s := newScanner(query)
+
+	err := s.expectKeyword("SELECT")
+	if err != nil {
+		return internal.Query{}, err
+	}
+
+	selectItems, err := s.parseSelectItems()
+	if err != nil {
+		return internal.Query{}, err
+	}
+
+	err = s.expectKeyword("FROM")
+	if err != nil {
+		return internal.Query{}, err
+	}
+
+	from, err := s.scanName("a data source name")
+	if err != nil {
+		return internal.Query{}, err
+	}
+
+	q := internal.Query{
+		From: from.text,
+	}
+
+	if s.consumeKeyword("WHERE") {
+		where, err := s.scanExpr(clauseKeywords)
+		if err != nil {
+			return internal.Query{}, err
+		}
+
+		q.Where, err = eparser.Parse(where.text)
+		if err != nil {
+			return internal.Query{}, s.invalidExprErr("WHERE", where, err)
+		}
+	}
+
+	var groupByKeys []exprText
+	if s.consumeKeyword("GROUP", "BY") {
+		groupByKeys, err = s.scanExprList(clauseKeywords)
+		if err != nil {
+			return internal.Query{}, err
+		}
+	}
+
+	sel, err := s.buildSelection(selectItems, groupByKeys)
+	if err != nil {
+		return internal.Query{}, err
+	}
+	q.Select = sel.projections
+	q.GroupBy = sel.groupBy
+
+	if s.consumeKeyword("HAVING") {
+		q.Having, err = s.parseHaving(sel)
+		if err != nil {
+			return internal.Query{}, err
+		}
+	}
+
+	if s.consumeKeyword("ORDER", "BY") {
+		q.OrderBy, err = s.parseOrderBy(sel)
+		if err != nil {
+			return internal.Query{}, err
+		}
+	}
+
+	if s.consumeKeyword("LIMIT") {
+		q.Limit, err = s.parseLimit()
+		if err != nil {
+			return internal.Query{}, err
+		}
+	}
+
+	if s.consumeKeyword("OFFSET") {
+		q.Offset, err = s.parseOffset()
+		if err != nil {
+			return internal.Query{}, err
+		}
+	}
+
+	err = s.expectEnd()
+	if err != nil {
+		return internal.Query{}, err
+	}
+
+	return q, nil
+}
+
+// selectItem is an item of the SELECT clause, e.g. `expr AS alias`
+type selectItem struct {
+	expr   exprText
+	alias  string
+	except []string
+}
+
+func (s *scanner) parseSelectItems() ([]selectItem, error) {
+	var items []selectItem
+	for {
+		expr, err := s.scanExpr(selectKeywords)
+		if err != nil {
+			return nil, err
+		}
+		item := selectItem{expr: expr}
+
+		if s.consumeKeyword("EXCEPT") {
+			if expr.text != "*" {
+				return nil, insights.SyntaxErr("EXCEPT can only be used after `*`", map[string]any{
+					"pos":  s.formatPos(expr.start),
+					"expr": expr.text,
+				})
+			}
+
+			item.except, err = s.parseExceptList()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if s.consumeKeyword("AS") {
+			if expr.text == "*" {
+				return nil, insights.SyntaxErr("`*` can't have an alias", map[string]any{
+					"pos": s.formatPos(expr.start),
+				})
+			}
+
+			alias, err := s.scanName("a column name")
+			if err != nil {
+				return nil, err
+			}
+			item.alias = alias.text
+		}
+
+		items = append(items, item)
+		if !s.consumeRune(',') {
+			return items, nil
+		}
+	}
+}
+
+// parseExceptList parses the fields excluded from `*`,
+// either a single name or a list of names inside brackets
+func (s *scanner) parseExceptList() ([]string, error) {
+	if !s.consumeRune('(') {
+		name, err := s.scanName("a field name")
+		if err != nil {
+			return nil, err
+		}
+		return []string{name.text}, nil
+	}
+
+	var names []string
+	for {
+		name, err := s.scanName("a field name")
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name.text)
+
+		if !s.consumeRune(',') {
+			break
+		}
+	}
+
+	if !s.consumeRune(')') {
+		return nil, s.unexpectedInputErr("`)`")
+	}
+
+	return names, nil
+}
+
+// selection describes the columns selected by a query
+type selection struct {
+	groupBy     internal.GroupBy
+	projections []internal.Projection
+
+	// columns maps the normalized expressions and aliases
+	// of the selected items to the names of their columns
+	columns map[string]string
+
+	// hasWildcard is true when the columns are not
+	// known until the records are read, i.e. for `*`
+	hasWildcard bool
+}
+
+func (s *scanner) buildSelection(selectItems []selectItem, groupByKeys []exprText) (selection, error) {
+	sel := selection{
+		columns: map[string]string{},
+	}
+
+	for _, key := range groupByKeys {
+		_, err := eparser.ParseValue(key.text)
+		if err != nil {
+			return selection{}, s.invalidExprErr("GROUP BY", key, err)
+		}
+
+		sel.groupBy.Keys = append(sel.groupBy.Keys, key.text)
+		sel.columns[normalize(key.text)] = key.text
+	}
+
+	var nonAggregations []selectItem
+	for _, item := range selectItems {
+		if item.expr.text == "*" {
+			nonAggregations = append(nonAggregations, item)
+			continue
+		}
+
+		aggregation, isAggregation, err := s.parseAggregation(item.expr)
+		if err != nil {
+			return selection{}, err
+		}
+		if !isAggregation {
+			nonAggregations = append(nonAggregations, item)
+			continue
+		}
+
+		if item.alias != "" {
+			aggregation.Name = item.alias
+			sel.columns[normalize(item.alias)] = item.alias
+		}
+		sel.columns[normalize(item.expr.text)] = aggregation.Name
+		sel.groupBy.Aggregations = append(sel.groupBy.Aggregations, aggregation)
+	}
+
+	if len(sel.groupBy.Keys) == 0 && len(sel.groupBy.Aggregations) == 0 {
+		return s.buildProjections(sel, selectItems)
+	}
+
+	for _, item := range nonAggregations {
+		if _, isKey := sel.columns[normalize(item.expr.text)]; !isKey || item.expr.text == "*" {
+			return selection{}, insights.SyntaxErr("selected expressions must appear on GROUP BY or be aggregation functions", map[string]any{
+				"pos":  s.formatPos(item.expr.start),
+				"expr": item.expr.text,
+			})
+		}
+
+		if item.alias != "" {
+			return selection{}, insights.SyntaxErr("aliases are not supported for GROUP BY keys", map[string]any{
+				"pos":   s.formatPos(item.expr.start),
+				"expr":  item.expr.text,
+				"alias": item.alias,
+			})
+		}
+	}
+
+	return sel, nil
+}
+
+// buildProjections builds the projections of queries with no
+// aggregations, `SELECT *` is represented by no projections
+func (s *scanner) buildProjections(sel selection, selectItems []selectItem) (selection, error) {
+	if len(selectItems) == 1 && selectItems[0].expr.text == "*" && selectItems[0].except == nil {
+		sel.hasWildcard = true
+		return sel, nil
+	}
+
+	names := map[string]bool{}
+	for _, item := range selectItems {
+		if item.expr.text == "*" {
+			if sel.hasWildcard {
+				return selection{}, insights.SyntaxErr("`*` can only be selected once", map[string]any{
+					"pos": s.formatPos(item.expr.start),
+				})
+			}
+
+			sel.hasWildcard = true
+			sel.projections = append(sel.projections, internal.Projection{
+				Wildcard: true,
+				Except:   item.except,
+			})
+			continue
+		}
+
+		expr, err := eparser.ParseValue(item.expr.text)
+		if err != nil {
+			return selection{}, s.invalidExprErr("SELECT", item.expr, err)
+		}
+
+		name := item.expr.text
+		if item.alias != "" {
+			name = item.alias
+			sel.columns[normalize(item.alias)] = name
+		}
+		sel.columns[normalize(item.expr.text)] = name
+
+		if names[name] {
+			return selection{}, insights.SyntaxErr("duplicate column name on SELECT", map[string]any{
+				"pos":    s.formatPos(item.expr.start),
+				"column": name,
+			})
+		}
+		names[name] = true
+
+		sel.projections = append(sel.projections, internal.Projection{
+			Name: name,
+			Expr: expr,
+		})
+	}
+
+	return sel, nil
+}
+
+// parseAggregation parses select items such as `sum(latency)`,
+// returning false if the item is not an aggregation function
+func (s *scanner) parseAggregation(item exprText) (_ internal.Aggregation, isAggregation bool, _ error) {
+	call := newScanner(item.text)
+	name := call.scanFuncName()
+	if !aggregators.IsAggregation(name) || !call.consumeRune('(') {
+		return internal.Aggregation{}, false, nil
+	}
+
+	var args []exprText
+	if !call.consumeRune(')') {
+		var err error
+		args, err = call.scanExprList(nil)
+		if err != nil || !call.consumeRune(')') {
+			return internal.Aggregation{}, false, nil
+		}
+	}
+
+	if !call.atEnd() {
+		return internal.Aggregation{}, false, insights.SyntaxErr("expressions containing aggregations are not supported yet, only plain aggregations can be selected", map[string]any{
+			"pos":  s.formatPos(item.start),
+			"expr": item.text,
+		})
+	}
+
+	// Positions of the arguments are relative to the item:
+	for i := range args {
+		args[i].start += item.start
+	}
+
+	// `count(*)` is accepted as an alias of `count()`:
+	if name == "count" && len(args) == 1 && args[0].text == "*" {
+		args = nil
+	}
+
+	_, err := aggregators.New(name, len(args))
+	if err != nil {
+		return internal.Aggregation{}, false, insights.SyntaxErr("invalid aggregation", map[string]any{
+			"pos":   s.formatPos(item.start),
+			"expr":  item.text,
+			"error": err,
+		})
+	}
+
+	aggregation := internal.Aggregation{
+		Name: item.text,
+		Func: name,
+	}
+	for _, arg := range args {
+		expr, err := eparser.ParseValue(arg.text)
+		if err != nil {
+			return internal.Aggregation{}, false, s.invalidExprErr("SELECT", arg, err)
+		}
+		aggregation.Args = append(aggregation.Args, expr)
+	}
+
+	return aggregation, true, nil
+}
+
+// parseOrderBy parses the ORDER BY items, which must be selected
+// unless the query selects `*`, in which case the names of the
+// fields of the records can also be used
+func (s *scanner) parseOrderBy(sel selection) ([]internal.OrderBy, error) {
+	var orderBy []internal.OrderBy
+	for {
+		item, err := s.scanExpr(orderByKeywords)
+		if err != nil {
+			return nil, err
+		}
+
+		column, found := sel.columns[normalize(item.text)]
+		if !found {
+			if !sel.hasWildcard {
+				return nil, insights.SyntaxErr("ORDER BY expressions must be selected", map[string]any{
+					"pos":  s.formatPos(item.start),
+					"expr": item.text,
+				})
+			}
+			column = item.text
+		}
+
+		desc := s.consumeKeyword("DESC")
+		if !desc {
+			s.consumeKeyword("ASC")
+		}
+
+		var nulls internal.NullsOrder
+		if s.consumeKeyword("NULLS", "FIRST") {
+			nulls = internal.NullsFirst
+		} else if s.consumeKeyword("NULLS", "LAST") {
+			nulls = internal.NullsLast
+		}
+
+		orderBy = append(orderBy, internal.OrderBy{
+			Column: column,
+			Desc:   desc,
+			Nulls:  nulls,
+		})
+
+		s.skipSpaces()
+		if !s.consumeRune(',') {
+			return orderBy, nil
+		}
+	}
+}
+
+func (s *scanner) parseLimit() (int, error) {
+	word := s.scanWord()
+	limit, err := strconv.Atoi(word.text)
+	if err != nil || limit <= 0 {
+		return 0, insights.SyntaxErr("LIMIT expects a positive integer", map[string]any{
+			"pos":      s.formatPos(word.start),
+			"received": word.text,
+		})
+	}
+
+	return limit, nil
+}
+
+func (s *scanner) parseOffset() (int, error) {
+	word := s.scanWord()
+	offset, err := strconv.Atoi(word.text)
+	if err != nil || offset < 0 {
+		return 0, insights.SyntaxErr("OFFSET expects a non-negative integer", map[string]any{
+			"pos":      s.formatPos(word.start),
+			"received": word.text,
+		})
+	}
+
+	return offset, nil
+}
+
+// parseHaving parses the HAVING expression, the aggregations and
+// group keys used on it are replaced by references to their columns
+// on the result, e.g. `count() > 10` becomes `$root["count()"] > 10`
+func (s *scanner) parseHaving(sel selection) (evaluator.Expression, error) {
+	having, err := s.scanExpr(clauseKeywords)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sel.groupBy.Keys) == 0 && len(sel.groupBy.Aggregations) == 0 {
+		return nil, insights.SyntaxErr("HAVING is only supported on queries with aggregations, use WHERE instead", map[string]any{
+			"pos": s.formatPos(having.start),
+		})
+	}
+
+	rewritten, err := s.rewriteColumnRefs(having, sel.columns, func(ref exprText, isAggregation bool) (string, error) {
+		column, found := sel.columns[normalize(ref.text)]
+		if found {
+			return column, nil
+		}
+
+		title := "HAVING can only use the group keys and the selected aggregations"
+		if isAggregation {
+			title = "HAVING aggregations must be selected"
+		}
+		return "", insights.SyntaxErr(title, map[string]any{
+			"pos":  s.formatPos(ref.start),
+			"expr": ref.text,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	where, err := eparser.Parse(rewritten)
+	if err != nil {
+		return nil, s.invalidRewrittenExprErr("HAVING", having, err)
+	}
+
+	return where, nil
+}
+
+// rewriteColumnRefs replaces the references to the columns of a
+// result on expr by `$root["<column>"]`, so expressions evaluated
+// on the rows of the result can use columns whose names are not
+// valid field names, e.g. `count()` or `req.route`.
+//
+// Names and aggregation calls are resolved by resolve, which should
+// return an error for unknown columns. Other function calls are only
+// replaced if they are on columns, e.g. `bin(ts, 5m)` for a group key,
+// and so are the prefixes of nested fields, e.g. `req` for `req.route`.
+func (s *scanner) rewriteColumnRefs(
+	expr exprText,
+	columns map[string]string,
+	resolve func(ref exprText, isAggregation bool) (column string, _ error),
+) (string, error) {
+	e := newScanner(expr.text)
+
+	var b strings.Builder
+	writeColumn := func(column string) {
+		b.WriteString("$root[" + strconv.Quote(column) + "]")
+	}
+
+	for i := 0; i < len(e.input); {
+		c := e.input[i]
+		if c == '\'' || c == '"' {
+			end := min(e.skipStrLiteral(i), len(e.input)-1) + 1
+			b.WriteString(string(e.input[i:end]))
+			i = end
+			continue
+		}
+
+		if !isWordChar(c) {
+			b.WriteRune(c)
+			i++
+			continue
+		}
+
+		// Words are always consumed whole, so `a.b` is never split:
+		wordEnd := i
+		for wordEnd < len(e.input) && isWordChar(e.input[wordEnd]) {
+			wordEnd++
+		}
+		word := string(e.input[i:wordEnd])
+
+		// Special variables such as `$root`, numbers, accesses
+		// to the fields of computed values, e.g. `f(x).y`, and
+		// keywords are kept as they are:
+		if c == '$' || c == '.' || unicode.IsNumber(c) || eparser.IsReservedWord(word) {
+			b.WriteString(word)
+			i = wordEnd
+			continue
+		}
+
+		name, callEnd, isCall := e.scanCall(i)
+		switch {
+		case isCall && aggregators.IsAggregation(name):
+			column, err := resolve(exprText{
+				text:  string(e.input[i:callEnd]),
+				start: expr.start + i,
+			}, true)
+			if err != nil {
+				return "", err
+			}
+			writeColumn(column)
+			i = callEnd
+
+		case isCall:
+			column, found := columns[normalize(string(e.input[i:callEnd]))]
+			if found {
+				writeColumn(column)
+				i = callEnd
+			} else {
+				b.WriteString(word)
+				i = wordEnd
+			}
+
+		default:
+			column, field, found := findColumnPrefix(columns, word)
+			if !found {
+				var err error
+				column, err = resolve(exprText{
+					text:  word,
+					start: expr.start + i,
+				}, false)
+				if err != nil {
+					return "", err
+				}
+			}
+			writeColumn(column)
+			b.WriteString(field)
+			i = wordEnd
+		}
+	}
+
+	return b.String(), nil
+}
+
+// findColumnPrefix finds the column referenced by names such
+// as `req.route`, which might be either a column named after
+// the whole name or a field of the column `req`
+func findColumnPrefix(columns map[string]string, name string) (column string, field string, found bool) {
+	for end := len(name); end > 0; end = strings.LastIndexByte(name[:end], '.') {
+		column, found := columns[name[:end]]
+		if found {
+			return column, name[end:], true
+		}
+	}
+
+	return "", "", false
+}
+
+// scanCall checks if there is a function call starting at index
+// i, e.g. `sum(latency)`, and returns the lowercase name of the
+// function and the index right after its closing bracket
+func (s *scanner) scanCall(i int) (name string, end int, isCall bool) {
+	if i > 0 && isWordChar(s.input[i-1]) {
+		return "", 0, false
+	}
+
+	s.pos = i
+	name = s.scanFuncName()
+	if name == "" || !s.consumeRune('(') {
+		return "", 0, false
+	}
+
+	if !s.consumeRune(')') {
+		_, err := s.scanExprList(nil)
+		if err != nil || !s.consumeRune(')') {
+			return "", 0, false
+		}
+	}
+
+	return name, s.pos, true
+}
+
+// scanFuncName scans the name of a function, returning
+// an empty string if the input doesn't start with a name
+func (s *scanner) scanFuncName() string {
+	s.skipSpaces()
+	start := s.pos
+	for s.pos < len(s.input) && (unicode.IsLetter(s.input[s.pos]) || unicode.IsNumber(s.input[s.pos]) || s.input[s.pos] == '_') {
+		s.pos++
+	}
+
+	return strings.ToLower(string(s.input[start:s.pos]))
+}
+
+func (s *scanner) consumeRune(r rune) bool {
+	s.skipSpaces()
+	if s.pos < len(s.input) && s.input[s.pos] == r {
+		s.pos++
+		return true
+	}
+
+	return false
+}
+
+// invalidExprErr reports errors returned by eparser for an
+// expression, translating their positions to the query
+func (s *scanner) invalidExprErr(clause string, expr exprText, err error) error {
+	pos := expr.start
+	if offset, found := parseExprPos(expr.text, err); found {
+		pos += offset
+	}
+
+	return insights.SyntaxErr("invalid expression", map[string]any{
+		"clause": clause,
+		"pos":    s.formatPos(pos),
+		"expr":   expr.text,
+		"error":  withoutPos(err),
+	})
+}
+
+// invalidRewrittenExprErr is like invalidExprErr for expressions
+// parsed after rewriting their column references, where the
+// positions of eparser no longer match the query
+func (s *scanner) invalidRewrittenExprErr(clause string, expr exprText, err error) error {
+	return insights.SyntaxErr("invalid expression", map[string]any{
+		"clause": clause,
+		"pos":    s.formatPos(expr.start),
+		"expr":   expr.text,
+		"error":  withoutPos(err),
+	})
+}
+
+// parseExprPos converts the line:col position of an eparser
+// error back into an index on the text of the expression
+func parseExprPos(text string, err error) (index int, found bool) {
+	e, ok := err.(insights.Err)
+	if !ok {
+		return 0, false
+	}
+	pos, ok := e.Data["pos"].(string)
+	if !ok {
+		return 0, false
+	}
+
+	lineStr, colStr, ok := strings.Cut(pos, ":")
+	if !ok {
+		return 0, false
+	}
+	line, err := strconv.Atoi(lineStr)
+	if err != nil {
+		return 0, false
+	}
+	col, err := strconv.Atoi(colStr)
+	if err != nil {
+		return 0, false
+	}
+
+	runes := []rune(text)
+	for line > 0 && index < len(runes) {
+		if runes[index] == '\n' {
+			line--
+		}
+		index++
+	}
+
+	return min(index+col, len(runes)), true
+}
+
+// withoutPos removes the position from eparser errors, so the
+// errors wrapping them only report positions on the query
+func withoutPos(err error) error {
+	e, ok := err.(insights.Err)
+	if !ok {
+		return err
+	}
+	if _, found := e.Data["pos"]; !found {
+		return err
+	}
+
+	data := make(map[string]any, len(e.Data))
+	for k, v := range e.Data {
+		if k != "pos" {
+			data[k] = v
+		}
+	}
+	e.Data = data
+	return e
+}
@@ -0,0 +1,432 @@
+package qparser
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/vingarcia/insights/internal"
+	"github.com/vingarcia/insights/internal/adapters/datasources"
+	"github.com/vingarcia/insights/internal/adapters/evaluator"
+	"github.com/vingarcia/insights/internal/adapters/evaluator/eparser"
+	"github.com/vingarcia/insights/internal/engine"
+	tt "github.com/vingarcia/insights/internal/testtools"
+)
+
+func TestParseSQL(t *testing.T) {
+	tests := []struct {
+		desc               string
+		query              string
+		expectedQuery      internal.Query
+		expectErrToContain []string
+	}{
+		{
+			desc:  "should parse simple queries",
+			query: "SELECT * FROM logs",
+			expectedQuery: internal.Query{
+				From: "logs",
+			},
+		},
+		{
+			desc: "should parse all clauses",
+			query: `
+				SELECT route, count(), p95(latency_ms)
+				FROM logs
+				WHERE status >= 500 and req.method == "GET"
+				GROUP BY route
+				ORDER BY count() DESC, route
+				LIMIT 10;
+			`,
+			expectedQuery: internal.Query{
+				From:  "logs",
+				Where: mustParse(t, `status >= 500 and req.method == "GET"`),
+				GroupBy: internal.GroupBy{
+					Keys: []string{"route"},
+					Aggregations: []internal.Aggregation{
+						{Name: "count()", Func: "count"},
+						{Name: "p95(latency_ms)", Func: "p95", Args: mustParseValues(t, "latency_ms")},
+					},
+				},
+				OrderBy: []internal.OrderBy{
+					{Column: "count()", Desc: true},
+					{Column: "route"},
+				},
+				Limit: 10,
+			},
+		},
+		{
+			desc:  "should ignore the case of keywords and aggregation functions",
+			query: `select COUNT(*), Percentile(latency, 90) from "access logs" group by bin(ts, 5m) order by bin( ts, 5m ) asc`,
+			expectedQuery: internal.Query{
+				From: "access logs",
+				GroupBy: internal.GroupBy{
+					Keys: []string{"bin(ts, 5m)"},
+					Aggregations: []internal.Aggregation{
+						{Name: "COUNT(*)", Func: "count"},
+						{Name: "Percentile(latency, 90)", Func: "percentile", Args: mustParseValues(t, "latency", "90")},
+					},
+				},
+				OrderBy: []internal.OrderBy{
+					{Column: "bin(ts, 5m)"},
+				},
+			},
+		},
+		{
+			desc:  "should ignore the case of aggregation functions when resolving columns",
+			query: `SELECT status, COUNT(), max(latency) FROM logs GROUP BY status HAVING count() > 1 and MAX( latency ) < 500 ORDER BY count() DESC, Max(latency)`,
+			expectedQuery: internal.Query{
+				From: "logs",
+				GroupBy: internal.GroupBy{
+					Keys: []string{"status"},
+					Aggregations: []internal.Aggregation{
+						{Name: "COUNT()", Func: "count"},
+						{Name: "max(latency)", Func: "max", Args: mustParseValues(t, "latency")},
+					},
+				},
+				Having: mustParse(t, `$root["COUNT()"] > 1 and $root["max(latency)"] < 500`),
+				OrderBy: []internal.OrderBy{
+					{Column: "COUNT()", Desc: true},
+					{Column: "max(latency)"},
+				},
+			},
+		},
+		{
+			desc:  "should not confuse keywords inside expressions",
+			query: `SELECT * FROM logs WHERE msg == "limit from where" and fromage == 1 and lim.limit == 2`,
+			expectedQuery: internal.Query{
+				From:  "logs",
+				Where: mustParse(t, `msg == "limit from where" and fromage == 1 and lim.limit == 2`),
+			},
+		},
+		{
+			desc:  "should ignore the case of keywords inside expressions",
+			query: `SELECT * FROM logs WHERE NOT status IN codes AND (ok == TRUE OR err == NULL)`,
+			expectedQuery: internal.Query{
+				From:  "logs",
+				Where: mustParse(t, `not status in codes and (ok == true or err == null)`),
+			},
+		},
+		{
+			desc:  "should ignore the case of keywords inside HAVING",
+			query: `SELECT route, count() FROM logs GROUP BY route HAVING count() > 1 AND NOT route == "/health"`,
+			expectedQuery: internal.Query{
+				From: "logs",
+				GroupBy: internal.GroupBy{
+					Keys: []string{"route"},
+					Aggregations: []internal.Aggregation{
+						{Name: "count()", Func: "count"},
+					},
+				},
+				Having: mustParse(t, `$root["count()"] > 1 and not $root["route"] == "/health"`),
+			},
+		},
+		{
+			desc:  "should order queries with no aggregations by field names",
+			query: `SELECT * FROM logs ORDER BY latency DESC LIMIT 1`,
+			expectedQuery: internal.Query{
+				From: "logs",
+				OrderBy: []internal.OrderBy{
+					{Column: "latency", Desc: true},
+				},
+				Limit: 1,
+			},
+		},
+		{
+			desc: "should parse HAVING, OFFSET and NULLS ordering",
+			query: `
+				SELECT route, count(*), max(latency)
+				FROM logs
+				GROUP BY route
+				HAVING count( * ) > 10 and route != "/health" and max( latency ) < 500
+				ORDER BY max(latency) DESC NULLS LAST, route NULLS FIRST
+				LIMIT 10 OFFSET 20
+			`,
+			expectedQuery: internal.Query{
+				From: "logs",
+				GroupBy: internal.GroupBy{
+					Keys: []string{"route"},
+					Aggregations: []internal.Aggregation{
+						{Name: "count(*)", Func: "count"},
+						{Name: "max(latency)", Func: "max", Args: mustParseValues(t, "latency")},
+					},
+				},
+				Having: mustParse(t, `$root["count(*)"] > 10 and $root["route"] != "/health" and $root["max(latency)"] < 500`),
+				OrderBy: []internal.OrderBy{
+					{Column: "max(latency)", Desc: true, Nulls: internal.NullsLast},
+					{Column: "route", Nulls: internal.NullsFirst},
+				},
+				Limit:  10,
+				Offset: 20,
+			},
+		},
+		{
+			desc:  "should parse OFFSET without LIMIT",
+			query: `SELECT * FROM logs OFFSET 0`,
+			expectedQuery: internal.Query{
+				From: "logs",
+			},
+		},
+		{
+			desc: "should parse projections",
+			query: `
+				SELECT ts, req.route, latency_ms / 1000 AS latency_s, * EXCEPT (password, "api key")
+				FROM logs
+				ORDER BY latency_s DESC, req.route, status
+			`,
+			expectedQuery: internal.Query{
+				Select: []internal.Projection{
+					{Name: "ts", Expr: mustParseValues(t, "ts")[0]},
+					{Name: "req.route", Expr: mustParseValues(t, "req.route")[0]},
+					{Name: "latency_s", Expr: mustParseValues(t, "latency_ms / 1000")[0]},
+					{Wildcard: true, Except: []string{"password", "api key"}},
+				},
+				From: "logs",
+				OrderBy: []internal.OrderBy{
+					{Column: "latency_s", Desc: true},
+					{Column: "req.route"},
+					{Column: "status"},
+				},
+			},
+		},
+		{
+			desc:  "should parse projections with a single excluded field",
+			query: `SELECT * EXCEPT password FROM users ORDER BY latency_ms/1000`,
+			expectedQuery: internal.Query{
+				Select: []internal.Projection{
+					{Wildcard: true, Except: []string{"password"}},
+				},
+				From: "users",
+				OrderBy: []internal.OrderBy{
+					{Column: "latency_ms/1000"},
+				},
+			},
+		},
+		{
+			desc:  "should resolve the aliases of aggregations",
+			query: `SELECT count() AS n, route, avg(latency) AS "avg latency" FROM logs GROUP BY route HAVING count() > 1 and n < 10 ORDER BY avg( latency ), n DESC`,
+			expectedQuery: internal.Query{
+				From: "logs",
+				GroupBy: internal.GroupBy{
+					Keys: []string{"route"},
+					Aggregations: []internal.Aggregation{
+						{Name: "n", Func: "count"},
+						{Name: "avg latency", Func: "avg", Args: mustParseValues(t, "latency")},
+					},
+				},
+				Having: mustParse(t, `$root["n"] > 1 and $root["n"] < 10`),
+				OrderBy: []internal.OrderBy{
+					{Column: "avg latency"},
+					{Column: "n", Desc: true},
+				},
+			},
+		},
+		{
+			desc: "should resolve group keys on HAVING",
+			query: `
+				SELECT req.route, count()
+				FROM logs
+				GROUP BY req.route, bin(ts, 5m), req
+				HAVING req.route == '/a' and req.route != "req.route" and bin( ts, 5m ) != null and req.method == "GET" and $root["count()"] > 1
+			`,
+			expectedQuery: internal.Query{
+				From: "logs",
+				GroupBy: internal.GroupBy{
+					Keys: []string{"req.route", "bin(ts, 5m)", "req"},
+					Aggregations: []internal.Aggregation{
+						{Name: "count()", Func: "count"},
+					},
+				},
+				Having: mustParse(t, `$root["req.route"] == '/a' and $root["req.route"] != "req.route" and $root["bin(ts, 5m)"] != null and $root["req"].method == "GET" and $root["count()"] > 1`),
+			},
+		},
+		{
+			desc:               "should report missing clauses",
+			query:              "SELECT *",
+			expectErrToContain: []string{"SyntaxErr", "unexpected input", "FROM", "0:8"},
+		},
+		{
+			desc:               "should report the position of invalid expressions",
+			query:              "SELECT *\nFROM logs\nWHERE status >= ",
+			expectErrToContain: []string{"SyntaxErr", "invalid expression", "WHERE", "2:15", "expected operand after operator"},
+		},
+		{
+			desc:               "should report the position of errors in the middle of expressions",
+			query:              "SELECT * FROM logs WHERE a == 1\n  and b == 1 2 and c",
+			expectErrToContain: []string{"SyntaxErr", "invalid expression", "WHERE", "1:13"},
+		},
+		{
+			desc:               "should report invalid aggregation arguments",
+			query:              "SELECT sum(a +) FROM logs",
+			expectErrToContain: []string{"SyntaxErr", "invalid expression", "0:14", "a +"},
+		},
+		{
+			desc:               "should report expressions starting with aggregations",
+			query:              "SELECT route, count() + 1 FROM logs GROUP BY route",
+			expectErrToContain: []string{"SyntaxErr", "not supported yet", "count() + 1", "0:14"},
+		},
+		{
+			desc:               "should report expressions starting with aggregations with arguments",
+			query:              "SELECT sum(latency) * 100 AS x FROM logs",
+			expectErrToContain: []string{"SyntaxErr", "not supported yet", "sum(latency) * 100", "0:7"},
+		},
+		{
+			desc:               "should report aggregations followed by unexpected text",
+			query:              "SELECT count() junk AS x FROM logs",
+			expectErrToContain: []string{"SyntaxErr", "not supported yet", "count() junk", "0:7"},
+		},
+		{
+			desc:               "should report aggregations with the wrong number of arguments",
+			query:              "SELECT avg() FROM logs",
+			expectErrToContain: []string{"SyntaxErr", "invalid aggregation", "wrong number of arguments", "avg"},
+		},
+		{
+			desc:               "should report selected expressions missing from GROUP BY",
+			query:              "SELECT route, status, count() FROM logs GROUP BY route",
+			expectErrToContain: []string{"SyntaxErr", "must appear on GROUP BY", "status", "0:14"},
+		},
+		{
+			desc:               "should report `*` on queries with aggregations",
+			query:              "SELECT *, count() FROM logs",
+			expectErrToContain: []string{"SyntaxErr", "must appear on GROUP BY", "0:7"},
+		},
+		{
+			desc:               "should report aliases for group keys",
+			query:              "SELECT route AS r, count() FROM logs GROUP BY route",
+			expectErrToContain: []string{"SyntaxErr", "aliases are not supported for GROUP BY keys", "0:7"},
+		},
+		{
+			desc:               "should report EXCEPT after expressions",
+			query:              "SELECT latency EXCEPT a FROM logs",
+			expectErrToContain: []string{"SyntaxErr", "EXCEPT can only be used after `*`", "latency"},
+		},
+		{
+			desc:               "should report `*` selected more than once",
+			query:              "SELECT *, * EXCEPT a FROM logs",
+			expectErrToContain: []string{"SyntaxErr", "`*` can only be selected once", "0:10"},
+		},
+		{
+			desc:               "should report duplicate column names",
+			query:              "SELECT a, b AS a FROM logs",
+			expectErrToContain: []string{"SyntaxErr", "duplicate column name", "0:10"},
+		},
+		{
+			desc:               "should report invalid projections",
+			query:              "SELECT a +, b FROM logs",
+			expectErrToContain: []string{"SyntaxErr", "invalid expression", "SELECT", "0:10"},
+		},
+		{
+			desc:               "should report ORDER BY on fields that are not projected",
+			query:              "SELECT a, b FROM logs ORDER BY c",
+			expectErrToContain: []string{"SyntaxErr", "ORDER BY expressions must be selected", "c"},
+		},
+		{
+			desc:               "should report ORDER BY expressions that are not selected",
+			query:              "SELECT count() FROM logs GROUP BY route ORDER BY status",
+			expectErrToContain: []string{"SyntaxErr", "ORDER BY expressions must be selected", "status"},
+		},
+		{
+			desc:               "should report invalid limits",
+			query:              "SELECT * FROM logs LIMIT ten",
+			expectErrToContain: []string{"SyntaxErr", "LIMIT expects a positive integer", "ten"},
+		},
+		{
+			desc:               "should report invalid offsets",
+			query:              "SELECT * FROM logs LIMIT 10 OFFSET -1",
+			expectErrToContain: []string{"SyntaxErr", "OFFSET expects a non-negative integer", "-1"},
+		},
+		{
+			desc:               "should report HAVING on queries with no aggregations",
+			query:              "SELECT * FROM logs HAVING a > 1",
+			expectErrToContain: []string{"SyntaxErr", "HAVING is only supported on queries with aggregations", "0:26"},
+		},
+		{
+			desc:               "should report HAVING aggregations that are not selected",
+			query:              "SELECT route, count() FROM logs GROUP BY route HAVING avg(latency) > 10",
+			expectErrToContain: []string{"SyntaxErr", "HAVING aggregations must be selected", "avg(latency)", "0:54"},
+		},
+		{
+			desc:               "should report HAVING names that are not group keys",
+			query:              "SELECT count() FROM logs GROUP BY route HAVING status > 1",
+			expectErrToContain: []string{"SyntaxErr", "HAVING can only use the group keys and the selected aggregations", "status", "0:47"},
+		},
+		{
+			desc:               "should report clauses out of order",
+			query:              "SELECT * FROM logs LIMIT 10 WHERE a == 1",
+			expectErrToContain: []string{"SyntaxErr", "unexpected input", "the end of the query", "WHERE a == 1"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			query, err := ParseSQL(test.query)
+			if test.expectErrToContain != nil {
+				tt.AssertErrContains(t, err, test.expectErrToContain...)
+				return
+			}
+			tt.AssertNoErr(t, err)
+
+			tt.AssertEqual(t, query, test.expectedQuery)
+		})
+	}
+}
+
+func TestSQLResults(t *testing.T) {
+	repo := datasources.Repo{
+		"logs": datasources.NewMemorySource("logs", []map[string]any{
+			{"ts": "2024-03-10T14:00:10Z", "route": "/users", "latency_ms": 250},
+			{"ts": "2024-03-10T14:00:11Z", "route": "/orders", "latency_ms": 2000},
+		}),
+	}
+
+	query, err := ParseSQL("SELECT ts, route, latency_ms / 1000 AS latency_s FROM logs")
+	tt.AssertNoErr(t, err)
+
+	result, err := engine.New(repo, eparser.ParseValue).Run(context.Background(), query)
+	tt.AssertNoErr(t, err)
+
+	tt.AssertEqual(t, result, internal.ResultSet{
+		Columns: []string{"ts", "route", "latency_s"},
+		Rows: [][]any{
+			{"2024-03-10T14:00:10Z", "/users", 0.25},
+			{"2024-03-10T14:00:11Z", "/orders", 2.0},
+		},
+	})
+}
+
+func TestHavingOnResultRows(t *testing.T) {
+	query, err := ParseSQL(`SELECT req.route, count() AS n FROM logs GROUP BY req.route HAVING req.route == '/a' and count() > 1`)
+	tt.AssertNoErr(t, err)
+
+	// The rows of the result have a column for each group key and aggregation:
+	match, err := query.Having.Evaluate([]byte(`{"req.route": "/a", "n": 2}`))
+	tt.AssertNoErr(t, err)
+	tt.AssertEqual(t, match, true)
+
+	match, err = query.Having.Evaluate([]byte(`{"req.route": "/b", "n": 2}`))
+	tt.AssertNoErr(t, err)
+	tt.AssertEqual(t, match, false)
+}
+
+func TestInvalidExprPosition(t *testing.T) {
+	_, err := ParseSQL("SELECT * FROM logs WHERE a == 1 2")
+	tt.AssertErrContains(t, err, "invalid expression", "pos = 0:32")
+
+	// The position of the eparser error is only reported once:
+	tt.AssertEqual(t, strings.Count(err.Error(), "pos = "), 1)
+}
+
+func mustParse(t *testing.T, expr string) evaluator.Expression {
+	e, err := eparser.Parse(expr)
+	tt.AssertNoErr(t, err)
+	return e
+}
+
+func mustParseValues(t *testing.T, exprs ...string) []evaluator.ValueExpression {
+	values := []evaluator.ValueExpression{}
+	for _, expr := range exprs {
+		v, err := eparser.ParseValue(expr)
+		tt.AssertNoErr(t, err)
+		values = append(values, v)
+	}
+	return values
+}
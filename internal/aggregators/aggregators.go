@@ -0,0 +1,533 @@
+package aggregators
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"reflect"
+	"strings"
+
+	"github.com/vingarcia/insights"
+	"github.com/vingarcia/insights/internal"
+)
+
+// Aggregator accumulates the arguments received for each
+// record of a group and computes the aggregated value.
+//
+// The partial state of all aggregators is kept on exported
+// fields so it can be encoded as JSON, and partial states
+// computed separately, e.g. on different files or goroutines,
+// can be combined using the Merge method.
+//
+// Null and missing values (i.e. nil arguments) are ignored by
+// all aggregators, so `avg(x)` is the average of the non-null
+// values of `x` just like in SQL.
+type Aggregator interface {
+	// Add receives the already evaluated arguments of the
+	// aggregation function for a single record
+	Add(args []any) error
+
+	// Merge combines the partial state of another
+	// aggregator of the same type into this one
+	Merge(other Aggregator) error
+
+	// Result returns the aggregated value
+	Result() any
+}
+
+// Factory creates a new empty Aggregator
+type Factory func() Aggregator
+
+type spec struct {
+	factory Factory
+	minArgs int
+	maxArgs int
+}
+
+var registry = map[string]spec{
+	"count":  {factory: newCount, minArgs: 0, maxArgs: 1},
+	"sum":    {factory: newSum, minArgs: 1, maxArgs: 1},
+	"avg":    {factory: newAvg, minArgs: 1, maxArgs: 1},
+	"min":    {factory: newMin, minArgs: 1, maxArgs: 1},
+	"max":    {factory: newMax, minArgs: 1, maxArgs: 1},
+	"first":  {factory: newFirst, minArgs: 1, maxArgs: 1},
+	"last":   {factory: newLast, minArgs: 1, maxArgs: 1},
+	"stddev": {factory: newStddev, minArgs: 1, maxArgs: 1},
+
+	// Approximate percentiles, see ddsketch for details:
+	"p50":        {factory: newPercentileFactory(50), minArgs: 1, maxArgs: 1},
+	"p95":        {factory: newPercentileFactory(95), minArgs: 1, maxArgs: 1},
+	"p99":        {factory: newPercentileFactory(99), minArgs: 1, maxArgs: 1},
+	"percentile": {factory: newPercentile, minArgs: 2, maxArgs: 2},
+
+	// Approximate distinct counts and most frequent values:
+	"count_distinct": {factory: newCountDistinct, minArgs: 1, maxArgs: 1},
+	"top_k":          {factory: newTopK, minArgs: 2, maxArgs: 2},
+}
+
+// IsAggregation checks if name is a registered aggregation function
+func IsAggregation(name string) bool {
+	_, found := registry[name]
+	return found
+}
+
+// New creates an empty aggregator for the aggregation function
+// with the given name, e.g. "count", after checking it accepts
+// the number of arguments it will receive
+func New(name string, numArgs int) (Aggregator, error) {
+	s, found := registry[name]
+	if !found {
+		return nil, insights.SyntaxErr("unknown aggregation function", map[string]any{
+			"name": name,
+		})
+	}
+
+	if numArgs < s.minArgs || numArgs > s.maxArgs {
+		return nil, insights.SyntaxErr("wrong number of arguments for aggregation function", map[string]any{
+			"name":     name,
+			"minArgs":  s.minArgs,
+			"maxArgs":  s.maxArgs,
+			"received": numArgs,
+		})
+	}
+
+	return s.factory(), nil
+}
+
+// count counts the number of records of the group when called
+// with no arguments, e.g. `count()`, or the number of records
+// where its argument is not null, e.g. `count(user_id)`
+type count struct {
+	N int64
+}
+
+func newCount() Aggregator {
+	return &count{}
+}
+
+func (c *count) Add(args []any) error {
+	if len(args) > 0 && args[0] == nil {
+		return nil
+	}
+
+	c.N++
+	return nil
+}
+
+func (c *count) Merge(other Aggregator) error {
+	o, err := castForMerge[*count](c, other)
+	if err != nil {
+		return err
+	}
+
+	c.N += o.N
+	return nil
+}
+
+func (c *count) Result() any {
+	return c.N
+}
+
+// sum keeps an exact int64 sum while all values are integers,
+// switching to float64 on the first float or on overflow.
+type sum struct {
+	Int     int64
+	Float   float64
+	IsFloat bool
+	Empty   bool
+}
+
+func newSum() Aggregator {
+	return &sum{Empty: true}
+}
+
+func (s *sum) Add(args []any) error {
+	if args[0] == nil {
+		return nil
+	}
+
+	switch v := args[0].(type) {
+	case int64:
+		s.addInt(v)
+	default:
+		f, err := toFloat64("sum", args[0])
+		if err != nil {
+			return err
+		}
+		s.addFloat(f)
+	}
+
+	s.Empty = false
+	return nil
+}
+
+func (s *sum) addInt(v int64) {
+	if s.IsFloat {
+		s.Float += float64(v)
+		return
+	}
+
+	if (v > 0 && s.Int > math.MaxInt64-v) || (v < 0 && s.Int < math.MinInt64-v) {
+		s.addFloat(float64(v))
+		return
+	}
+
+	s.Int += v
+}
+
+func (s *sum) addFloat(v float64) {
+	if !s.IsFloat {
+		s.IsFloat = true
+		s.Float = float64(s.Int)
+		s.Int = 0
+	}
+
+	s.Float += v
+}
+
+func (s *sum) Merge(other Aggregator) error {
+	o, err := castForMerge[*sum](s, other)
+	if err != nil {
+		return err
+	}
+
+	if o.IsFloat {
+		s.addFloat(o.Float)
+	} else {
+		s.addInt(o.Int)
+	}
+
+	s.Empty = s.Empty && o.Empty
+	return nil
+}
+
+func (s *sum) Result() any {
+	if s.Empty {
+		return nil
+	}
+
+	if s.IsFloat {
+		return s.Float
+	}
+
+	return s.Int
+}
+
+type avg struct {
+	Sum   float64
+	Count int64
+}
+
+func newAvg() Aggregator {
+	return &avg{}
+}
+
+func (a *avg) Add(args []any) error {
+	if args[0] == nil {
+		return nil
+	}
+
+	f, err := toFloat64("avg", args[0])
+	if err != nil {
+		return err
+	}
+
+	a.Sum += f
+	a.Count++
+	return nil
+}
+
+func (a *avg) Merge(other Aggregator) error {
+	o, err := castForMerge[*avg](a, other)
+	if err != nil {
+		return err
+	}
+
+	a.Sum += o.Sum
+	a.Count += o.Count
+	return nil
+}
+
+func (a *avg) Result() any {
+	if a.Count == 0 {
+		return nil
+	}
+
+	return a.Sum / float64(a.Count)
+}
+
+// extreme implements both `min` and `max`, it accepts
+// either numbers or strings, but not both on the same group.
+type extreme struct {
+	Value Value
+	IsMax bool
+}
+
+func newMin() Aggregator {
+	return &extreme{}
+}
+
+func newMax() Aggregator {
+	return &extreme{IsMax: true}
+}
+
+func (e *extreme) Add(args []any) error {
+	if args[0] == nil {
+		return nil
+	}
+
+	if e.Value.V == nil {
+		_, err := compareValues(e.name(), args[0], args[0])
+		if err != nil {
+			return err
+		}
+
+		e.Value.V = args[0]
+		return nil
+	}
+
+	c, err := compareValues(e.name(), args[0], e.Value.V)
+	if err != nil {
+		return err
+	}
+
+	if (e.IsMax && c > 0) || (!e.IsMax && c < 0) {
+		e.Value.V = args[0]
+	}
+
+	return nil
+}
+
+func (e *extreme) name() string {
+	if e.IsMax {
+		return "max"
+	}
+	return "min"
+}
+
+func (e *extreme) Merge(other Aggregator) error {
+	o, err := castForMerge[*extreme](e, other)
+	if err != nil {
+		return err
+	}
+
+	return e.Add([]any{o.Value.V})
+}
+
+func (e *extreme) Result() any {
+	return e.Value.V
+}
+
+// first and last return the first and last non-null values
+// of the group, when merging partial states the receiver is
+// assumed to contain the records that came first.
+type first struct {
+	Value Value
+}
+
+func newFirst() Aggregator {
+	return &first{}
+}
+
+func (f *first) Add(args []any) error {
+	if f.Value.V == nil {
+		f.Value.V = args[0]
+	}
+
+	return nil
+}
+
+func (f *first) Merge(other Aggregator) error {
+	o, err := castForMerge[*first](f, other)
+	if err != nil {
+		return err
+	}
+
+	return f.Add([]any{o.Value.V})
+}
+
+func (f *first) Result() any {
+	return f.Value.V
+}
+
+type last struct {
+	Value Value
+}
+
+func newLast() Aggregator {
+	return &last{}
+}
+
+func (l *last) Add(args []any) error {
+	if args[0] != nil {
+		l.Value.V = args[0]
+	}
+
+	return nil
+}
+
+func (l *last) Merge(other Aggregator) error {
+	o, err := castForMerge[*last](l, other)
+	if err != nil {
+		return err
+	}
+
+	return l.Add([]any{o.Value.V})
+}
+
+func (l *last) Result() any {
+	return l.Value.V
+}
+
+// stddev computes the sample standard deviation using
+// Welford's online algorithm, which is numerically stable
+// and can be merged as described by Chan et al.
+type stddev struct {
+	Count int64
+	Mean  float64
+	M2    float64
+}
+
+func newStddev() Aggregator {
+	return &stddev{}
+}
+
+func (s *stddev) Add(args []any) error {
+	if args[0] == nil {
+		return nil
+	}
+
+	x, err := toFloat64("stddev", args[0])
+	if err != nil {
+		return err
+	}
+
+	s.Count++
+	delta := x - s.Mean
+	s.Mean += delta / float64(s.Count)
+	s.M2 += delta * (x - s.Mean)
+	return nil
+}
+
+func (s *stddev) Merge(other Aggregator) error {
+	o, err := castForMerge[*stddev](s, other)
+	if err != nil {
+		return err
+	}
+
+	if o.Count == 0 {
+		return nil
+	}
+
+	n1, n2 := float64(s.Count), float64(o.Count)
+	delta := o.Mean - s.Mean
+
+	s.Count += o.Count
+	s.Mean += delta * n2 / (n1 + n2)
+	s.M2 += o.M2 + delta*delta*n1*n2/(n1+n2)
+	return nil
+}
+
+// Result returns nil for groups with less than two values
+// since the sample standard deviation is undefined for them
+func (s *stddev) Result() any {
+	if s.Count < 2 {
+		return nil
+	}
+
+	return math.Sqrt(s.M2 / float64(s.Count-1))
+}
+
+// Value holds the values kept on the partial states making sure
+// they are decoded from JSON with the same types produced by the
+// evaluator, i.e. int64, *big.Int or float64 for numbers.
+type Value struct {
+	V any
+}
+
+func (v Value) MarshalJSON() ([]byte, error) {
+	f, isFloat := v.V.(float64)
+	if !isFloat {
+		return json.Marshal(v.V)
+	}
+
+	b, err := json.Marshal(f)
+	if err != nil {
+		return nil, err
+	}
+
+	// Integral floats need a decimal point so they are
+	// not decoded back as integers, e.g. `2` => `2.0`:
+	if !bytes.ContainsAny(b, ".eE") {
+		b = append(b, ".0"...)
+	}
+
+	return b, nil
+}
+
+func (v *Value) UnmarshalJSON(b []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+
+	err := decoder.Decode(&v.V)
+	if err != nil {
+		return err
+	}
+
+	v.V = internal.ConvertNumbersKeepingFloats(v.V)
+	return nil
+}
+
+// * * * * * Helper functions: * * * * * //
+
+func castForMerge[T Aggregator](receiver T, other Aggregator) (T, error) {
+	o, ok := other.(T)
+	if !ok {
+		return o, insights.InternalErr("cannot merge aggregators of different types", map[string]any{
+			"receiver": reflect.TypeOf(receiver).String(),
+			"other":    reflect.TypeOf(other).String(),
+		})
+	}
+
+	return o, nil
+}
+
+func toFloat64(fnName string, value any) (float64, error) {
+	f, isNumber := internal.ToFloat64(value)
+	if !isNumber {
+		return 0, insights.RuntimeErr("aggregation function expects numeric values", map[string]any{
+			"function": fnName,
+			"value":    value,
+		})
+	}
+
+	return f, nil
+}
+
+// compareValues returns a negative number if v1 < v2, zero if
+// they are equal and a positive number otherwise, it only accepts
+// pairs of numbers or pairs of strings.
+func compareValues(fnName string, v1 any, v2 any) (int, error) {
+	s1, isStr1 := v1.(string)
+	s2, isStr2 := v2.(string)
+	if isStr1 && isStr2 {
+		return strings.Compare(s1, s2), nil
+	}
+
+	if isStr1 || isStr2 {
+		return 0, insights.RuntimeErr("aggregation function can't compare strings with other types", map[string]any{
+			"function": fnName,
+			"values":   []any{v1, v2},
+		})
+	}
+
+	c, ok := internal.CompareNumbers(v1, v2)
+	if !ok {
+		return 0, insights.RuntimeErr("aggregation function expects numbers or strings", map[string]any{
+			"function": fnName,
+			"values":   []any{v1, v2},
+		})
+	}
+
+	return c, nil
+}
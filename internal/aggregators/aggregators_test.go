@@ -0,0 +1,401 @@
+package aggregators
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"testing"
+
+	tt "github.com/vingarcia/insights/internal/testtools"
+)
+
+func TestAggregators(t *testing.T) {
+	tests := []struct {
+		desc               string
+		fn                 string
+		values             []any
+		expectedResult     any
+		expectErrToContain []string
+	}{
+		{
+			desc:           "count should count all non null values",
+			fn:             "count",
+			values:         []any{int64(1), nil, "foo", false},
+			expectedResult: int64(3),
+		},
+		{
+			desc:           "sum should keep integer results as int64",
+			fn:             "sum",
+			values:         []any{int64(1), nil, int64(2), int64(3)},
+			expectedResult: int64(6),
+		},
+		{
+			desc:           "sum should switch to float64 when receiving floats",
+			fn:             "sum",
+			values:         []any{int64(1), 0.5, int64(2)},
+			expectedResult: 3.5,
+		},
+		{
+			desc:           "sum should switch to float64 on integer overflow",
+			fn:             "sum",
+			values:         []any{int64(math.MaxInt64), int64(math.MaxInt64)},
+			expectedResult: 2 * float64(math.MaxInt64),
+		},
+		{
+			desc:           "sum should return null when all values are null",
+			fn:             "sum",
+			values:         []any{nil, nil},
+			expectedResult: nil,
+		},
+		{
+			desc:               "sum should reject non numeric values",
+			fn:                 "sum",
+			values:             []any{int64(1), "foo"},
+			expectErrToContain: []string{"expects numeric values", "sum", "foo"},
+		},
+		{
+			desc:           "avg should ignore null values",
+			fn:             "avg",
+			values:         []any{int64(1), nil, int64(2)},
+			expectedResult: 1.5,
+		},
+		{
+			desc:           "avg should return null for empty groups",
+			fn:             "avg",
+			values:         []any{},
+			expectedResult: nil,
+		},
+		{
+			desc:           "min should compare mixed number types",
+			fn:             "min",
+			values:         []any{int64(3), nil, 2.5, big.NewInt(7)},
+			expectedResult: 2.5,
+		},
+		{
+			desc:           "max should compare mixed number types",
+			fn:             "max",
+			values:         []any{int64(3), nil, 2.5, new(big.Int).Lsh(big.NewInt(1), 70)},
+			expectedResult: new(big.Int).Lsh(big.NewInt(1), 70),
+		},
+		{
+			desc:           "max should compare strings",
+			fn:             "max",
+			values:         []any{"b", "c", "a"},
+			expectedResult: "c",
+		},
+		{
+			desc:               "min should reject comparing strings with numbers",
+			fn:                 "min",
+			values:             []any{"a", int64(1)},
+			expectErrToContain: []string{"can't compare strings", "min"},
+		},
+		{
+			desc:               "max should reject values that are not numbers or strings",
+			fn:                 "max",
+			values:             []any{true},
+			expectErrToContain: []string{"expects numbers or strings", "max"},
+		},
+		{
+			desc:           "first should return the first non null value",
+			fn:             "first",
+			values:         []any{nil, "a", "b", nil},
+			expectedResult: "a",
+		},
+		{
+			desc:           "last should return the last non null value",
+			fn:             "last",
+			values:         []any{nil, "a", "b", nil},
+			expectedResult: "b",
+		},
+		{
+			desc:           "stddev should compute the sample standard deviation",
+			fn:             "stddev",
+			values:         []any{int64(2), int64(4), nil, int64(4), int64(4), int64(5), int64(5), int64(7), int64(9)},
+			expectedResult: math.Sqrt(32.0 / 7),
+		},
+		{
+			desc:           "stddev should return null for less than two values",
+			fn:             "stddev",
+			values:         []any{int64(2), nil},
+			expectedResult: nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			aggregator, err := New(test.fn, 1)
+			tt.AssertNoErr(t, err)
+
+			for _, v := range test.values {
+				err = aggregator.Add([]any{v})
+				if err != nil {
+					break
+				}
+			}
+			if test.expectErrToContain != nil {
+				tt.AssertErrContains(t, err, test.expectErrToContain...)
+				return
+			}
+			tt.AssertNoErr(t, err)
+
+			assertResult(t, aggregator.Result(), test.expectedResult)
+		})
+	}
+}
+
+func TestMerge(t *testing.T) {
+	values := []any{int64(5), nil, 1.5, 2.0, int64(-3), int64(8), nil, int64(2), 4.25, int64(7)}
+
+	for fn, s := range registry {
+		t.Run(fn, func(t *testing.T) {
+			// The second argument is either the percentile of
+			// `percentile(expr, q)` or the k of `top_k(expr, k)`:
+			numArgs := max(s.minArgs, 1)
+			args := func(v any) []any {
+				return []any{v, int64(90)}[:numArgs]
+			}
+
+			expected, err := New(fn, numArgs)
+			tt.AssertNoErr(t, err)
+			for _, v := range values {
+				tt.AssertNoErr(t, expected.Add(args(v)))
+			}
+
+			// Every split point should produce the same result,
+			// including merging empty partial states:
+			for split := 0; split <= len(values); split++ {
+				left, _ := New(fn, numArgs)
+				for _, v := range values[:split] {
+					tt.AssertNoErr(t, left.Add(args(v)))
+				}
+
+				right, _ := New(fn, numArgs)
+				for _, v := range values[split:] {
+					tt.AssertNoErr(t, right.Add(args(v)))
+				}
+
+				// The partial states should survive a JSON round trip:
+				rawState, err := json.Marshal(right)
+				tt.AssertNoErr(t, err)
+				decoded, _ := New(fn, numArgs)
+				tt.AssertNoErr(t, json.Unmarshal(rawState, decoded))
+
+				tt.AssertNoErr(t, left.Merge(decoded))
+				assertResult(t, left.Result(), expected.Result())
+			}
+		})
+	}
+
+	t.Run("should reject merging different aggregators", func(t *testing.T) {
+		sum, _ := New("sum", 1)
+		count, _ := New("count", 1)
+		tt.AssertErrContains(t, sum.Merge(count), "cannot merge aggregators of different types")
+	})
+}
+
+func TestPercentiles(t *testing.T) {
+	t.Run("should respect the relative accuracy of the sketch", func(t *testing.T) {
+		// Values spanning several orders of magnitude including negatives:
+		values := []float64{}
+		for i := 1; i <= 10000; i++ {
+			values = append(values, math.Pow(1.001, float64(i))-3)
+		}
+
+		for _, fn := range []string{"p50", "p95", "p99"} {
+			aggregator, err := New(fn, 1)
+			tt.AssertNoErr(t, err)
+			for _, v := range values {
+				tt.AssertNoErr(t, aggregator.Add([]any{v}))
+			}
+
+			q := map[string]float64{"p50": 0.50, "p95": 0.95, "p99": 0.99}[fn]
+			exact := values[int(q*float64(len(values)-1))]
+
+			result := aggregator.Result().(float64)
+			if math.Abs(result-exact) > relativeAccuracy*math.Abs(exact) {
+				t.Fatalf("%s: expected %v to be within 1%% of %v", fn, result, exact)
+			}
+		}
+	})
+
+	t.Run("should return exact values for the extremes", func(t *testing.T) {
+		for q, expected := range map[int64]any{0: int64(-7), 100: int64(1234)} {
+			aggregator, _ := New("percentile", 2)
+			for _, v := range []any{int64(3), nil, int64(-7), int64(0), int64(1234)} {
+				tt.AssertNoErr(t, aggregator.Add([]any{v, q}))
+			}
+			assertResult(t, aggregator.Result(), float64(expected.(int64)))
+		}
+	})
+
+	t.Run("should return null for empty groups", func(t *testing.T) {
+		aggregator, _ := New("p99", 1)
+		tt.AssertNoErr(t, aggregator.Add([]any{nil}))
+		tt.AssertEqual(t, aggregator.Result(), nil)
+	})
+
+	t.Run("should reject invalid percentiles", func(t *testing.T) {
+		aggregator, _ := New("percentile", 2)
+		tt.AssertErrContains(t, aggregator.Add([]any{int64(1), int64(101)}), "between 0 and 100")
+		tt.AssertErrContains(t, aggregator.Add([]any{int64(1), "50"}), "between 0 and 100")
+
+		tt.AssertNoErr(t, aggregator.Add([]any{int64(1), int64(50)}))
+		tt.AssertErrContains(t, aggregator.Add([]any{int64(1), 90.0}), "same percentile for all records")
+	})
+
+	t.Run("should ignore non finite values", func(t *testing.T) {
+		aggregator, _ := New("p50", 1)
+		tt.AssertNoErr(t, aggregator.Add([]any{1.5}))
+		for _, v := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+			tt.AssertNoErr(t, aggregator.Add([]any{v}))
+		}
+
+		// The sketch should not be affected by the ignored values:
+		tt.AssertEqual(t, aggregator.Result(), 1.5)
+
+		// And it should still be encoded as JSON:
+		_, err := json.Marshal(aggregator)
+		tt.AssertNoErr(t, err)
+	})
+
+	t.Run("should reject merging different percentiles", func(t *testing.T) {
+		p50, _ := New("p50", 1)
+		p99, _ := New("p99", 1)
+		tt.AssertErrContains(t, p50.Merge(p99), "same percentile for all records")
+	})
+}
+
+func TestCountDistinct(t *testing.T) {
+	t.Run("should count small groups exactly", func(t *testing.T) {
+		aggregator, _ := New("count_distinct", 1)
+		for _, v := range []any{"a", "b", nil, "a", int64(1), "1", int64(1)} {
+			tt.AssertNoErr(t, aggregator.Add([]any{v}))
+		}
+
+		tt.AssertEqual(t, aggregator.Result(), map[string]any{
+			"estimate": int64(4),
+			"error":    int64(0),
+		})
+	})
+
+	t.Run("should estimate large groups within the error bounds", func(t *testing.T) {
+		for _, n := range []int64{2000, 50000, 300000} {
+			left, _ := New("count_distinct", 1)
+			right, _ := New("count_distinct", 1)
+
+			// Half of the values of the right partition are also on the left one:
+			for i := int64(0); i < n; i++ {
+				tt.AssertNoErr(t, left.Add([]any{fmt.Sprint("user-", i)}))
+				if i >= n/2 {
+					tt.AssertNoErr(t, right.Add([]any{fmt.Sprint("user-", i+n/4)}))
+				}
+			}
+			tt.AssertNoErr(t, left.Merge(right))
+
+			exact := n + n/4
+			result := left.Result().(map[string]any)
+			estimate, bound := result["estimate"].(int64), result["error"].(int64)
+			if estimate < exact-bound || estimate > exact+bound {
+				t.Fatalf("expected %d to be within %d of %d", estimate, bound, exact)
+			}
+		}
+	})
+}
+
+func TestTopK(t *testing.T) {
+	t.Run("should find the most frequent values within the error bounds", func(t *testing.T) {
+		left, _ := New("top_k", 2)
+		right, _ := New("top_k", 2)
+
+		// Value i appears 1000/i times plus a long tail of unique values:
+		exact := map[any]int64{}
+		for i := int64(1); i <= 50; i++ {
+			for j := int64(0); j < 1000/i; j++ {
+				aggregator := left
+				if j%2 == 0 {
+					aggregator = right
+				}
+				tt.AssertNoErr(t, aggregator.Add([]any{i, int64(3)}))
+				tt.AssertNoErr(t, aggregator.Add([]any{fmt.Sprint("unique-", i, "-", j), int64(3)}))
+				exact[i]++
+			}
+		}
+		tt.AssertNoErr(t, left.Merge(right))
+
+		result := left.Result().([]any)
+		tt.AssertEqual(t, len(result), 3)
+		for i, item := range result {
+			counter := item.(map[string]any)
+			tt.AssertEqual(t, counter["value"], int64(i+1))
+
+			count, bound := counter["count"].(int64), counter["error"].(int64)
+			if count < exact[int64(i+1)] || count-bound > exact[int64(i+1)] {
+				t.Fatalf("expected %d with error %d to contain %d", count, bound, exact[int64(i+1)])
+			}
+		}
+	})
+
+	t.Run("should replace the least frequent value when all counters are in use", func(t *testing.T) {
+		aggregator, _ := New("top_k", 2)
+
+		// k = 1 keeps 10 counters, value i is counted i+1 times:
+		for i := 0; i < 10; i++ {
+			for j := 0; j <= i; j++ {
+				tt.AssertNoErr(t, aggregator.Add([]any{fmt.Sprint("v", i), int64(1)}))
+			}
+		}
+
+		// The counters should still be tracked after a JSON round trip:
+		rawState, err := json.Marshal(aggregator)
+		tt.AssertNoErr(t, err)
+		decoded, _ := New("top_k", 2)
+		tt.AssertNoErr(t, json.Unmarshal(rawState, decoded))
+
+		// "new" replaces "v0", the only value counted once:
+		for i := 0; i < 10; i++ {
+			tt.AssertNoErr(t, decoded.Add([]any{"new", int64(1)}))
+		}
+
+		tt.AssertEqual(t, decoded.Result(), []any{
+			map[string]any{"value": "new", "count": int64(11), "error": int64(1)},
+		})
+	})
+
+	t.Run("should reject invalid values for k", func(t *testing.T) {
+		aggregator, _ := New("top_k", 2)
+		tt.AssertErrContains(t, aggregator.Add([]any{"a", int64(0)}), "between 1 and 1000")
+		tt.AssertErrContains(t, aggregator.Add([]any{"a", 2.5}), "between 1 and 1000")
+
+		tt.AssertNoErr(t, aggregator.Add([]any{"a", int64(5)}))
+		tt.AssertErrContains(t, aggregator.Add([]any{"a", int64(10)}), "same k for all records")
+	})
+}
+
+func TestNew(t *testing.T) {
+	_, err := New("count", 0)
+	tt.AssertNoErr(t, err)
+
+	_, err = New("avg", 2)
+	tt.AssertErrContains(t, err, "wrong number of arguments", "avg")
+
+	_, err = New("foo", 1)
+	tt.AssertErrContains(t, err, "unknown aggregation function", "foo")
+}
+
+// assertResult compares floats with a tolerance since
+// merging partial states might change rounding errors
+func assertResult(t *testing.T, result any, expected any) {
+	t.Helper()
+
+	f1, isFloat1 := result.(float64)
+	f2, isFloat2 := expected.(float64)
+	if isFloat1 && isFloat2 {
+		if math.Abs(f1-f2) > 1e-9*math.Max(1, math.Abs(f2)) {
+			t.Fatalf("expected %v to be approximately %v", f1, f2)
+		}
+		return
+	}
+
+	tt.AssertEqual(t, result, expected)
+}
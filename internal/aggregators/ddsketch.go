@@ -0,0 +1,252 @@
+package aggregators
+
+import (
+	"math"
+	"sort"
+
+	"github.com/vingarcia/insights"
+)
+
+// relativeAccuracy is the maximum relative error of the
+// quantiles estimated by the sketch, i.e. 1%
+const relativeAccuracy = 0.01
+
+// ddsketch is a mergeable quantile sketch as described on the
+// DDSketch paper (Masson et al, 2019): values are counted on
+// exponentially sized bins so any quantile can be estimated with
+// a bounded relative error regardless of the number of values.
+//
+// The number of bins grows with the logarithm of the range of
+// the values instead of their count, so for instance all values
+// between 1 nanosecond and 1 hour fit in less than 1500 bins.
+type ddsketch struct {
+	Positive map[int]int64
+	Negative map[int]int64
+	Zeros    int64
+	Count    int64
+	Min      float64
+	Max      float64
+}
+
+var gamma = (1 + relativeAccuracy) / (1 - relativeAccuracy)
+var logGamma = math.Log(gamma)
+
+func (d *ddsketch) add(x float64) {
+	switch {
+	case x > 0:
+		if d.Positive == nil {
+			d.Positive = map[int]int64{}
+		}
+		d.Positive[binIndex(x)]++
+	case x < 0:
+		if d.Negative == nil {
+			d.Negative = map[int]int64{}
+		}
+		d.Negative[binIndex(-x)]++
+	default:
+		d.Zeros++
+	}
+
+	if d.Count == 0 || x < d.Min {
+		d.Min = x
+	}
+	if d.Count == 0 || x > d.Max {
+		d.Max = x
+	}
+	d.Count++
+}
+
+func (d *ddsketch) merge(other ddsketch) {
+	if other.Count == 0 {
+		return
+	}
+
+	for idx, n := range other.Positive {
+		if d.Positive == nil {
+			d.Positive = map[int]int64{}
+		}
+		d.Positive[idx] += n
+	}
+	for idx, n := range other.Negative {
+		if d.Negative == nil {
+			d.Negative = map[int]int64{}
+		}
+		d.Negative[idx] += n
+	}
+	d.Zeros += other.Zeros
+
+	if d.Count == 0 || other.Min < d.Min {
+		d.Min = other.Min
+	}
+	if d.Count == 0 || other.Max > d.Max {
+		d.Max = other.Max
+	}
+	d.Count += other.Count
+}
+
+// quantile returns the estimated value for the quantile q,
+// which must be between 0 and 1, or nil if the sketch is empty
+func (d *ddsketch) quantile(q float64) any {
+	if d.Count == 0 {
+		return nil
+	}
+
+	// The extremes are tracked separately so they are always exact:
+	if q <= 0 {
+		return d.Min
+	}
+	if q >= 1 {
+		return d.Max
+	}
+
+	rank := q * float64(d.Count-1)
+
+	// Negative values are visited from the most negative,
+	// i.e. from the highest index to the lowest one:
+	var seen int64
+	for _, idx := range sortedIndexes(d.Negative, true) {
+		seen += d.Negative[idx]
+		if float64(seen) > rank {
+			return d.clamp(-binValue(idx))
+		}
+	}
+
+	seen += d.Zeros
+	if float64(seen) > rank {
+		return 0.0
+	}
+
+	for _, idx := range sortedIndexes(d.Positive, false) {
+		seen += d.Positive[idx]
+		if float64(seen) > rank {
+			return d.clamp(binValue(idx))
+		}
+	}
+
+	return d.Max
+}
+
+// clamp keeps the estimations inside the range of the values
+func (d *ddsketch) clamp(x float64) float64 {
+	return math.Max(d.Min, math.Min(d.Max, x))
+}
+
+// binIndex returns the index of the bin (gamma^(i-1), gamma^i]
+// that contains x, which must be positive
+func binIndex(x float64) int {
+	return int(math.Ceil(math.Log(x) / logGamma))
+}
+
+// binValue returns the value with the smallest relative
+// distance to all values inside the bin with index idx
+func binValue(idx int) float64 {
+	return 2 * math.Pow(gamma, float64(idx)) / (gamma + 1)
+}
+
+func sortedIndexes(bins map[int]int64, desc bool) []int {
+	indexes := make([]int, 0, len(bins))
+	for idx := range bins {
+		indexes = append(indexes, idx)
+	}
+
+	if desc {
+		sort.Sort(sort.Reverse(sort.IntSlice(indexes)))
+	} else {
+		sort.Ints(indexes)
+	}
+
+	return indexes
+}
+
+// percentile estimates a fixed percentile of its first
+// argument, for `percentile(expr, q)` the percentile is
+// given by the second argument, as a number from 0 to 100.
+type percentile struct {
+	Sketch ddsketch
+
+	// Percentile is nil until it is read from the arguments
+	// when used by the `percentile(expr, q)` function
+	Percentile *float64
+}
+
+func newPercentileFactory(p float64) Factory {
+	return func() Aggregator {
+		return &percentile{Percentile: &p}
+	}
+}
+
+func newPercentile() Aggregator {
+	return &percentile{}
+}
+
+func (p *percentile) Add(args []any) error {
+	if len(args) > 1 {
+		err := p.setPercentile(args[1])
+		if err != nil {
+			return err
+		}
+	}
+
+	if args[0] == nil {
+		return nil
+	}
+
+	x, err := toFloat64("percentile", args[0])
+	if err != nil {
+		return err
+	}
+
+	// Non finite values have no bin, and would also prevent the
+	// sketch from being encoded as JSON, so they are ignored just
+	// like nulls:
+	if math.IsNaN(x) || math.IsInf(x, 0) {
+		return nil
+	}
+
+	p.Sketch.add(x)
+	return nil
+}
+
+func (p *percentile) setPercentile(arg any) error {
+	q, err := toFloat64("percentile", arg)
+	if err != nil || q < 0 || q > 100 {
+		return insights.RuntimeErr("percentile expects a number between 0 and 100 as its second argument", map[string]any{
+			"received": arg,
+		})
+	}
+
+	if p.Percentile != nil && *p.Percentile != q {
+		return insights.RuntimeErr("percentile expects the same percentile for all records", map[string]any{
+			"previous": *p.Percentile,
+			"received": q,
+		})
+	}
+
+	p.Percentile = &q
+	return nil
+}
+
+func (p *percentile) Merge(other Aggregator) error {
+	o, err := castForMerge[*percentile](p, other)
+	if err != nil {
+		return err
+	}
+
+	if o.Percentile != nil {
+		err := p.setPercentile(*o.Percentile)
+		if err != nil {
+			return err
+		}
+	}
+
+	p.Sketch.merge(o.Sketch)
+	return nil
+}
+
+func (p *percentile) Result() any {
+	if p.Percentile == nil {
+		return nil
+	}
+
+	return p.Sketch.quantile(*p.Percentile / 100)
+}
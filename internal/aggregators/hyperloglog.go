@@ -0,0 +1,174 @@
+package aggregators
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"math"
+	"math/bits"
+
+	"github.com/vingarcia/insights"
+)
+
+const (
+	// hllPrecision is the number of bits of the hash used for
+	// selecting the register, i.e. there are 2^14 registers and
+	// the relative standard error is 1.04/sqrt(2^14) ~= 0.81%
+	hllPrecision = 14
+	hllRegisters = 1 << hllPrecision
+
+	// hllExactLimit is the number of distinct hashes kept before
+	// switching to the registers, so that small groups are counted
+	// exactly and use much less memory than the 16KB of registers
+	hllExactLimit = 1024
+)
+
+// countDistinct estimates the number of distinct values of its
+// argument using the HyperLogLog algorithm (Flajolet et al, 2007).
+//
+// The result is a map with the estimated count and its error,
+// which is the half-width of a ~95% confidence interval, e.g.
+// `{"estimate": 1000, "error": 17}` means 983 to 1017.
+type countDistinct struct {
+	// Exact keeps the hashes of the values while there are
+	// only a few of them, it is nil once Registers is used
+	Exact map[uint64]struct{}
+
+	Registers []byte
+}
+
+func newCountDistinct() Aggregator {
+	return &countDistinct{}
+}
+
+func (c *countDistinct) Add(args []any) error {
+	if args[0] == nil {
+		return nil
+	}
+
+	h, err := hashValue("count_distinct", args[0])
+	if err != nil {
+		return err
+	}
+
+	c.addHash(h)
+	return nil
+}
+
+func (c *countDistinct) addHash(h uint64) {
+	if c.Registers != nil {
+		c.setRegister(h)
+		return
+	}
+
+	if c.Exact == nil {
+		c.Exact = map[uint64]struct{}{}
+	}
+	c.Exact[h] = struct{}{}
+
+	if len(c.Exact) > hllExactLimit {
+		c.switchToRegisters()
+	}
+}
+
+func (c *countDistinct) setRegister(h uint64) {
+	idx := h >> (64 - hllPrecision)
+
+	// The position of the leftmost 1 bit on the remaining bits,
+	// the sentinel bit caps the value at 64 - hllPrecision + 1:
+	rank := byte(bits.LeadingZeros64(h<<hllPrecision|1<<(hllPrecision-1)) + 1)
+	if rank > c.Registers[idx] {
+		c.Registers[idx] = rank
+	}
+}
+
+func (c *countDistinct) switchToRegisters() {
+	c.Registers = make([]byte, hllRegisters)
+	for h := range c.Exact {
+		c.setRegister(h)
+	}
+	c.Exact = nil
+}
+
+func (c *countDistinct) Merge(other Aggregator) error {
+	o, err := castForMerge[*countDistinct](c, other)
+	if err != nil {
+		return err
+	}
+
+	if o.Registers != nil && c.Registers == nil {
+		c.switchToRegisters()
+	}
+
+	for h := range o.Exact {
+		c.addHash(h)
+	}
+
+	for idx, rank := range o.Registers {
+		if rank > c.Registers[idx] {
+			c.Registers[idx] = rank
+		}
+	}
+
+	return nil
+}
+
+func (c *countDistinct) Result() any {
+	if c.Registers == nil {
+		return map[string]any{
+			"estimate": int64(len(c.Exact)),
+			"error":    int64(0),
+		}
+	}
+
+	m := float64(hllRegisters)
+	alpha := 0.7213 / (1 + 1.079/m)
+
+	sum := 0.0
+	zeros := 0
+	for _, rank := range c.Registers {
+		sum += math.Ldexp(1, -int(rank))
+		if rank == 0 {
+			zeros++
+		}
+	}
+
+	estimate := alpha * m * m / sum
+
+	// Linear counting is more accurate for small cardinalities:
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+
+	stdError := 1.04 / math.Sqrt(m)
+	return map[string]any{
+		"estimate": int64(math.Round(estimate)),
+		"error":    int64(math.Ceil(2 * stdError * estimate)),
+	}
+}
+
+// hashValue hashes the JSON representation of the value, so
+// the same value has the same hash on different processes,
+// which is required for merging partial states.
+func hashValue(fnName string, value any) (uint64, error) {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return 0, insights.RuntimeErr("unable to hash value", map[string]any{
+			"function": fnName,
+			"value":    value,
+			"error":    err,
+		})
+	}
+
+	h := fnv.New64a()
+	h.Write(b)
+
+	// FNV doesn't spread similar inputs well enough on the high
+	// bits, so we finalize it with the MurmurHash3 mixer:
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x, nil
+}
@@ -0,0 +1,270 @@
+package aggregators
+
+import (
+	"container/heap"
+	"encoding/json"
+	"sort"
+
+	"github.com/vingarcia/insights"
+)
+
+const (
+	// topKCapacityFactor is the number of counters kept for each
+	// of the k requested values, more counters reduce the error
+	topKCapacityFactor = 10
+
+	maxTopK = 1000
+)
+
+// topK finds the most frequent values of its first argument
+// using the Space-Saving algorithm (Metwally et al, 2005), the
+// number of values is given by the second argument, e.g.
+// `top_k(path, 10)`.
+//
+// The result is a list of maps sorted by count, each count may
+// be overestimated by at most its error, e.g. for
+// `{"value": "/users", "count": 120, "error": 3}` the exact
+// count is between 117 and 120.
+type topK struct {
+	K        int
+	Counters map[string]*topKCounter
+
+	// minHeap indexes the counters so the least frequent one
+	// can be replaced without scanning all of them
+	minHeap topKHeap
+}
+
+type topKCounter struct {
+	Value Value
+	Count int64
+	Error int64
+
+	key   string
+	index int
+}
+
+func newTopK() Aggregator {
+	return &topK{}
+}
+
+func (t *topK) Add(args []any) error {
+	err := t.setK(args[1])
+	if err != nil {
+		return err
+	}
+
+	if args[0] == nil {
+		return nil
+	}
+
+	key, err := json.Marshal(args[0])
+	if err != nil {
+		return insights.RuntimeErr("unable to encode value", map[string]any{
+			"function": "top_k",
+			"value":    args[0],
+			"error":    err,
+		})
+	}
+
+	if t.Counters == nil {
+		t.Counters = map[string]*topKCounter{}
+	}
+	t.syncHeap()
+
+	counter, found := t.Counters[string(key)]
+	if found {
+		counter.Count++
+		heap.Fix(&t.minHeap, counter.index)
+		return nil
+	}
+
+	if len(t.Counters) < t.capacity() {
+		counter := &topKCounter{
+			Value: Value{V: args[0]},
+			Count: 1,
+			key:   string(key),
+		}
+		t.Counters[counter.key] = counter
+		heap.Push(&t.minHeap, counter)
+		return nil
+	}
+
+	// When all counters are in use the least frequent value is
+	// replaced and its count becomes the error of the new value:
+	counter = t.minHeap[0]
+	delete(t.Counters, counter.key)
+	minCount := counter.Count
+	*counter = topKCounter{
+		Value: Value{V: args[0]},
+		Count: minCount + 1,
+		Error: minCount,
+		key:   string(key),
+	}
+	t.Counters[counter.key] = counter
+	heap.Fix(&t.minHeap, 0)
+
+	return nil
+}
+
+func (t *topK) setK(arg any) error {
+	k, isInt := arg.(int64)
+	if !isInt || k < 1 || k > maxTopK {
+		return insights.RuntimeErr("top_k expects an integer between 1 and 1000 as its second argument", map[string]any{
+			"received": arg,
+		})
+	}
+
+	if t.K != 0 && t.K != int(k) {
+		return insights.RuntimeErr("top_k expects the same k for all records", map[string]any{
+			"previous": t.K,
+			"received": k,
+		})
+	}
+
+	t.K = int(k)
+	return nil
+}
+
+func (t *topK) capacity() int {
+	return t.K * topKCapacityFactor
+}
+
+// syncHeap rebuilds the heap when it doesn't match the
+// counters, e.g. after decoding a partial state from JSON
+func (t *topK) syncHeap() {
+	if len(t.minHeap) == len(t.Counters) {
+		return
+	}
+
+	t.minHeap = make(topKHeap, 0, len(t.Counters))
+	for key, counter := range t.Counters {
+		counter.key = key
+		counter.index = len(t.minHeap)
+		t.minHeap = append(t.minHeap, counter)
+	}
+	heap.Init(&t.minHeap)
+}
+
+// Merge combines the counters as described by Agarwal et al,
+// 2012 on "Mergeable Summaries": values missing from one of the
+// summaries might have been counted up to its minimum count.
+func (t *topK) Merge(other Aggregator) error {
+	o, err := castForMerge[*topK](t, other)
+	if err != nil {
+		return err
+	}
+
+	if o.K == 0 {
+		return nil
+	}
+
+	err = t.setK(int64(o.K))
+	if err != nil {
+		return err
+	}
+
+	tMin, oMin := t.minCountIfFull(), o.minCountIfFull()
+
+	merged := map[string]*topKCounter{}
+	for key, counter := range t.Counters {
+		merged[key] = &topKCounter{
+			Value: counter.Value,
+			Count: counter.Count + oMin,
+			Error: counter.Error + oMin,
+		}
+	}
+	for key, counter := range o.Counters {
+		if m, found := merged[key]; found {
+			m.Count += counter.Count - oMin
+			m.Error += counter.Error - oMin
+			continue
+		}
+
+		merged[key] = &topKCounter{
+			Value: counter.Value,
+			Count: counter.Count + tMin,
+			Error: counter.Error + tMin,
+		}
+	}
+
+	t.Counters = merged
+	t.minHeap = nil
+	t.syncHeap()
+	for len(t.Counters) > t.capacity() {
+		counter := heap.Pop(&t.minHeap).(*topKCounter)
+		delete(t.Counters, counter.key)
+	}
+
+	return nil
+}
+
+func (t *topK) minCountIfFull() int64 {
+	if len(t.Counters) < t.capacity() {
+		return 0
+	}
+
+	t.syncHeap()
+	return t.minHeap[0].Count
+}
+
+func (t *topK) Result() any {
+	keys := make([]string, 0, len(t.Counters))
+	for key := range t.Counters {
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		ci, cj := t.Counters[keys[i]], t.Counters[keys[j]]
+		if ci.Count != cj.Count {
+			return ci.Count > cj.Count
+		}
+		return keys[i] < keys[j]
+	})
+
+	result := []any{}
+	for _, key := range keys[:min(t.K, len(keys))] {
+		counter := t.Counters[key]
+		result = append(result, map[string]any{
+			"value": counter.Value.V,
+			"count": counter.Count,
+			"error": counter.Error,
+		})
+	}
+
+	return result
+}
+
+// topKHeap implements heap.Interface ordering the counters by
+// count and then by key, so ties are always evicted the same way
+type topKHeap []*topKCounter
+
+func (h topKHeap) Len() int {
+	return len(h)
+}
+
+func (h topKHeap) Less(i, j int) bool {
+	if h[i].Count != h[j].Count {
+		return h[i].Count < h[j].Count
+	}
+	return h[i].key < h[j].key
+}
+
+func (h topKHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *topKHeap) Push(x any) {
+	counter := x.(*topKCounter)
+	counter.index = len(*h)
+	*h = append(*h, counter)
+}
+
+func (h *topKHeap) Pop() any {
+	old := *h
+	counter := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return counter
+}
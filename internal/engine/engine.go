@@ -0,0 +1,703 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"sort"
+
+	"github.com/vingarcia/insights"
+	"github.com/vingarcia/insights/internal"
+	"github.com/vingarcia/insights/internal/adapters/evaluator"
+	"github.com/vingarcia/insights/internal/aggregators"
+)
+
+// Engine executes queries against the data sources of a DataSourceRepo
+type Engine struct {
+	repo        internal.DataSourceRepo
+	parseValue  func(expr string) (evaluator.ValueExpression, error)
+	onRecordErr func(err error) error
+}
+
+// New instantiates a new Engine, the parseValue argument is used
+// for compiling the group keys of the queries into expressions.
+func New(
+	repo internal.DataSourceRepo,
+	parseValue func(expr string) (evaluator.ValueExpression, error),
+) Engine {
+	return Engine{
+		repo:       repo,
+		parseValue: parseValue,
+		onRecordErr: func(err error) error {
+			return err
+		},
+	}
+}
+
+// WithRecordErrHandler returns a copy of the engine that calls
+// handler for the errors affecting a single record, e.g. malformed
+// lines. If the handler returns nil the record is skipped, otherwise
+// the query fails with the returned error, which is the default.
+func (e Engine) WithRecordErrHandler(handler func(err error) error) Engine {
+	e.onRecordErr = handler
+	return e
+}
+
+// Run reads all the records of the query's data source and
+// returns the records matching the Where expression, with the
+// columns described by the Select projections, if any.
+//
+// If the query has group keys or aggregations the result will
+// contain one row per group with the key values followed by
+// the aggregated values, in this order.
+//
+// The rows are then filtered by the Having expression, sorted
+// by the OrderBy columns and truncated to the Offset and Limit
+// of the query, if any. When there is a Limit, only the first
+// Offset+Limit rows are sorted, and if there is no OrderBy nor
+// grouping the data source stops being read once they are found.
+//
+// Run fails with ctx.Err() if ctx is canceled before it finishes.
+func (e Engine) Run(ctx context.Context, query internal.Query) (internal.ResultSet, error) {
+	source, err := e.findSource(query.From)
+	if err != nil {
+		return internal.ResultSet{}, err
+	}
+
+	isGrouped := len(query.GroupBy.Keys) > 0 || len(query.GroupBy.Aggregations) > 0
+
+	// The columns of the records are only known after reading all
+	// of them, unless they are all listed on the projections:
+	strictColumns := isGrouped || len(query.Select) > 0
+	for _, projection := range query.Select {
+		if projection.Wildcard {
+			strictColumns = false
+		}
+	}
+
+	// maxRows is the number of rows needed for producing
+	// the result, zero means all rows are needed:
+	maxRows := 0
+	if query.Limit > 0 {
+		maxRows = query.Offset + query.Limit
+	}
+
+	var exec executor
+	if !isGrouped {
+		records, err := newRecordsExecutor(query.Select)
+		if err != nil {
+			return internal.ResultSet{}, err
+		}
+		if query.Having == nil && maxRows > 0 {
+			records.top = newTopN(query.OrderBy, maxRows)
+		}
+		exec = records
+	} else {
+		if len(query.Select) > 0 {
+			return internal.ResultSet{}, insights.RuntimeErr("projections are not supported on queries with GroupBy", map[string]any{
+				"source": query.From,
+			})
+		}
+
+		var err error
+		exec, err = e.newGroupsExecutor(query.GroupBy)
+		if err != nil {
+			return internal.ResultSet{}, err
+		}
+	}
+
+	stopEarly := !isGrouped && query.Having == nil && len(query.OrderBy) == 0 && maxRows > 0
+	numMatches := 0
+	err = e.forEachMatch(ctx, source, query.Where, func(record *evaluator.Record) (stop bool, _ error) {
+		err := exec.Add(record)
+		if err != nil {
+			return false, err
+		}
+
+		numMatches++
+		return stopEarly && numMatches >= maxRows, nil
+	})
+	if err != nil {
+		return internal.ResultSet{}, err
+	}
+
+	result, err := exec.Result()
+	if err != nil {
+		return internal.ResultSet{}, err
+	}
+
+	if query.Having != nil {
+		result, err = filterRows(result, query.Having)
+		if err != nil {
+			return internal.ResultSet{}, err
+		}
+	}
+
+	if maxRows > 0 && len(query.OrderBy) > 0 {
+		result.Rows, err = topRows(result, query.OrderBy, strictColumns, maxRows)
+	} else {
+		err = sortRows(result, query.OrderBy, strictColumns)
+	}
+	if err != nil {
+		return internal.ResultSet{}, err
+	}
+
+	result.Rows = result.Rows[min(query.Offset, len(result.Rows)):]
+	if query.Limit > 0 && len(result.Rows) > query.Limit {
+		result.Rows = result.Rows[:query.Limit]
+	}
+
+	return result, nil
+}
+
+// Stream runs the query continuously, calling emit for each matching
+// row as soon as it is read, which allows running queries against data
+// sources that never end, such as the ones following log files.
+//
+// Each result passed to emit contains a single row and all the columns
+// known so far, so new columns might appear on the later results when
+// the query selects `*`. Streaming stops when the data source ends,
+// Limit rows are emitted, emit returns an error or ctx is canceled,
+// in which case ctx.Err() is returned.
+//
+// Queries with GroupBy or OrderBy are not supported, since their
+// results are only known after all records are read.
+func (e Engine) Stream(ctx context.Context, query internal.Query, emit func(result internal.ResultSet) error) error {
+	if len(query.GroupBy.Keys) > 0 || len(query.GroupBy.Aggregations) > 0 || len(query.OrderBy) > 0 {
+		return insights.RuntimeErr("streaming is not supported for queries with GroupBy or OrderBy", map[string]any{
+			"source": query.From,
+		})
+	}
+
+	source, err := e.findSource(query.From)
+	if err != nil {
+		return err
+	}
+
+	records, err := newRecordsExecutor(query.Select)
+	if err != nil {
+		return err
+	}
+
+	numSkipped, numEmitted := 0, 0
+	return e.forEachMatch(ctx, source, query.Where, func(record *evaluator.Record) (stop bool, _ error) {
+		err := records.Add(record)
+		if err != nil {
+			return false, err
+		}
+
+		result, err := records.Result()
+		if err != nil {
+			return false, err
+		}
+		records.records = nil
+
+		if query.Having != nil {
+			result, err = filterRows(result, query.Having)
+			if err != nil || len(result.Rows) == 0 {
+				return false, err
+			}
+		}
+
+		if numSkipped < query.Offset {
+			numSkipped++
+			return false, nil
+		}
+
+		err = emit(result)
+		if err != nil {
+			return false, err
+		}
+
+		numEmitted++
+		return query.Limit > 0 && numEmitted >= query.Limit, nil
+	})
+}
+
+func (e Engine) findSource(name string) (internal.DataSource, error) {
+	source := e.repo.FindByName(name)
+	if source.Open == nil {
+		return internal.DataSource{}, insights.RuntimeErr("data source not found", map[string]any{
+			"name": name,
+		})
+	}
+
+	return source, nil
+}
+
+// forEachMatch reads the records of the source calling fn for the
+// ones matching the where expression, if any, until fn returns true
+// or there are no more records to read.
+//
+// Each record is passed to fn as an evaluator.Record, so it is only
+// parsed once for all the expressions evaluated against it.
+func (e Engine) forEachMatch(
+	ctx context.Context,
+	source internal.DataSource,
+	where evaluator.Expression,
+	fn func(record *evaluator.Record) (stop bool, _ error),
+) error {
+	iterator, err := source.Open(ctx)
+	if err != nil {
+		return err
+	}
+	defer iterator.Close()
+
+	next := newRecordReader(iterator)
+	for {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		record, err := next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if record.Err != nil {
+			err := e.onRecordErr(record.Err)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		evalRecord := evaluator.NewRecord(record.Raw)
+		if where != nil {
+			match, err := where.EvaluateRecord(evalRecord)
+			if err != nil {
+				return err
+			}
+
+			if !match {
+				continue
+			}
+		}
+
+		stop, err := fn(evalRecord)
+		if err != nil || stop {
+			return err
+		}
+	}
+}
+
+// batchSize is the maximum number of records
+// read at once from iterators supporting batches
+const batchSize = 256
+
+// newRecordReader returns a function reading one record at a time
+// from the iterator, using batches if the iterator supports them
+func newRecordReader(iterator internal.RecordIterator) func() (internal.Record, error) {
+	batchIterator, ok := iterator.(internal.BatchIterator)
+	if !ok {
+		return iterator.Next
+	}
+
+	var batch []internal.Record
+	var batchErr error
+	return func() (internal.Record, error) {
+		for len(batch) == 0 {
+			if batchErr != nil {
+				return internal.Record{}, batchErr
+			}
+			batch, batchErr = batchIterator.NextBatch(batchSize)
+		}
+
+		record := batch[0]
+		batch = batch[1:]
+		return record, nil
+	}
+}
+
+// executor builds the result set from the matching records
+type executor interface {
+	Add(record *evaluator.Record) error
+	Result() (internal.ResultSet, error)
+}
+
+// recordsExecutor returns the matching records as rows, the
+// columns are the projections of the query or, if there are
+// none, the top level fields of the records.
+type recordsExecutor struct {
+	projections []internal.Projection
+
+	// wildcard is the index of the wildcard projection, if any,
+	// whose columns are added in the order they first appear
+	wildcard        int
+	wildcardColumns []string
+	seenColumns     map[string]bool
+	skipFields      map[string]bool
+
+	records []map[string]any
+
+	// top, if set, keeps only the records needed for the
+	// result instead of all of them, the output columns
+	// are used as the columns of its sort keys
+	top *topN
+}
+
+func newRecordsExecutor(projections []internal.Projection) (*recordsExecutor, error) {
+	if len(projections) == 0 {
+		projections = []internal.Projection{{Wildcard: true}}
+	}
+
+	r := &recordsExecutor{
+		projections: projections,
+		wildcard:    -1,
+		seenColumns: map[string]bool{},
+		skipFields:  map[string]bool{},
+	}
+	for i, projection := range projections {
+		if !projection.Wildcard {
+			r.skipFields[projection.Name] = true
+			continue
+		}
+
+		if r.wildcard != -1 {
+			return nil, insights.RuntimeErr("only one wildcard projection is allowed per query", nil)
+		}
+		r.wildcard = i
+	}
+
+	if r.wildcard != -1 {
+		for _, field := range projections[r.wildcard].Except {
+			r.skipFields[field] = true
+		}
+	}
+
+	return r, nil
+}
+
+func (r *recordsExecutor) Add(record *evaluator.Record) error {
+	row := map[string]any{}
+	if r.wildcard != -1 {
+		fields, err := decodeRecord(record.Raw)
+		if err != nil {
+			return err
+		}
+
+		keys := make([]string, 0, len(fields))
+		for k := range fields {
+			if !r.skipFields[k] {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+
+		// New columns are added in the order they first appear:
+		for _, k := range keys {
+			if !r.seenColumns[k] {
+				r.seenColumns[k] = true
+				r.wildcardColumns = append(r.wildcardColumns, k)
+			}
+			row[k] = fields[k]
+		}
+	}
+
+	for _, projection := range r.projections {
+		if projection.Wildcard {
+			continue
+		}
+
+		v, err := projection.Expr.EvaluateRecord(record)
+		if err != nil {
+			return err
+		}
+		row[projection.Name] = v
+	}
+
+	if r.top == nil {
+		r.records = append(r.records, row)
+		return nil
+	}
+
+	key := make([]any, len(r.top.orderBy))
+	for i, order := range r.top.orderBy {
+		key[i] = row[order.Column]
+	}
+	r.top.Add(key, row)
+
+	return nil
+}
+
+func (r *recordsExecutor) Result() (internal.ResultSet, error) {
+	records := r.records
+	if r.top != nil {
+		for _, record := range r.top.Sorted() {
+			records = append(records, record.(map[string]any))
+		}
+	}
+
+	columns := []string{}
+	for _, projection := range r.projections {
+		if projection.Wildcard {
+			columns = append(columns, r.wildcardColumns...)
+		} else {
+			columns = append(columns, projection.Name)
+		}
+	}
+
+	rows := make([][]any, 0, len(records))
+	for _, record := range records {
+		row := make([]any, len(columns))
+		for i, column := range columns {
+			row[i] = record[column]
+		}
+		rows = append(rows, row)
+	}
+
+	return internal.ResultSet{
+		Columns: columns,
+		Rows:    rows,
+	}, nil
+}
+
+// groupsExecutor groups the matching records by the
+// query keys and aggregates each group separately
+type groupsExecutor struct {
+	groupBy internal.GroupBy
+	keys    []evaluator.ValueExpression
+
+	// groups are kept in the order they first appear
+	// so the result is deterministic:
+	groups     []*group
+	groupIndex map[string]*group
+}
+
+type group struct {
+	keyValues   []any
+	aggregators []aggregators.Aggregator
+}
+
+func (e Engine) newGroupsExecutor(groupBy internal.GroupBy) (*groupsExecutor, error) {
+	keys := []evaluator.ValueExpression{}
+	for _, key := range groupBy.Keys {
+		expr, err := e.parseValue(key)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, expr)
+	}
+
+	// Validate the aggregations before reading any records:
+	for _, aggregation := range groupBy.Aggregations {
+		_, err := aggregators.New(aggregation.Func, len(aggregation.Args))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	g := &groupsExecutor{
+		groupBy:    groupBy,
+		keys:       keys,
+		groupIndex: map[string]*group{},
+	}
+
+	// Queries with aggregations and no keys always produce a single
+	// row even if no records match, e.g. `count()` should return 0:
+	if len(keys) == 0 {
+		_, err := g.findOrCreateGroup([]any{})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return g, nil
+}
+
+func (g *groupsExecutor) Add(record *evaluator.Record) error {
+	keyValues := make([]any, 0, len(g.keys))
+	for _, key := range g.keys {
+		v, err := key.EvaluateRecord(record)
+		if err != nil {
+			return err
+		}
+		keyValues = append(keyValues, v)
+	}
+
+	grp, err := g.findOrCreateGroup(keyValues)
+	if err != nil {
+		return err
+	}
+
+	for i, aggregation := range g.groupBy.Aggregations {
+		args := make([]any, 0, len(aggregation.Args))
+		for _, arg := range aggregation.Args {
+			v, err := arg.EvaluateRecord(record)
+			if err != nil {
+				return err
+			}
+			args = append(args, v)
+		}
+
+		err := grp.aggregators[i].Add(args)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (g *groupsExecutor) findOrCreateGroup(keyValues []any) (*group, error) {
+	groupID, err := json.Marshal(keyValues)
+	if err != nil {
+		return nil, insights.RuntimeErr("unable to encode group key", map[string]any{
+			"keyValues": keyValues,
+			"error":     err,
+		})
+	}
+
+	grp, found := g.groupIndex[string(groupID)]
+	if found {
+		return grp, nil
+	}
+
+	grp = &group{
+		keyValues: keyValues,
+	}
+	for _, aggregation := range g.groupBy.Aggregations {
+		aggregator, err := aggregators.New(aggregation.Func, len(aggregation.Args))
+		if err != nil {
+			return nil, err
+		}
+		grp.aggregators = append(grp.aggregators, aggregator)
+	}
+
+	g.groupIndex[string(groupID)] = grp
+	g.groups = append(g.groups, grp)
+	return grp, nil
+}
+
+func (g *groupsExecutor) Result() (internal.ResultSet, error) {
+	groups, err := g.zeroFillTimeBuckets()
+	if err != nil {
+		return internal.ResultSet{}, err
+	}
+
+	columns := append([]string{}, g.groupBy.Keys...)
+	for _, aggregation := range g.groupBy.Aggregations {
+		columns = append(columns, aggregation.Name)
+	}
+
+	rows := make([][]any, 0, len(groups))
+	for _, grp := range groups {
+		row := append([]any{}, grp.keyValues...)
+		for _, aggregator := range grp.aggregators {
+			row = append(row, aggregator.Result())
+		}
+		rows = append(rows, row)
+	}
+
+	return internal.ResultSet{
+		Columns: columns,
+		Rows:    rows,
+	}, nil
+}
+
+// maxZeroFilledGroups protects against filling huge ranges, e.g.
+// a single record from 1970 would otherwise produce millions of
+// empty groups for a query such as `bin(ts, 1m)`
+const maxZeroFilledGroups = 1000000
+
+// zeroFillTimeBuckets creates empty groups for the missing buckets
+// of the first time bucketing key, e.g. `bin(ts, 1m)`, so that time
+// series have no gaps. The groups are returned sorted by bucket and
+// then by the order the other key values first appeared.
+//
+// Groups whose bucket is null, e.g. records with no timestamp,
+// are kept at the end of the result.
+func (g *groupsExecutor) zeroFillTimeBuckets() ([]*group, error) {
+	keyIdx := -1
+	var firstBucket, lastBucket evaluator.TimeBucket
+	for _, grp := range g.groups {
+		for i, v := range grp.keyValues {
+			bucket, ok := v.(evaluator.TimeBucket)
+			if !ok || (keyIdx != -1 && i != keyIdx) {
+				continue
+			}
+
+			if keyIdx == -1 || bucket.Start.Before(firstBucket.Start) {
+				firstBucket = bucket
+			}
+			if keyIdx == -1 || bucket.Start.After(lastBucket.Start) {
+				lastBucket = bucket
+			}
+			keyIdx = i
+		}
+	}
+
+	if keyIdx == -1 {
+		return g.groups, nil
+	}
+
+	// A series is a combination of the values of the other keys:
+	var series [][]any
+	var nullBucketGroups []*group
+	seenSeries := map[string]bool{}
+	for _, grp := range g.groups {
+		if _, ok := grp.keyValues[keyIdx].(evaluator.TimeBucket); !ok {
+			nullBucketGroups = append(nullBucketGroups, grp)
+			continue
+		}
+
+		keyValues := append([]any{}, grp.keyValues...)
+		keyValues[keyIdx] = nil
+		seriesID, _ := json.Marshal(keyValues)
+		if !seenSeries[string(seriesID)] {
+			seenSeries[string(seriesID)] = true
+			series = append(series, keyValues)
+		}
+	}
+
+	var groups []*group
+	for bucket := firstBucket; !bucket.Start.After(lastBucket.Start); bucket = bucket.Next() {
+		for _, keyValues := range series {
+			if len(groups) >= maxZeroFilledGroups {
+				return nil, insights.RuntimeErr("too many time buckets to zero-fill, try using larger buckets", map[string]any{
+					"maxGroups": maxZeroFilledGroups,
+					"first":     firstBucket.String(),
+					"last":      lastBucket.String(),
+				})
+			}
+
+			keyValues = append([]any{}, keyValues...)
+			keyValues[keyIdx] = bucket
+
+			grp, err := g.findOrCreateGroup(keyValues)
+			if err != nil {
+				return nil, err
+			}
+			groups = append(groups, grp)
+		}
+	}
+
+	return append(groups, nullBucketGroups...), nil
+}
+
+// decodeRecord decodes the record using the same Go types
+// produced by the evaluator.ValueExpression interface, i.e.
+// numbers are parsed with internal.ParseNumber
+func decodeRecord(rawRecord json.RawMessage) (map[string]any, error) {
+	decoder := json.NewDecoder(bytes.NewReader(rawRecord))
+	decoder.UseNumber()
+
+	var record map[string]any
+	err := decoder.Decode(&record)
+	if err != nil {
+		return nil, insights.RuntimeErr("unable to decode record", map[string]any{
+			"record": string(rawRecord),
+			"error":  err,
+		})
+	}
+
+	for k, v := range record {
+		record[k] = internal.ConvertNumbers(v)
+	}
+
+	return record, nil
+}
@@ -0,0 +1,737 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/vingarcia/insights/internal"
+	"github.com/vingarcia/insights/internal/adapters/datasources"
+	"github.com/vingarcia/insights/internal/adapters/evaluator"
+	"github.com/vingarcia/insights/internal/adapters/evaluator/eparser"
+	tt "github.com/vingarcia/insights/internal/testtools"
+)
+
+var logs = []map[string]any{
+	{"route": "/users", "status": 200, "latency": 10, "req": map[string]any{"method": "GET"}},
+	{"route": "/users", "status": 500, "latency": 30, "req": map[string]any{"method": "POST"}},
+	{"route": "/orders", "status": 200, "latency": 20, "req": map[string]any{"method": "GET"}},
+	{"route": "/users", "status": 200, "latency": 15, "req": map[string]any{"method": "GET"}},
+}
+
+var events = []map[string]any{
+	{"ts": "2024-03-10T14:00:10Z", "level": "error"},
+	{"ts": "2024-03-10T14:00:50Z", "level": "info"},
+	{"ts": "2024-03-10T14:03:05Z", "level": "error"},
+	{"level": "error"},
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		desc               string
+		query              internal.Query
+		expectedResult     internal.ResultSet
+		expectErrToContain []string
+	}{
+		{
+			desc: "should return matching records",
+			query: internal.Query{
+				From:  "logs",
+				Where: mustParse(t, "status >= 500"),
+			},
+			expectedResult: internal.ResultSet{
+				Columns: []string{"latency", "req", "route", "status"},
+				Rows: [][]any{
+					{int64(30), map[string]any{"method": "POST"}, "/users", int64(500)},
+				},
+			},
+		},
+		{
+			desc: "should project the records",
+			query: internal.Query{
+				Select: []internal.Projection{
+					{Name: "method", Expr: mustParseValues(t, "req.method")[0]},
+					{Name: "latency_s", Expr: mustParseValues(t, "latency / 1000.0")[0]},
+					{Wildcard: true, Except: []string{"req", "latency"}},
+				},
+				From:  "logs",
+				Where: mustParse(t, "route == '/users'"),
+				OrderBy: []internal.OrderBy{
+					{Column: "latency_s", Desc: true},
+				},
+				Limit: 2,
+			},
+			expectedResult: internal.ResultSet{
+				Columns: []string{"method", "latency_s", "route", "status"},
+				Rows: [][]any{
+					{"POST", 0.03, "/users", int64(500)},
+					{"GET", 0.015, "/users", int64(200)},
+				},
+			},
+		},
+		{
+			desc: "should not repeat projected columns on the wildcard",
+			query: internal.Query{
+				Select: []internal.Projection{
+					{Wildcard: true},
+					{Name: "level", Expr: mustParseValues(t, "upper(level)")[0]},
+				},
+				From: "events",
+			},
+			expectedResult: internal.ResultSet{
+				Columns: []string{"ts", "level"},
+				Rows: [][]any{
+					{"2024-03-10T14:00:10Z", "ERROR"},
+					{"2024-03-10T14:00:50Z", "INFO"},
+					{"2024-03-10T14:03:05Z", "ERROR"},
+					{nil, "ERROR"},
+				},
+			},
+		},
+		{
+			desc: "should report ORDER BY columns that are not projected",
+			query: internal.Query{
+				Select: []internal.Projection{
+					{Name: "route", Expr: mustParseValues(t, "route")[0]},
+				},
+				From: "logs",
+				OrderBy: []internal.OrderBy{
+					{Column: "status"},
+				},
+			},
+			expectErrToContain: []string{"unknown column on ORDER BY", "status"},
+		},
+		{
+			desc: "should report projections on grouped queries",
+			query: internal.Query{
+				Select: []internal.Projection{
+					{Name: "route", Expr: mustParseValues(t, "route")[0]},
+				},
+				From: "logs",
+				GroupBy: internal.GroupBy{
+					Keys: []string{"route"},
+				},
+			},
+			expectErrToContain: []string{"projections are not supported on queries with GroupBy"},
+		},
+		{
+			desc: "should group by keys and aggregate",
+			query: internal.Query{
+				From: "logs",
+				GroupBy: internal.GroupBy{
+					Keys: []string{"route", "status"},
+					Aggregations: []internal.Aggregation{
+						{Name: "count()", Func: "count"},
+					},
+				},
+			},
+			expectedResult: internal.ResultSet{
+				Columns: []string{"route", "status", "count()"},
+				Rows: [][]any{
+					{"/users", int64(200), int64(2)},
+					{"/users", int64(500), int64(1)},
+					{"/orders", int64(200), int64(1)},
+				},
+			},
+		},
+		{
+			desc: "should group by nested keys",
+			query: internal.Query{
+				From:  "logs",
+				Where: mustParse(t, `route == "/users"`),
+				GroupBy: internal.GroupBy{
+					Keys: []string{"req.method"},
+					Aggregations: []internal.Aggregation{
+						{Name: "count()", Func: "count"},
+					},
+				},
+			},
+			expectedResult: internal.ResultSet{
+				Columns: []string{"req.method", "count()"},
+				Rows: [][]any{
+					{"GET", int64(2)},
+					{"POST", int64(1)},
+				},
+			},
+		},
+		{
+			desc: "should aggregate all records when there are no keys",
+			query: internal.Query{
+				From:  "logs",
+				Where: mustParse(t, "status == 404"),
+				GroupBy: internal.GroupBy{
+					Aggregations: []internal.Aggregation{
+						{Name: "count()", Func: "count"},
+					},
+				},
+			},
+			expectedResult: internal.ResultSet{
+				Columns: []string{"count()"},
+				Rows: [][]any{
+					{int64(0)},
+				},
+			},
+		},
+		{
+			desc: "should compute the standard aggregations",
+			query: internal.Query{
+				From: "logs",
+				GroupBy: internal.GroupBy{
+					Keys: []string{"route"},
+					Aggregations: []internal.Aggregation{
+						{Name: "count(missing)", Func: "count", Args: mustParseValues(t, "missing")},
+						{Name: "sum(latency)", Func: "sum", Args: mustParseValues(t, "latency")},
+						{Name: "avg(latency)", Func: "avg", Args: mustParseValues(t, "latency")},
+						{Name: "min(latency)", Func: "min", Args: mustParseValues(t, "latency")},
+						{Name: "max(req.method)", Func: "max", Args: mustParseValues(t, "req.method")},
+						{Name: "first(status)", Func: "first", Args: mustParseValues(t, "status")},
+						{Name: "last(status)", Func: "last", Args: mustParseValues(t, "status")},
+					},
+				},
+			},
+			expectedResult: internal.ResultSet{
+				Columns: []string{
+					"route", "count(missing)", "sum(latency)", "avg(latency)",
+					"min(latency)", "max(req.method)", "first(status)", "last(status)",
+				},
+				Rows: [][]any{
+					{"/users", int64(0), int64(55), 55.0 / 3, int64(10), "POST", int64(200), int64(200)},
+					{"/orders", int64(0), int64(20), 20.0, int64(20), "GET", int64(200), int64(200)},
+				},
+			},
+		},
+		{
+			desc: "should report aggregations with the wrong number of arguments",
+			query: internal.Query{
+				From: "logs",
+				GroupBy: internal.GroupBy{
+					Aggregations: []internal.Aggregation{
+						{Name: "sum()", Func: "sum"},
+					},
+				},
+			},
+			expectErrToContain: []string{"wrong number of arguments", "sum"},
+		},
+		{
+			desc: "should zero-fill empty time buckets",
+			query: internal.Query{
+				From: "events",
+				GroupBy: internal.GroupBy{
+					Keys: []string{"bin(ts, 1m)"},
+					Aggregations: []internal.Aggregation{
+						{Name: "count()", Func: "count"},
+					},
+				},
+			},
+			expectedResult: internal.ResultSet{
+				Columns: []string{"bin(ts, 1m)", "count()"},
+				Rows: [][]any{
+					{timeBucket(0, time.Minute), int64(2)},
+					{timeBucket(1, time.Minute), int64(0)},
+					{timeBucket(2, time.Minute), int64(0)},
+					{timeBucket(3, time.Minute), int64(1)},
+					{nil, int64(1)},
+				},
+			},
+		},
+		{
+			desc: "should zero-fill empty time buckets for each series",
+			query: internal.Query{
+				From:  "events",
+				Where: mustParse(t, "exists(ts)"),
+				GroupBy: internal.GroupBy{
+					Keys: []string{"level", "bin(ts, 2m)"},
+					Aggregations: []internal.Aggregation{
+						{Name: "count()", Func: "count"},
+						{Name: "max(ts)", Func: "max", Args: mustParseValues(t, "ts")},
+					},
+				},
+			},
+			expectedResult: internal.ResultSet{
+				Columns: []string{"level", "bin(ts, 2m)", "count()", "max(ts)"},
+				Rows: [][]any{
+					{"error", timeBucket(0, 2*time.Minute), int64(1), "2024-03-10T14:00:10Z"},
+					{"info", timeBucket(0, 2*time.Minute), int64(1), "2024-03-10T14:00:50Z"},
+					{"error", timeBucket(2, 2*time.Minute), int64(1), "2024-03-10T14:03:05Z"},
+					{"info", timeBucket(2, 2*time.Minute), int64(0), nil},
+				},
+			},
+		},
+		{
+			desc: "should sort and limit the rows",
+			query: internal.Query{
+				From: "logs",
+				GroupBy: internal.GroupBy{
+					Keys: []string{"route", "status"},
+					Aggregations: []internal.Aggregation{
+						{Name: "count()", Func: "count"},
+					},
+				},
+				OrderBy: []internal.OrderBy{
+					{Column: "count()"},
+					{Column: "route", Desc: true},
+				},
+				Limit: 2,
+			},
+			expectedResult: internal.ResultSet{
+				Columns: []string{"route", "status", "count()"},
+				Rows: [][]any{
+					{"/users", int64(500), int64(1)},
+					{"/orders", int64(200), int64(1)},
+				},
+			},
+		},
+		{
+			desc: "should sort records with nulls last",
+			query: internal.Query{
+				From: "events",
+				OrderBy: []internal.OrderBy{
+					{Column: "ts"},
+				},
+				Limit: 2,
+			},
+			expectedResult: internal.ResultSet{
+				Columns: []string{"level", "ts"},
+				Rows: [][]any{
+					{"error", "2024-03-10T14:00:10Z"},
+					{"info", "2024-03-10T14:00:50Z"},
+				},
+			},
+		},
+		{
+			desc: "should filter the aggregated rows with having",
+			query: internal.Query{
+				From: "logs",
+				GroupBy: internal.GroupBy{
+					Keys: []string{"route"},
+					Aggregations: []internal.Aggregation{
+						{Name: "count()", Func: "count"},
+						{Name: "avg(latency)", Func: "avg", Args: mustParseValues(t, "latency")},
+					},
+				},
+				Having: mustParse(t, `$root["count()"] > 1 or route == "/health"`),
+			},
+			expectedResult: internal.ResultSet{
+				Columns: []string{"route", "count()", "avg(latency)"},
+				Rows: [][]any{
+					{"/users", int64(3), 55.0 / 3},
+				},
+			},
+		},
+		{
+			desc: "should skip the offset rows",
+			query: internal.Query{
+				From: "logs",
+				GroupBy: internal.GroupBy{
+					Keys: []string{"route", "status"},
+				},
+				OrderBy: []internal.OrderBy{
+					{Column: "route"},
+					{Column: "status", Desc: true},
+				},
+				Limit:  2,
+				Offset: 1,
+			},
+			expectedResult: internal.ResultSet{
+				Columns: []string{"route", "status"},
+				Rows: [][]any{
+					{"/users", int64(500)},
+					{"/users", int64(200)},
+				},
+			},
+		},
+		{
+			desc: "should return no rows when the offset is past the end",
+			query: internal.Query{
+				From:   "events",
+				Offset: 10,
+			},
+			expectedResult: internal.ResultSet{
+				Columns: []string{"level", "ts"},
+				Rows:    [][]any{},
+			},
+		},
+		{
+			desc: "should sort nulls first when requested",
+			query: internal.Query{
+				From: "events",
+				OrderBy: []internal.OrderBy{
+					{Column: "ts", Nulls: internal.NullsFirst},
+				},
+				Limit: 2,
+			},
+			expectedResult: internal.ResultSet{
+				Columns: []string{"level", "ts"},
+				Rows: [][]any{
+					{"error", nil},
+					{"error", "2024-03-10T14:00:10Z"},
+				},
+			},
+		},
+		{
+			desc: "should sort nulls last on descending order when requested",
+			query: internal.Query{
+				From: "events",
+				OrderBy: []internal.OrderBy{
+					{Column: "ts", Desc: true, Nulls: internal.NullsLast},
+				},
+			},
+			expectedResult: internal.ResultSet{
+				Columns: []string{"level", "ts"},
+				Rows: [][]any{
+					{"error", "2024-03-10T14:03:05Z"},
+					{"info", "2024-03-10T14:00:50Z"},
+					{"error", "2024-03-10T14:00:10Z"},
+					{"error", nil},
+				},
+			},
+		},
+		{
+			desc: "should report unknown ORDER BY columns",
+			query: internal.Query{
+				From: "logs",
+				GroupBy: internal.GroupBy{
+					Keys: []string{"route"},
+				},
+				OrderBy: []internal.OrderBy{
+					{Column: "status"},
+				},
+			},
+			expectErrToContain: []string{"unknown column on ORDER BY", "status"},
+		},
+		{
+			desc: "should report unknown data sources",
+			query: internal.Query{
+				From: "unknown",
+			},
+			expectErrToContain: []string{"data source not found", "unknown"},
+		},
+		{
+			desc: "should report unknown aggregation functions",
+			query: internal.Query{
+				From: "logs",
+				GroupBy: internal.GroupBy{
+					Aggregations: []internal.Aggregation{
+						{Name: "foo()", Func: "foo"},
+					},
+				},
+			},
+			expectErrToContain: []string{"unknown aggregation function", "foo"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			engine := New(datasources.Repo{
+				"logs":   datasources.NewMemorySource("logs", logs),
+				"events": datasources.NewMemorySource("events", events),
+			}, eparser.ParseValue)
+
+			result, err := engine.Run(context.Background(), test.query)
+			if test.expectErrToContain != nil {
+				tt.AssertErrContains(t, err, test.expectErrToContain...)
+				return
+			}
+			tt.AssertNoErr(t, err)
+
+			tt.AssertEqual(t, result, test.expectedResult)
+		})
+	}
+}
+
+func TestDecodeRecord(t *testing.T) {
+	rawRecord := []byte(`{"a": 1.0, "b": 1.5, "c": 1e3, "d": 12345678901234567890, "e": [2.0, {"f": -3.0}]}`)
+
+	record, err := decodeRecord(rawRecord)
+	tt.AssertNoErr(t, err)
+
+	// Numbers must be decoded just like the expressions decode them:
+	for field, value := range record {
+		expected, err := mustParseValues(t, field)[0].Evaluate(rawRecord)
+		tt.AssertNoErr(t, err)
+		tt.AssertEqual(t, value, expected)
+	}
+	tt.AssertEqual(t, record["a"], int64(1))
+	tt.AssertEqual(t, record["e"], []any{int64(2), map[string]any{"f": int64(-3)}})
+}
+
+func TestRunStopsReadingEarly(t *testing.T) {
+	records := []map[string]any{}
+	for i := 0; i < 100; i++ {
+		records = append(records, map[string]any{"i": i})
+	}
+
+	tests := []struct {
+		desc          string
+		query         internal.Query
+		expectedReads int
+	}{
+		{
+			desc: "should stop reading once the limit is reached",
+			query: internal.Query{
+				From:   "numbers",
+				Where:  mustParse(t, "i % 2 == 0"),
+				Limit:  3,
+				Offset: 2,
+			},
+			expectedReads: 9,
+		},
+		{
+			desc: "should read all records when sorting",
+			query: internal.Query{
+				From:    "numbers",
+				OrderBy: []internal.OrderBy{{Column: "i", Desc: true}},
+				Limit:   3,
+			},
+			expectedReads: 100,
+		},
+		{
+			desc: "should read all records when there is no limit",
+			query: internal.Query{
+				From:   "numbers",
+				Offset: 3,
+			},
+			expectedReads: 100,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			iterator := &countingIterator{}
+			engine := New(datasources.Repo{
+				"numbers": {
+					Name: "numbers",
+					Open: func(ctx context.Context) (internal.RecordIterator, error) {
+						var err error
+						iterator.RecordIterator, err = datasources.NewMemorySource("numbers", records).Open(ctx)
+						return iterator, err
+					},
+				},
+			}, eparser.ParseValue)
+
+			_, err := engine.Run(context.Background(), test.query)
+			tt.AssertNoErr(t, err)
+
+			tt.AssertEqual(t, iterator.reads, test.expectedReads)
+			tt.AssertEqual(t, iterator.closed, true)
+		})
+	}
+}
+
+// countingIterator hides the NextBatch method of the
+// wrapped iterator so records are read one at a time
+type countingIterator struct {
+	internal.RecordIterator
+	reads  int
+	closed bool
+}
+
+func (c *countingIterator) Next() (internal.Record, error) {
+	record, err := c.RecordIterator.Next()
+	if err == nil {
+		c.reads++
+	}
+	return record, err
+}
+
+func (c *countingIterator) Close() error {
+	c.closed = true
+	return c.RecordIterator.Close()
+}
+
+func TestRunRecordErrors(t *testing.T) {
+	repo := datasources.Repo{
+		"broken": {
+			Name: "broken",
+			Open: func(ctx context.Context) (internal.RecordIterator, error) {
+				return &sliceIterator{records: []internal.Record{
+					{Raw: []byte(`{"a": 1}`)},
+					{Err: errors.New("malformed line 2")},
+					{Raw: []byte(`{"a": 3}`)},
+				}}, nil
+			},
+		},
+	}
+	query := internal.Query{
+		From: "broken",
+		GroupBy: internal.GroupBy{
+			Aggregations: []internal.Aggregation{
+				{Name: "sum(a)", Func: "sum", Args: mustParseValues(t, "a")},
+			},
+		},
+	}
+
+	t.Run("should fail on record errors by default", func(t *testing.T) {
+		_, err := New(repo, eparser.ParseValue).Run(context.Background(), query)
+		tt.AssertErrContains(t, err, "malformed line 2")
+	})
+
+	t.Run("should skip records when the handler returns nil", func(t *testing.T) {
+		var recordErrs []error
+		engine := New(repo, eparser.ParseValue).WithRecordErrHandler(func(err error) error {
+			recordErrs = append(recordErrs, err)
+			return nil
+		})
+
+		result, err := engine.Run(context.Background(), query)
+		tt.AssertNoErr(t, err)
+
+		tt.AssertEqual(t, result.Rows, [][]any{{int64(4)}})
+		tt.AssertEqual(t, recordErrs, []error{errors.New("malformed line 2")})
+	})
+}
+
+func TestRunCancellation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	engine := New(datasources.Repo{
+		"logs": datasources.NewMemorySource("logs", logs),
+	}, eparser.ParseValue)
+
+	_, err := engine.Run(ctx, internal.Query{From: "logs"})
+	tt.AssertEqual(t, err, context.Canceled)
+}
+
+func TestStream(t *testing.T) {
+	tests := []struct {
+		desc               string
+		query              internal.Query
+		expectedResults    []internal.ResultSet
+		expectErrToContain []string
+	}{
+		{
+			desc: "should emit each matching record as it is read",
+			query: internal.Query{
+				Select: []internal.Projection{
+					{Name: "route", Expr: mustParseValues(t, "route")[0]},
+					{Name: "latency", Expr: mustParseValues(t, "latency")[0]},
+				},
+				From:  "logs",
+				Where: mustParse(t, "status == 200"),
+			},
+			expectedResults: []internal.ResultSet{
+				{Columns: []string{"route", "latency"}, Rows: [][]any{{"/users", int64(10)}}},
+				{Columns: []string{"route", "latency"}, Rows: [][]any{{"/orders", int64(20)}}},
+				{Columns: []string{"route", "latency"}, Rows: [][]any{{"/users", int64(15)}}},
+			},
+		},
+		{
+			desc: "should apply offset and limit",
+			query: internal.Query{
+				Select: []internal.Projection{
+					{Name: "latency", Expr: mustParseValues(t, "latency")[0]},
+				},
+				From:   "logs",
+				Limit:  2,
+				Offset: 1,
+			},
+			expectedResults: []internal.ResultSet{
+				{Columns: []string{"latency"}, Rows: [][]any{{int64(30)}}},
+				{Columns: []string{"latency"}, Rows: [][]any{{int64(20)}}},
+			},
+		},
+		{
+			desc: "should reject queries with GroupBy",
+			query: internal.Query{
+				From: "logs",
+				GroupBy: internal.GroupBy{
+					Aggregations: []internal.Aggregation{
+						{Name: "count()", Func: "count"},
+					},
+				},
+			},
+			expectErrToContain: []string{"streaming is not supported"},
+		},
+		{
+			desc: "should reject queries with OrderBy",
+			query: internal.Query{
+				From:    "logs",
+				OrderBy: []internal.OrderBy{{Column: "latency"}},
+			},
+			expectErrToContain: []string{"streaming is not supported"},
+		},
+		{
+			desc:               "should report unknown data sources",
+			query:              internal.Query{From: "missing"},
+			expectErrToContain: []string{"data source not found"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			engine := New(datasources.Repo{
+				"logs": datasources.NewMemorySource("logs", logs),
+			}, eparser.ParseValue)
+
+			var results []internal.ResultSet
+			err := engine.Stream(context.Background(), test.query, func(result internal.ResultSet) error {
+				results = append(results, result)
+				return nil
+			})
+			if test.expectErrToContain != nil {
+				tt.AssertErrContains(t, err, test.expectErrToContain...)
+				return
+			}
+			tt.AssertNoErr(t, err)
+
+			tt.AssertEqual(t, results, test.expectedResults)
+		})
+	}
+
+	t.Run("should stop on errors from emit", func(t *testing.T) {
+		engine := New(datasources.Repo{
+			"logs": datasources.NewMemorySource("logs", logs),
+		}, eparser.ParseValue)
+
+		numCalls := 0
+		err := engine.Stream(context.Background(), internal.Query{From: "logs"}, func(result internal.ResultSet) error {
+			numCalls++
+			return errors.New("fake emit error")
+		})
+		tt.AssertErrContains(t, err, "fake emit error")
+		tt.AssertEqual(t, numCalls, 1)
+	})
+}
+
+type sliceIterator struct {
+	records []internal.Record
+}
+
+func (s *sliceIterator) Next() (internal.Record, error) {
+	if len(s.records) == 0 {
+		return internal.Record{}, io.EOF
+	}
+	record := s.records[0]
+	s.records = s.records[1:]
+	return record, nil
+}
+
+func (s *sliceIterator) Close() error {
+	return nil
+}
+
+func timeBucket(minute int, width time.Duration) evaluator.TimeBucket {
+	return evaluator.TimeBucket{
+		Start: time.Date(2024, 3, 10, 14, minute, 0, 0, time.UTC),
+		Width: width,
+	}
+}
+
+func mustParse(t *testing.T, expr string) evaluator.Expression {
+	e, err := eparser.Parse(expr)
+	tt.AssertNoErr(t, err)
+	return e
+}
+
+func mustParseValues(t *testing.T, exprs ...string) []evaluator.ValueExpression {
+	values := []evaluator.ValueExpression{}
+	for _, expr := range exprs {
+		v, err := eparser.ParseValue(expr)
+		tt.AssertNoErr(t, err)
+		values = append(values, v)
+	}
+	return values
+}
@@ -0,0 +1,282 @@
+package engine
+
+import (
+	"container/heap"
+	"encoding/json"
+	"math"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/vingarcia/insights"
+	"github.com/vingarcia/insights/internal"
+	"github.com/vingarcia/insights/internal/adapters/evaluator"
+)
+
+// sortRows sorts the rows of the result set in place, if
+// strictColumns is false, columns that don't exist on the
+// result are ignored, since it is not possible to know the
+// columns of a data source without reading all its records.
+func sortRows(result internal.ResultSet, orderBy []internal.OrderBy, strictColumns bool) error {
+	if len(orderBy) == 0 {
+		return nil
+	}
+
+	keyOf, err := newKeyFunc(result.Columns, orderBy, strictColumns)
+	if err != nil {
+		return err
+	}
+
+	sort.SliceStable(result.Rows, func(i, j int) bool {
+		return compareKeys(orderBy, keyOf(result.Rows[i]), keyOf(result.Rows[j])) < 0
+	})
+
+	return nil
+}
+
+// topRows returns the first n rows of the result set as if it was
+// sorted by sortRows, but using a bounded heap so that only n rows
+// are sorted instead of all of them.
+func topRows(result internal.ResultSet, orderBy []internal.OrderBy, strictColumns bool, n int) ([][]any, error) {
+	keyOf, err := newKeyFunc(result.Columns, orderBy, strictColumns)
+	if err != nil {
+		return nil, err
+	}
+
+	top := newTopN(orderBy, n)
+	for _, row := range result.Rows {
+		top.Add(keyOf(row), row)
+	}
+
+	rows := make([][]any, 0, n)
+	for _, row := range top.Sorted() {
+		rows = append(rows, row.([]any))
+	}
+
+	return rows, nil
+}
+
+// newKeyFunc returns a function that extracts the values of the
+// OrderBy columns from a row, the ignored columns are always null
+func newKeyFunc(columns []string, orderBy []internal.OrderBy, strictColumns bool) (func(row []any) []any, error) {
+	columnIdxs := make([]int, len(orderBy))
+	for i, order := range orderBy {
+		columnIdxs[i] = -1
+		for j, column := range columns {
+			if column == order.Column {
+				columnIdxs[i] = j
+				break
+			}
+		}
+
+		if columnIdxs[i] == -1 && strictColumns {
+			return nil, insights.RuntimeErr("unknown column on ORDER BY", map[string]any{
+				"column":  order.Column,
+				"columns": columns,
+			})
+		}
+	}
+
+	return func(row []any) []any {
+		key := make([]any, len(columnIdxs))
+		for i, idx := range columnIdxs {
+			if idx != -1 {
+				key[i] = row[idx]
+			}
+		}
+		return key
+	}, nil
+}
+
+// compareKeys compares two sort keys, i.e. the values of the
+// OrderBy columns of two rows, following the OrderBy directions
+func compareKeys(orderBy []internal.OrderBy, k1 []any, k2 []any) int {
+	for i, order := range orderBy {
+		if order.Nulls != "" {
+			null1, null2 := typeRank(k1[i]) == nullRank, typeRank(k2[i]) == nullRank
+			if null1 != null2 {
+				if null1 == (order.Nulls == internal.NullsFirst) {
+					return -1
+				}
+				return 1
+			}
+		}
+
+		c := compareValues(k1[i], k2[i])
+		if c == 0 {
+			continue
+		}
+
+		if order.Desc {
+			return -c
+		}
+		return c
+	}
+
+	return 0
+}
+
+// topN keeps the first n values added to it according to their
+// sort keys, ties are broken by the order the values were added
+// so the result is the same as the one of a stable sort.
+//
+// The kept values are stored on a heap with the last of them at
+// its root, so adding a value costs O(log n) and memory usage is
+// bounded by n regardless of how many values are added.
+type topN struct {
+	orderBy []internal.OrderBy
+	n       int
+	seq     int
+	items   topNHeap
+}
+
+type topNItem struct {
+	key   []any
+	seq   int
+	value any
+}
+
+func newTopN(orderBy []internal.OrderBy, n int) *topN {
+	return &topN{
+		orderBy: orderBy,
+		n:       n,
+		items: topNHeap{
+			orderBy: orderBy,
+		},
+	}
+}
+
+func (t *topN) Add(key []any, value any) {
+	item := topNItem{
+		key:   key,
+		seq:   t.seq,
+		value: value,
+	}
+	t.seq++
+
+	if len(t.items.items) < t.n {
+		heap.Push(&t.items, item)
+		return
+	}
+
+	// Since item was added last it also loses all ties:
+	if t.n == 0 || !t.items.comesBefore(item, t.items.items[0]) {
+		return
+	}
+
+	t.items.items[0] = item
+	heap.Fix(&t.items, 0)
+}
+
+// Sorted returns the kept values in order
+func (t *topN) Sorted() []any {
+	items := append([]topNItem{}, t.items.items...)
+	sort.Slice(items, func(i, j int) bool {
+		return t.items.comesBefore(items[i], items[j])
+	})
+
+	values := make([]any, 0, len(items))
+	for _, item := range items {
+		values = append(values, item.value)
+	}
+
+	return values
+}
+
+// topNHeap implements heap.Interface keeping
+// the last item of the sort order at its root
+type topNHeap struct {
+	orderBy []internal.OrderBy
+	items   []topNItem
+}
+
+func (h *topNHeap) comesBefore(a topNItem, b topNItem) bool {
+	c := compareKeys(h.orderBy, a.key, b.key)
+	if c != 0 {
+		return c < 0
+	}
+
+	return a.seq < b.seq
+}
+
+func (h topNHeap) Len() int           { return len(h.items) }
+func (h topNHeap) Less(i, j int) bool { return h.comesBefore(h.items[j], h.items[i]) }
+func (h topNHeap) Swap(i, j int)      { h.items[i], h.items[j] = h.items[j], h.items[i] }
+
+func (h *topNHeap) Push(item any) {
+	h.items = append(h.items, item.(topNItem))
+}
+
+func (h *topNHeap) Pop() any {
+	last := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return last
+}
+
+// compareValues returns a negative number if v1 < v2, zero if they
+// are equal and a positive number otherwise. Values of different
+// types are ordered by type: bools, numbers, strings, time buckets,
+// lists and maps and finally nulls.
+func compareValues(v1 any, v2 any) int {
+	r1, r2 := typeRank(v1), typeRank(v2)
+	if r1 != r2 {
+		return r1 - r2
+	}
+
+	switch v1 := v1.(type) {
+	case bool:
+		b2 := v2.(bool)
+		if v1 == b2 {
+			return 0
+		}
+		if b2 {
+			return -1
+		}
+		return 1
+	case string:
+		return strings.Compare(v1, v2.(string))
+	case evaluator.TimeBucket:
+		return v1.Start.Compare(v2.(evaluator.TimeBucket).Start)
+	case nil:
+		return 0
+	}
+
+	if r1 == numberRank {
+		c, _ := internal.CompareNumbers(v1, v2)
+		return c
+	}
+
+	// Lists and maps have no natural order, but
+	// sorting them by their JSON makes it deterministic:
+	b1, _ := json.Marshal(v1)
+	b2, _ := json.Marshal(v2)
+	return strings.Compare(string(b1), string(b2))
+}
+
+const (
+	numberRank = 2
+	nullRank   = 6
+)
+
+func typeRank(v any) int {
+	switch v := v.(type) {
+	case bool:
+		return 1
+	case int64, *big.Int:
+		return numberRank
+	case float64:
+		// NaNs are sorted with nulls since they can't be compared:
+		if math.IsNaN(v) {
+			return nullRank
+		}
+		return numberRank
+	case string:
+		return 3
+	case evaluator.TimeBucket:
+		return 4
+	case nil:
+		return nullRank
+	}
+
+	return 5
+}
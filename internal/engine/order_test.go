@@ -0,0 +1,52 @@
+package engine
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/vingarcia/insights/internal"
+	tt "github.com/vingarcia/insights/internal/testtools"
+)
+
+func TestTopRows(t *testing.T) {
+	// Many ties and nulls so the results depend on the tie breaking:
+	result := internal.ResultSet{
+		Columns: []string{"id", "a", "b"},
+	}
+	for i := 0; i < 200; i++ {
+		var b any = int64(i * 7 % 5)
+		if i%11 == 0 {
+			b = nil
+		}
+		result.Rows = append(result.Rows, []any{int64(i), int64(i * 13 % 4), b})
+	}
+
+	orderBys := [][]internal.OrderBy{
+		{{Column: "a"}},
+		{{Column: "b", Desc: true}},
+		{{Column: "b", Nulls: internal.NullsFirst}, {Column: "a", Desc: true}},
+		{{Column: "a", Desc: true}, {Column: "b", Desc: true, Nulls: internal.NullsLast}},
+	}
+
+	for _, orderBy := range orderBys {
+		sorted := internal.ResultSet{
+			Columns: result.Columns,
+			Rows:    append([][]any{}, result.Rows...),
+		}
+		tt.AssertNoErr(t, sortRows(sorted, orderBy, true))
+
+		for _, n := range []int{1, 7, 50, 200, 300} {
+			t.Run(fmt.Sprintf("%v/%d", orderBy, n), func(t *testing.T) {
+				rows, err := topRows(result, orderBy, true, n)
+				tt.AssertNoErr(t, err)
+
+				tt.AssertEqual(t, rows, sorted.Rows[:min(n, len(sorted.Rows))])
+			})
+		}
+	}
+
+	t.Run("should report unknown columns", func(t *testing.T) {
+		_, err := topRows(result, []internal.OrderBy{{Column: "c"}}, true, 10)
+		tt.AssertErrContains(t, err, "unknown column on ORDER BY", "c")
+	})
+}
@@ -0,0 +1,97 @@
+package engine
+
+import (
+	"encoding/json"
+
+	"github.com/vingarcia/insights"
+	"github.com/vingarcia/insights/internal"
+	"github.com/vingarcia/insights/internal/adapters/evaluator"
+)
+
+// ApplyStages applies each of the stages, in order, to the result set
+func ApplyStages(result internal.ResultSet, stages []internal.Stage) (internal.ResultSet, error) {
+	for _, stage := range stages {
+		var err error
+		switch stage := stage.(type) {
+		case internal.FilterStage:
+			result, err = filterRows(result, stage.Where)
+		case internal.SortStage:
+			result, err = sortedCopy(result, stage.OrderBy)
+		case internal.HeadStage:
+			result = headRows(result, stage.N)
+		default:
+			err = insights.InternalErr("unknown stage type", map[string]any{
+				"stage": stage,
+			})
+		}
+		if err != nil {
+			return internal.ResultSet{}, err
+		}
+	}
+
+	return result, nil
+}
+
+// filterRows keeps only the rows matching the where expression,
+// see internal.FilterStage
+func filterRows(result internal.ResultSet, where evaluator.Expression) (internal.ResultSet, error) {
+	rows := [][]any{}
+	for _, row := range result.Rows {
+		record := map[string]any{}
+		for i, column := range result.Columns {
+			if row[i] != nil {
+				record[column] = row[i]
+			}
+		}
+
+		rawRecord, err := json.Marshal(record)
+		if err != nil {
+			return internal.ResultSet{}, insights.RuntimeErr("unable to encode row as JSON", map[string]any{
+				"row":   row,
+				"error": err,
+			})
+		}
+
+		match, err := where.Evaluate(rawRecord)
+		if err != nil {
+			return internal.ResultSet{}, err
+		}
+
+		if match {
+			rows = append(rows, row)
+		}
+	}
+
+	return internal.ResultSet{
+		Columns: result.Columns,
+		Rows:    rows,
+	}, nil
+}
+
+// sortedCopy returns a copy of the result with its rows sorted,
+// so the input is not modified, the columns of the result are
+// all known at this point, so unknown columns are reported
+func sortedCopy(result internal.ResultSet, orderBy []internal.OrderBy) (internal.ResultSet, error) {
+	sorted := internal.ResultSet{
+		Columns: result.Columns,
+		Rows:    append([][]any{}, result.Rows...),
+	}
+
+	err := sortRows(sorted, orderBy, true)
+	if err != nil {
+		return internal.ResultSet{}, err
+	}
+
+	return sorted, nil
+}
+
+func headRows(result internal.ResultSet, n int) internal.ResultSet {
+	if len(result.Rows) <= n {
+		return result
+	}
+
+	return internal.ResultSet{
+		Columns: result.Columns,
+		Rows:    result.Rows[:n],
+	}
+}
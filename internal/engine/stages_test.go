@@ -0,0 +1,103 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/vingarcia/insights/internal"
+	tt "github.com/vingarcia/insights/internal/testtools"
+)
+
+var routes = internal.ResultSet{
+	Columns: []string{"route", "count()", "p95"},
+	Rows: [][]any{
+		{"/users", int64(10), 12.5},
+		{"/orders", int64(30), nil},
+		{"/health", int64(20), 1.0},
+	},
+}
+
+func TestStages(t *testing.T) {
+	tests := []struct {
+		desc               string
+		stages             []internal.Stage
+		expectedResult     internal.ResultSet
+		expectErrToContain []string
+	}{
+		{
+			desc:           "should return the input when there are no stages",
+			expectedResult: routes,
+		},
+		{
+			desc: "should filter rows using the columns as fields",
+			stages: []internal.Stage{
+				internal.FilterStage{Where: mustParse(t, `$root["count()"] > 10 and (p95 < 5 or !exists(p95))`)},
+			},
+			expectedResult: internal.ResultSet{
+				Columns: routes.Columns,
+				Rows: [][]any{
+					{"/orders", int64(30), nil},
+					{"/health", int64(20), 1.0},
+				},
+			},
+		},
+		{
+			desc: "should sort rows",
+			stages: []internal.Stage{
+				internal.SortStage{OrderBy: []internal.OrderBy{{Column: "p95", Desc: true}}},
+			},
+			expectedResult: internal.ResultSet{
+				Columns: routes.Columns,
+				Rows: [][]any{
+					{"/orders", int64(30), nil},
+					{"/users", int64(10), 12.5},
+					{"/health", int64(20), 1.0},
+				},
+			},
+		},
+		{
+			desc: "should apply the stages in order",
+			stages: []internal.Stage{
+				internal.HeadStage{N: 2},
+				internal.SortStage{OrderBy: []internal.OrderBy{{Column: "route"}}},
+				internal.HeadStage{N: 5},
+			},
+			expectedResult: internal.ResultSet{
+				Columns: routes.Columns,
+				Rows: [][]any{
+					{"/orders", int64(30), nil},
+					{"/users", int64(10), 12.5},
+				},
+			},
+		},
+		{
+			desc: "should report unknown columns when sorting",
+			stages: []internal.Stage{
+				internal.SortStage{OrderBy: []internal.OrderBy{{Column: "typo", Desc: true}}},
+			},
+			expectErrToContain: []string{"RuntimeErr", "unknown column on ORDER BY", "typo"},
+		},
+		{
+			desc: "should report errors from the filter expressions",
+			stages: []internal.Stage{
+				internal.FilterStage{Where: mustParse(t, "p95 / 0 > 1")},
+			},
+			expectErrToContain: []string{"RuntimeErr", "division by zero"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			result, err := ApplyStages(routes, test.stages)
+			if test.expectErrToContain != nil {
+				tt.AssertErrContains(t, err, test.expectErrToContain...)
+				return
+			}
+			tt.AssertNoErr(t, err)
+
+			tt.AssertEqual(t, result, test.expectedResult)
+		})
+	}
+
+	// Stages should never modify their input:
+	tt.AssertEqual(t, routes.Rows[0], []any{"/users", int64(10), 12.5})
+}
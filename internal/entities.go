@@ -1,6 +1,11 @@
 package internal
 
-import "github.com/vingarcia/insights/internal/adapters/evaluator"
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/vingarcia/insights/internal/adapters/evaluator"
+)
 
 type DataSourceRepo interface {
 	FindByName(name string) DataSource
@@ -9,16 +14,168 @@ type DataSourceRepo interface {
 type DataSource struct {
 	Name string
 	Type string
-	Read func() map[string]any
+
+	// Open starts reading the records of the data source, the
+	// iterator must be closed after use and should stop reading
+	// once ctx is canceled, returning ctx.Err() from Next.
+	Open func(ctx context.Context) (RecordIterator, error)
+}
+
+// RecordIterator reads the records of a DataSource incrementally
+type RecordIterator interface {
+	// Next returns the next record or io.EOF when there are no more
+	// records to read, any other error means reading has failed.
+	//
+	// Errors that only affect a single record, e.g. a malformed
+	// line, are returned on the Err field of the record instead.
+	Next() (Record, error)
+
+	Close() error
+}
+
+// BatchIterator can optionally be implemented by RecordIterators
+// that are able to read several records at once more efficiently
+type BatchIterator interface {
+	RecordIterator
+
+	// NextBatch returns up to max records, the error has the
+	// same meaning it has on Next and might be returned
+	// together with the last records of the data source.
+	NextBatch(max int) ([]Record, error)
+}
+
+// Record is a record read from a DataSource, Raw contains the
+// record encoded as a JSON object so that expressions can decode
+// only the fields they use
+type Record struct {
+	Raw json.RawMessage
+
+	// Err is set when this record could not be read,
+	// in which case Raw should be ignored
+	Err error
 }
 
 type Query struct {
+	// Select lists the columns of the result of queries with
+	// no GroupBy, an empty Select returns all the fields
+	Select []Projection
+
 	From    string
 	Where   evaluator.Expression
 	GroupBy GroupBy
+
+	// Having filters the rows of the result, the columns of
+	// each row are available to it as top level fields, e.g.
+	// `$root["count()"] > 10`, so unlike Where it can be
+	// used for filtering the aggregated values
+	Having evaluator.Expression
+
+	OrderBy []OrderBy
+
+	// Limit is the maximum number of rows
+	// of the result, zero means no limit
+	Limit int
+
+	// Offset is the number of rows skipped
+	// from the start of the result
+	Offset int
+}
+
+// Projection describes a column of the result of queries with
+// no GroupBy, e.g. `latency_ms / 1000 AS latency_s` would be:
+//
+//	Projection{Name: "latency_s", Expr: <latency_ms / 1000 expression>}
+type Projection struct {
+	// Name is the name of the output column
+	Name string
+
+	// Expr is evaluated for each record, it is
+	// nil for wildcard projections
+	Expr evaluator.ValueExpression
+
+	// Wildcard projections, i.e. `*`, expand to all top level
+	// fields of the records but the ones listed on Except and
+	// the ones with the same name of other projections
+	Wildcard bool
+	Except   []string
 }
 
 type GroupBy struct {
+	// Keys are the fields used for grouping the records,
+	// nested fields can be referenced as `request.route`
 	Keys         []string
-	Aggregations []evaluator.Expression
+	Aggregations []Aggregation
 }
+
+// Aggregation describes an aggregated column of the result,
+// e.g. `sum(latency_ms)` would be described as:
+//
+//	Aggregation{Name: "sum(latency_ms)", Func: "sum", Args: <latency_ms expression>}
+type Aggregation struct {
+	// Name is the name of the output column
+	Name string
+
+	// Func is the name of the aggregation function, e.g. "count"
+	Func string
+
+	// Args are evaluated for each record and the results
+	// are passed as arguments to the aggregation function
+	Args []evaluator.ValueExpression
+}
+
+// OrderBy sorts the rows of the result by one of its columns,
+// when a query has more than one OrderBy the following ones
+// are only used for breaking ties.
+//
+// Nulls are sorted as if they were greater than any other value,
+// unless the Nulls attribute is set to NullsFirst or NullsLast.
+type OrderBy struct {
+	Column string
+	Desc   bool
+	Nulls  NullsOrder
+}
+
+// NullsOrder sets where nulls are placed regardless of the sort direction
+type NullsOrder string
+
+const (
+	NullsFirst NullsOrder = "first"
+	NullsLast  NullsOrder = "last"
+)
+
+// ResultSet is the tabular result of a Query
+type ResultSet struct {
+	Columns []string
+	Rows    [][]any
+}
+
+// Stage is a post-processing step applied to the result of a
+// Query, e.g. for filtering the rows produced by a GroupBy, it
+// is either a FilterStage, a SortStage or a HeadStage
+type Stage interface {
+	isStage()
+}
+
+// FilterStage keeps only the rows matching the Where expression,
+// the columns of each row are available to the expression as top
+// level fields, e.g. `$root["count()"] > 10`.
+//
+// Null values are omitted so they are treated as missing fields.
+type FilterStage struct {
+	Where evaluator.Expression
+}
+
+// SortStage sorts the rows of the result by the OrderBy columns,
+// just like the OrderBy attribute of a Query
+type SortStage struct {
+	OrderBy []OrderBy
+}
+
+// HeadStage keeps only the first N rows of the result
+type HeadStage struct {
+	N int
+}
+
+func (FilterStage) isStage() {}
+func (SortStage) isStage()   {}
+func (HeadStage) isStage()   {}
@@ -0,0 +1,143 @@
+package internal
+
+import (
+	"encoding/json"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// ParseNumber parses the JSON numbers of the records into the
+// types produced by the evaluator.ValueExpression interface, i.e.
+// int64, *big.Int for integers that don't fit on an int64, or
+// float64.
+//
+// Integral floats that fit on an int64, e.g. `1.0` or `1e3`, are
+// parsed as int64, so they work with int only operators such as
+// the bitwise operators.
+func ParseNumber(literal string) (any, error) {
+	if !strings.ContainsAny(literal, ".eE") {
+		return parseInt(literal)
+	}
+
+	f, err := strconv.ParseFloat(literal, 64)
+	if err != nil {
+		return f, err
+	}
+
+	if f == math.Trunc(f) && f >= math.MinInt64 && f < -math.MinInt64 {
+		return int64(f), nil
+	}
+
+	return f, nil
+}
+
+func parseInt(literal string) (any, error) {
+	i, err := strconv.ParseInt(literal, 10, 64)
+	if err == nil {
+		return i, nil
+	}
+
+	n, ok := new(big.Int).SetString(literal, 10)
+	if !ok {
+		return nil, err
+	}
+
+	return n, nil
+}
+
+// ConvertNumbers replaces the json.Number values produced by
+// decoders using UseNumber with the values returned by ParseNumber.
+//
+// Lists and maps are converted in place.
+func ConvertNumbers(value any) any {
+	return convertNumbers(value, ParseNumber)
+}
+
+// ConvertNumbersKeepingFloats is like ConvertNumbers but parses all
+// numbers with a decimal point or an exponent as float64, for
+// decoding values that were encoded without losing their types.
+func ConvertNumbersKeepingFloats(value any) any {
+	return convertNumbers(value, func(literal string) (any, error) {
+		if !strings.ContainsAny(literal, ".eE") {
+			return parseInt(literal)
+		}
+		return strconv.ParseFloat(literal, 64)
+	})
+}
+
+func convertNumbers(value any, parse func(literal string) (any, error)) any {
+	switch v := value.(type) {
+	case json.Number:
+		n, err := parse(string(v))
+		if err != nil {
+			// Numbers out of the float64 range become ±Inf:
+			f, _ := v.Float64()
+			return f
+		}
+		return n
+	case []any:
+		for i := range v {
+			v[i] = convertNumbers(v[i], parse)
+		}
+	case map[string]any:
+		for k := range v {
+			v[k] = convertNumbers(v[k], parse)
+		}
+	}
+
+	return value
+}
+
+// ToFloat64 converts the numbers produced by ParseNumber to
+// float64, returning false for values that are not numbers
+func ToFloat64(value any) (_ float64, isNumber bool) {
+	switch v := value.(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	case *big.Int:
+		f, _ := new(big.Float).SetInt(v).Float64()
+		return f, true
+	}
+
+	return 0, false
+}
+
+// CompareNumbers compares int64, *big.Int and float64 values
+// exactly, returning a negative number if v1 < v2, zero if they
+// are equal and a positive number otherwise.
+//
+// It returns false if any of the values is not a number or is a
+// NaN, since NaNs can't be compared.
+func CompareNumbers(v1 any, v2 any) (_ int, ok bool) {
+	f1, ok := toBigFloat(v1)
+	if !ok {
+		return 0, false
+	}
+
+	f2, ok := toBigFloat(v2)
+	if !ok {
+		return 0, false
+	}
+
+	return f1.Cmp(f2), true
+}
+
+func toBigFloat(value any) (_ *big.Float, ok bool) {
+	switch v := value.(type) {
+	case int64:
+		return new(big.Float).SetInt64(v), true
+	case *big.Int:
+		return new(big.Float).SetInt(v), true
+	case float64:
+		if math.IsNaN(v) {
+			return nil, false
+		}
+		return new(big.Float).SetFloat64(v), true
+	}
+
+	return nil, false
+}